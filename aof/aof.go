@@ -0,0 +1,547 @@
+// Package aof persists the commands applied by a single node to an append-only
+// file, so its state survives a restart without raft.
+//
+// The file starts with a header holding a snapshot of the state and the index
+// of the last command it reflects, followed by a record for every command
+// applied since. Rewriting the file replaces it with a fresh snapshot and the
+// commands applied while the snapshot was written.
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	magic         = "DCAOF1"
+	headerLen     = len(magic) + 8 + 8 // Magic, index and snapshot length
+	recordLen     = 4 + 4 + 8          // Length, checksum and index of a record
+	maxRecordSize = 1 << 30            // Longer records are corrupt
+
+	// DefaultRewriteMinSize is the size the file must reach before it is
+	// rewritten automatically
+	DefaultRewriteMinSize = 64 << 20
+	// DefaultRewriteGrowth is the growth in percent of the file since it was
+	// last rewritten that triggers a rewrite
+	DefaultRewriteGrowth = 100
+)
+
+var (
+	// ErrCorrupt is returned when a record that is not the last one or the
+	// header of the file fails its checksum
+	ErrCorrupt = errors.New("aof: corrupt file")
+	// ErrRewriting is returned when a rewrite is requested while one is running
+	ErrRewriting = errors.New("aof: rewrite in progress")
+	// ErrClosed is returned when appending to a closed file
+	ErrClosed = errors.New("aof: closed")
+)
+
+// FsyncPolicy is when appended commands are flushed to disk
+type FsyncPolicy byte
+
+const (
+	// FsyncEverySec flushes once a second, losing at most a second of commands
+	// if the machine crashes
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways flushes every command before it is acknowledged
+	FsyncAlways
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever
+)
+
+// String returns the name of the policy
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncEverySec:
+		return "everysec"
+	case FsyncNever:
+		return "no"
+	default:
+		return "NONE"
+	}
+}
+
+// ParseFsyncPolicy parses always, everysec or no
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNever, nil
+	default:
+		return 0, fmt.Errorf("aof: unknown fsync policy %q, expected always, everysec or no", s)
+	}
+}
+
+// Options configures an append-only file
+type Options struct {
+	Fsync FsyncPolicy
+
+	// RewriteMinSize is the size below which ShouldRewrite never reports true,
+	// DefaultRewriteMinSize if 0
+	RewriteMinSize int64
+	// RewriteGrowth is the growth in percent since the last rewrite above
+	// which ShouldRewrite reports true, DefaultRewriteGrowth if 0 and never
+	// if negative
+	RewriteGrowth int
+}
+
+// AOF is an append-only file of commands. It is safe for concurrent use,
+// though callers must append commands in the order they were applied.
+type AOF struct {
+	opts Options
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64    // Size of the file
+	base     int64    // Size of the file after it was last rewritten
+	dirty    bool     // Records were written since the last fsync
+	pending  [][]byte // Records appended while a rewrite is running
+	rewrite  chan struct{}
+	err      error // Error of the last rewrite
+	closed   bool
+	stop     chan struct{}
+	stopSync sync.WaitGroup
+}
+
+// Open opens the append-only file at path, creating it if it does not exist.
+// Replay should be called before appending to it, to restore the state the
+// appended commands apply to.
+func Open(path string, opts Options) (*AOF, error) {
+	if opts.RewriteMinSize == 0 {
+		opts.RewriteMinSize = DefaultRewriteMinSize
+	}
+	if opts.RewriteGrowth == 0 {
+		opts.RewriteGrowth = DefaultRewriteGrowth
+	}
+
+	// A new file is moved in place once complete, so it is never found empty
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		tmp := path + ".tmp"
+		if err := writeFile(tmp, 0, nil); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return nil, fmt.Errorf("aof: %w", err)
+		}
+		syncDir(filepath.Dir(path))
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("aof: %w", err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("aof: %w", err)
+	}
+
+	a := &AOF{opts: opts, path: path, file: file, size: size, base: size, stop: make(chan struct{})}
+	if opts.Fsync == FsyncEverySec {
+		a.stopSync.Add(1)
+		go a.syncLoop()
+	}
+	return a, nil
+}
+
+// Replay restores the snapshot of the file with restore, unless the file was
+// never rewritten and has none, then passes every
+// command recorded after it to apply in order, and returns the index of the
+// last one. A record cut short or garbled at the end of the file, as left by
+// a crash in the middle of a write, is dropped and the file truncated before
+// it, so appending continues from the last complete record.
+func (a *AOF) Replay(restore func(r io.Reader) error, apply func(index uint64, cmd []byte) error) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := a.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("aof: %w", err)
+	}
+	if _, err := a.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("aof: %w", err)
+	}
+	r := &countingReader{r: bufio.NewReader(a.file)}
+
+	index, snapshot, err := readHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(snapshot) > 0 {
+		if err := restore(bytes.NewReader(snapshot)); err != nil {
+			return 0, fmt.Errorf("aof: failed to restore snapshot: %w", err)
+		}
+	}
+
+	for {
+		offset := r.n
+		recIndex, cmd, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only the last record may be torn by a crash
+			if errors.Is(err, io.ErrUnexpectedEOF) || (errors.Is(err, ErrCorrupt) && r.n >= info.Size()) {
+				if err := a.file.Truncate(offset); err != nil {
+					return 0, fmt.Errorf("aof: failed to truncate torn record: %w", err)
+				}
+				break
+			}
+			return 0, err
+		}
+		// Records of commands already in the snapshot are skipped
+		if recIndex <= index {
+			continue
+		}
+		if err := apply(recIndex, cmd); err != nil {
+			return 0, fmt.Errorf("aof: failed to apply record %d: %w", recIndex, err)
+		}
+		index = recIndex
+	}
+
+	size, err := a.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("aof: %w", err)
+	}
+	a.size = size
+	a.base = size
+	return index, nil
+}
+
+// Append records the command applied at index, flushing it to disk first if
+// the policy is FsyncAlways
+func (a *AOF) Append(index uint64, cmd []byte) error {
+	rec := encodeRecord(index, cmd)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	if _, err := a.file.Write(rec); err != nil {
+		return fmt.Errorf("aof: %w", err)
+	}
+	a.size += int64(len(rec))
+	if a.rewrite != nil {
+		a.pending = append(a.pending, rec)
+	}
+
+	if a.opts.Fsync == FsyncAlways {
+		if err := a.file.Sync(); err != nil {
+			return fmt.Errorf("aof: %w", err)
+		}
+		return nil
+	}
+	a.dirty = true
+	return nil
+}
+
+// Size returns the size of the file
+func (a *AOF) Size() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// ShouldRewrite reports whether the file grew enough since it was last
+// rewritten to be rewritten, and no rewrite is running
+func (a *AOF) ShouldRewrite() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewrite != nil || a.opts.RewriteGrowth < 0 || a.size < a.opts.RewriteMinSize {
+		return false
+	}
+	return a.size >= a.base+a.base*int64(a.opts.RewriteGrowth)/100
+}
+
+// Rewrite replaces the file in the background with a snapshot reflecting the
+// commands up to index, written by persist, followed by the commands appended
+// from now on. The caller must not append a command between taking the
+// snapshot and calling Rewrite.
+func (a *AOF) Rewrite(index uint64, persist func(w io.Writer) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	if a.rewrite != nil {
+		return ErrRewriting
+	}
+	a.rewrite = make(chan struct{})
+	a.pending = nil
+	go a.runRewrite(index, persist, a.rewrite)
+	return nil
+}
+
+// WaitRewrite waits for the running rewrite, if any, and returns the error of
+// the last one
+func (a *AOF) WaitRewrite() error {
+	a.mu.Lock()
+	done := a.rewrite
+	a.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// runRewrite writes the snapshot to a temporary file, then appends the
+// commands recorded meanwhile and swaps it with the file
+func (a *AOF) runRewrite(index uint64, persist func(w io.Writer) error, done chan struct{}) {
+	tmp := a.path + ".rewrite"
+	err := writeFile(tmp, index, persist)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer close(done)
+	a.rewrite = nil
+	if err == nil {
+		err = a.swap(tmp)
+	}
+	a.pending = nil
+	if err != nil {
+		os.Remove(tmp)
+		a.err = fmt.Errorf("aof: rewrite failed: %w", err)
+		return
+	}
+	a.err = nil
+}
+
+// swap appends the pending records to the rewritten file at tmp and moves it
+// over the file. The AOF must be locked.
+func (a *AOF) swap(tmp string) error {
+	if a.closed {
+		return ErrClosed
+	}
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	for _, rec := range a.pending {
+		if _, err := file.Write(rec); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		file.Close()
+		return err
+	}
+	syncDir(filepath.Dir(a.path))
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file.Close()
+	a.file = file
+	a.size = size
+	a.base = size
+	a.dirty = false
+	return nil
+}
+
+// syncLoop flushes the file once a second until it is closed
+func (a *AOF) syncLoop() {
+	defer a.stopSync.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.dirty {
+				a.file.Sync()
+				a.dirty = false
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Close waits for the running rewrite, flushes and closes the file
+func (a *AOF) Close() error {
+	a.WaitRewrite()
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.stop)
+	a.mu.Unlock()
+	a.stopSync.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.file.Sync()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("aof: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes a file at path holding the snapshot written by persist,
+// nil for an empty one, reflecting the commands up to index
+func writeFile(path string, index uint64, persist func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("aof: %w", err)
+	}
+	defer file.Close()
+
+	// The snapshot length is filled in once it is written
+	header := make([]byte, headerLen)
+	copy(header, magic)
+	binary.LittleEndian.PutUint64(header[len(magic):], index)
+	if _, err := file.Write(header); err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[len(magic) : len(magic)+8])
+	w := &countingWriter{w: io.MultiWriter(file, crc)}
+	if persist != nil {
+		if err := persist(w); err != nil {
+			return fmt.Errorf("failed to persist snapshot: %w", err)
+		}
+	}
+	if err := binary.Write(file, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(w.n))
+	if _, err := file.WriteAt(length[:], int64(len(magic)+8)); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// readHeader reads the index and snapshot at the start of a file
+func readHeader(r io.Reader) (uint64, []byte, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("%w: short header", ErrCorrupt)
+	}
+	if string(header[:len(magic)]) != magic {
+		return 0, nil, fmt.Errorf("%w: not an append-only file", ErrCorrupt)
+	}
+	index := binary.LittleEndian.Uint64(header[len(magic):])
+	length := binary.LittleEndian.Uint64(header[len(magic)+8:])
+
+	snapshot := make([]byte, 0, min(length, maxRecordSize))
+	buf := bytes.NewBuffer(snapshot)
+	if _, err := io.CopyN(buf, r, int64(length)); err != nil {
+		return 0, nil, fmt.Errorf("%w: short snapshot", ErrCorrupt)
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+		return 0, nil, fmt.Errorf("%w: short snapshot", ErrCorrupt)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[len(magic) : len(magic)+8])
+	crc.Write(buf.Bytes())
+	if crc.Sum32() != sum {
+		return 0, nil, fmt.Errorf("%w: snapshot checksum mismatch", ErrCorrupt)
+	}
+	return index, buf.Bytes(), nil
+}
+
+// encodeRecord encodes the command applied at index as a record
+func encodeRecord(index uint64, cmd []byte) []byte {
+	rec := make([]byte, recordLen+len(cmd))
+	binary.LittleEndian.PutUint32(rec, uint32(len(cmd)))
+	binary.LittleEndian.PutUint64(rec[8:], index)
+	copy(rec[recordLen:], cmd)
+	binary.LittleEndian.PutUint32(rec[4:], crc32.ChecksumIEEE(rec[8:]))
+	return rec
+}
+
+// readRecord reads a record. It returns io.EOF at the end of the file and
+// io.ErrUnexpectedEOF if the record is cut short.
+func readRecord(r io.Reader) (uint64, []byte, error) {
+	head := make([]byte, recordLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(head)
+	if length > maxRecordSize {
+		return 0, nil, fmt.Errorf("%w: record of %d bytes", ErrCorrupt, length)
+	}
+	cmd := make([]byte, length)
+	if _, err := io.ReadFull(r, cmd); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(head[8:])
+	crc.Write(cmd)
+	if crc.Sum32() != binary.LittleEndian.Uint32(head[4:]) {
+		return 0, nil, fmt.Errorf("%w: record checksum mismatch", ErrCorrupt)
+	}
+	return binary.LittleEndian.Uint64(head[8:]), cmd, nil
+}
+
+// syncDir flushes a directory so a file renamed into it survives a crash
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,239 @@
+package aof
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// state is a map of keys set by commands of the form key=value
+type state struct {
+	mu    sync.Mutex
+	items map[string]string
+	index uint64
+}
+
+func newState() *state {
+	return &state{items: make(map[string]string)}
+}
+
+func (s *state) restore(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil || len(b) == 0 {
+		return err
+	}
+	return json.Unmarshal(b, &s.items)
+}
+
+func (s *state) apply(index uint64, cmd []byte) error {
+	key, value, _ := strings.Cut(string(cmd), "=")
+	s.mu.Lock()
+	s.items[key] = value
+	s.index = index
+	s.mu.Unlock()
+	return nil
+}
+
+// set applies a command and appends it, as a node serving it would
+func (s *state) set(t *testing.T, a *AOF, key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index++
+	s.items[key] = value
+	assert.Nil(t, a.Append(s.index, []byte(key+"="+value)))
+}
+
+// replay opens the file at path and replays it into a new state
+func replay(t *testing.T, path string) (*AOF, *state, error) {
+	a, err := Open(path, Options{Fsync: FsyncNever})
+	assert.Nil(t, err)
+	t.Cleanup(func() { a.Close() })
+	s := newState()
+	index, err := a.Replay(s.restore, s.apply)
+	s.index = index
+	return a, s, err
+}
+
+// TestAppendReplay tests that appended records replay in order with their
+// last index
+func TestAppendReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	s.set(t, a, "foo", "1")
+	s.set(t, a, "bar", "2")
+	s.set(t, a, "foo", "3")
+	assert.Nil(t, a.Close())
+
+	_, replayed, err := replay(t, path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "3", "bar": "2"}, replayed.items)
+	assert.Equal(t, uint64(3), replayed.index)
+}
+
+// TestReplayTruncatedRecord tests that a file cut anywhere in its last
+// record, as a crash would leave it, replays the records before it and
+// accepts new ones
+func TestReplayTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	s.set(t, a, "foo", "1")
+	before := a.Size()
+	s.set(t, a, "bar", "2")
+	full := a.Size()
+	assert.Nil(t, a.Close())
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	for cut := before; cut < full; cut++ {
+		t.Run(fmt.Sprint(cut), func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("cut-%d.aof", cut))
+			assert.Nil(t, os.WriteFile(path, data[:cut], 0o644))
+
+			a, s, err := replay(t, path)
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]string{"foo": "1"}, s.items)
+			assert.Equal(t, before, a.Size())
+
+			s.set(t, a, "baz", "3")
+			assert.Nil(t, a.Close())
+			_, s, err = replay(t, path)
+			assert.Nil(t, err)
+			assert.Equal(t, map[string]string{"foo": "1", "baz": "3"}, s.items)
+		})
+	}
+}
+
+// TestReplayTornLastRecord tests that a last record failing its checksum is
+// dropped as torn by a crash
+func TestReplayTornLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	s.set(t, a, "foo", "1")
+	s.set(t, a, "bar", "2")
+	assert.Nil(t, a.Close())
+
+	// The last byte of the file was never written
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	_, s, err = replay(t, path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "1"}, s.items)
+}
+
+// TestReplayCorruptRecord tests that a record failing its checksum before
+// the last one stops the replay with ErrCorrupt
+func TestReplayCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	s.set(t, a, "foo", "1")
+	s.set(t, a, "bar", "2")
+	assert.Nil(t, a.Close())
+
+	// A record followed by another is not torn by a crash
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)-recordLen-len("bar=2")-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	_, _, err = replay(t, path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+// TestRewrite tests that a rewrite shrinks the file while keeping the
+// commands appended during it
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		s.set(t, a, "foo", fmt.Sprint(i))
+	}
+	large := a.Size()
+
+	// Commands appended while the snapshot is written are kept
+	release := make(chan struct{})
+	s.mu.Lock()
+	snapshot, err := json.Marshal(s.items)
+	assert.Nil(t, err)
+	assert.Nil(t, a.Rewrite(s.index, func(w io.Writer) error {
+		<-release
+		_, err := w.Write(snapshot)
+		return err
+	}))
+	s.mu.Unlock()
+	assert.ErrorIs(t, a.Rewrite(s.index, nil), ErrRewriting)
+	s.set(t, a, "bar", "1")
+	close(release)
+	assert.Nil(t, a.WaitRewrite())
+	s.set(t, a, "baz", "2")
+	assert.Less(t, a.Size(), large)
+	assert.Nil(t, a.Close())
+
+	_, replayed, err := replay(t, path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "99", "bar": "1", "baz": "2"}, replayed.items)
+	assert.Equal(t, uint64(102), replayed.index)
+}
+
+// TestRewriteFailureKeepsFile tests that a failed rewrite leaves the file
+// appendable and replayable
+func TestRewriteFailureKeepsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, s, err := replay(t, path)
+	assert.Nil(t, err)
+	s.set(t, a, "foo", "1")
+
+	assert.Nil(t, a.Rewrite(s.index, func(io.Writer) error { return io.ErrShortWrite }))
+	assert.ErrorIs(t, a.WaitRewrite(), io.ErrShortWrite)
+	s.set(t, a, "bar", "2")
+	assert.Nil(t, a.Close())
+
+	_, replayed, err := replay(t, path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"foo": "1", "bar": "2"}, replayed.items)
+}
+
+// TestShouldRewrite tests that a rewrite is due once the file reaches its
+// minimum size
+func TestShouldRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	a, err := Open(path, Options{Fsync: FsyncAlways, RewriteMinSize: 100, RewriteGrowth: 100})
+	assert.Nil(t, err)
+	defer a.Close()
+	_, err = a.Replay(func(io.Reader) error { return nil }, func(uint64, []byte) error { return nil })
+	assert.Nil(t, err)
+
+	var index uint64
+	for !a.ShouldRewrite() {
+		index++
+		assert.Nil(t, a.Append(index, []byte("foo=bar")))
+	}
+	assert.GreaterOrEqual(t, a.Size(), int64(100))
+}
+
+// TestParseFsyncPolicy tests that every fsync policy parses from its name and
+// unknown names are rejected
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, policy := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNever} {
+		parsed, err := ParseFsyncPolicy(policy.String())
+		assert.Nil(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+	_, err := ParseFsyncPolicy("sometimes")
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,11 @@
 package cache
 
 import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,66 +16,272 @@ import (
 type CacheOpts struct {
 	Capacity int
 	TTL      time.Duration
+	// MaxBytes bounds the approximate memory used by keys and values, 0 means unlimited
+	MaxBytes int64
 	OnEvict  func(key string, value []byte)
+	// Loader loads the items Fetch misses or refreshes, nil disables Fetch
+	Loader Loader
+	// Grace keeps items this long past their TTL, so Fetch serves them stale
+	// while a refresh runs
+	Grace time.Duration
+	// Beta weighs how early Fetch refreshes items before their TTL elapsed,
+	// 0 disables early refreshes and 1 is a good default
+	Beta float64
+	// HotKeys is the number of keys the hot key sketch monitors, 0 disables it
+	HotKeys int
+	// HotKeySampling counts one in that many accesses in the sketch, 0 or 1
+	// counting every access
+	HotKeySampling int
+}
+
+// RemoveReason describes why an item was removed from the cache
+type RemoveReason int
+
+const (
+	// RemoveEvicted means the item was dropped to make room for another one
+	RemoveEvicted RemoveReason = iota
+	// RemoveExpired means the TTL of the item elapsed
+	RemoveExpired
+)
+
+// RemoveListener is called when an item is evicted or expires. It runs with
+// the cache locked and must not call back into the cache.
+type RemoveListener func(key string, value []byte, reason RemoveReason)
+
+// Condition is a guard evaluated against the current item before a write
+type Condition int
+
+const (
+	// CondNone always allows the write
+	CondNone Condition = iota
+	// CondVersion requires the item to exist with the expected version
+	CondVersion
+	// CondAbsent requires the item not to exist
+	CondAbsent
+	// CondPresent requires the item to exist
+	CondPresent
+)
+
+// WriteOpts controls how an item is written
+type WriteOpts struct {
+	// TTL after which the item expires, 0 uses the cache TTL
+	TTL time.Duration
+	// At is the time the item expires, overriding TTL unless zero
+	At time.Time
+	// Version assigned to the item, 0 picks the next local version
+	Version uint64
+	// Cond guards the write, with Expected as the version for CondVersion
+	Cond     Condition
+	Expected uint64
+	// Tags the item can be invalidated by
+	Tags []string
+	// Grace the item is kept stale past its TTL, 0 uses the cache grace
+	Grace time.Duration
+}
+
+// Item is a value stored in the cache along with the version that wrote it
+type Item struct {
+	Value   []byte
+	Version uint64
+}
+
+// TxnCheck is a condition on a key evaluated before a transaction applies
+type TxnCheck struct {
+	Key      []byte
+	Cond     Condition
+	Expected uint64
+}
+
+// TxnOp is a write applied by a transaction. Value, TTL and At are ignored by deletes.
+type TxnOp struct {
+	Delete bool
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+	At     time.Time // Time the item expires, overriding TTL unless zero
+}
+
+// entry is an item and its bookkeeping. Only the field matching kind is set.
+type entry struct {
+	kind    Kind
+	value   []byte              // KindString
+	hash    map[string][]byte   // KindHash
+	list    [][]byte            // KindList
+	set     map[string]struct{} // KindSet
+	zset    map[string]float64  // KindZSet
+	version uint64
+	expiry  time.Time     // Zero time means the item never expires
+	hard    time.Time     // Time Fetch stops serving the expired item stale, zero without a grace period
+	sliding time.Duration // Window Touch extends the expiry to, 0 for a fixed expiry
+	delta   time.Duration // Time the loader took to load the item
+	tags    []string
+	size    int64 // Approximate memory used by the key, value and tags
 }
 
 // Cache is an in-memory key-value store with a fixed capacity and TTL
 type Cache struct {
 	CacheOpts
-	items                   map[string][]byte
+	items                   map[string]*entry
 	order                   []string // Slice to maintain the LRU order
 	mu                      sync.RWMutex
 	hits, misses, evictions int
-	timestamps              map[string]time.Time
+	memoryEvictions         int // Evictions for the memory limit, included in evictions
+	expirations             int
+	bytes                   int64                          // Approximate memory used by every entry
+	version                 uint64                         // Highest version assigned so far
+	tags                    map[string]map[string]struct{} // Keys carrying every tag
+	listeners               []RemoveListener
+	loads                   map[string]*load // Loads in flight
+	random                  func() float64   // Source of the early refresh probability
+	hot                     *hotKeys         // Sketch of the most accessed keys, nil if disabled
 }
 
+// Ensure Cache satisfies the Cacher interface
+var _ Cacher = (*Cache)(nil)
+
 // NewCache creates a new cache with the specified capacity, TTL, and eviction callback
 func NewCache(opts CacheOpts) *Cache {
 	return &Cache{
-		CacheOpts:  opts,
-		items:      make(map[string][]byte),
-		order:      []string{},
-		timestamps: make(map[string]time.Time),
+		CacheOpts: opts,
+		items:     make(map[string]*entry),
+		order:     []string{},
+		tags:      make(map[string]map[string]struct{}),
+		loads:     make(map[string]*load),
+		random:    rand.Float64,
+		hot:       newHotKeys(opts.HotKeys, opts.HotKeySampling),
 	}
 }
 
 // Get retrieves an item from the cache and updates its usage
 func (c *Cache) Get(key []byte) ([]byte, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	item, err := c.GetItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// GetItem retrieves an item and its version from the cache and updates its usage
+func (c *Cache) GetItem(key []byte) (Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	strKey := string(key)
+	c.hot.record(strKey)
 
-	if value, found := c.items[strKey]; found {
-		if c.CacheOpts.TTL > 0 && time.Since(c.timestamps[strKey]) > c.CacheOpts.TTL {
-			c.mu.RUnlock()
-			c.mu.Lock()
-			c.remove(strKey) // Expire the item if TTL has elapsed
-			c.mu.Unlock()
-			c.mu.RLock()
+	if e, found := c.items[strKey]; found {
+		if e.expired() {
+			if e.dead() {
+				c.remove(strKey, RemoveExpired) // Expire the item if TTL and grace have elapsed
+			}
 			c.misses++
-			return nil, &util.ExpiredKeyError{Key: strKey}
+			return Item{}, &util.ExpiredKeyError{Key: strKey}
+		}
+		if e.kind != KindString {
+			return Item{}, &util.WrongTypeError{Key: strKey}
 		}
 		c.hits++
 		c.updateOrder(strKey) // Move the accessed key to the end of the order slice
-		return value, nil
+		return Item{Value: e.value, Version: e.version}, nil
 	}
 	c.misses++
-	return nil, &util.KeyNotFoundError{Key: strKey}
+	return Item{}, &util.KeyNotFoundError{Key: strKey}
+}
+
+// Peek retrieves an item and its version from the cache without updating its
+// usage, the hot keys or the statistics, and without expiring it, so reads
+// served by a replica leave its state to the entries raft applies
+func (c *Cache) Peek(key []byte) (Item, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	strKey := string(key)
+	e, found := c.items[strKey]
+	if !found {
+		return Item{}, &util.KeyNotFoundError{Key: strKey}
+	}
+	if e.expired() {
+		return Item{}, &util.ExpiredKeyError{Key: strKey}
+	}
+	if e.kind != KindString {
+		return Item{}, &util.WrongTypeError{Key: strKey}
+	}
+	return Item{Value: e.value, Version: e.version}, nil
 }
 
-// Put inserts an item into the cache and updates its usage
-func (c *Cache) Put(key, value []byte) error {
+// Put inserts an item into the cache and updates its usage. The item expires
+// after ttl, or after the cache TTL when ttl is 0.
+func (c *Cache) Put(key, value []byte, ttl time.Duration) error {
+	_, err := c.Set(key, value, WriteOpts{TTL: ttl})
+	return err
+}
+
+// Set inserts an item into the cache if its condition holds and returns the
+// version it was written with. A failed condition returns a ConflictError.
+func (c *Cache) Set(key, value []byte, opts WriteOpts) (uint64, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	strKey := string(key)
+	if err := c.check(strKey, opts.Cond, opts.Expected); err != nil {
+		return 0, err
+	}
+	return c.put(strKey, value, opts), nil
+}
 
-	if _, found := c.items[strKey]; found {
-		c.items[strKey] = value
-		c.timestamps[strKey] = time.Now()
-		c.updateOrder(strKey)
-		return nil
+// put inserts a string item, replacing an item of any kind
+func (c *Cache) put(key string, value []byte, opts WriteOpts) uint64 {
+	c.hot.record(key)
+	e := c.newEntry(KindString, opts)
+	e.value = value
+	c.insert(key, e)
+	c.resize(key, e, int64(len(value)))
+	return e.version
+}
+
+// newEntry creates an empty entry of kind expiring at the time of opts, or
+// after the TTL of opts or the cache
+func (c *Cache) newEntry(kind Kind, opts WriteOpts) *entry {
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = c.CacheOpts.TTL
+	}
+	e := &entry{
+		kind:    kind,
+		version: c.nextVersion(opts.Version),
+		tags:    slices.Clone(opts.Tags),
+	}
+	grace := opts.Grace
+	if grace == 0 {
+		grace = c.CacheOpts.Grace
+	}
+	if !opts.At.IsZero() {
+		e.expiry = opts.At
+	} else if ttl > 0 {
+		e.expiry = time.Now().Add(ttl)
+	}
+	if !e.expiry.IsZero() && grace > 0 {
+		e.hard = e.expiry.Add(grace)
+	}
+	return e
+}
+
+// insert stores an entry holding only its key, evicting the least recently
+// used item if the cache is full
+func (c *Cache) insert(key string, e *entry) {
+	e.size = int64(len(key))
+	for _, tag := range e.tags {
+		e.size += int64(len(tag))
+	}
+	c.bytes += e.size
+
+	if old, found := c.items[key]; found {
+		c.bytes -= old.size
+		c.untag(key, old)
+		c.tag(key, e)
+		c.items[key] = e
+		c.updateOrder(key)
+		return
 	}
 
 	// Evict the least recently used item if capacity is reached
@@ -78,10 +289,86 @@ func (c *Cache) Put(key, value []byte) error {
 		c.evict()
 	}
 
-	c.items[strKey] = value
-	c.timestamps[strKey] = time.Now()
-	c.order = append(c.order, strKey) // Add key to the end of order slice
-	return nil
+	c.tag(key, e)
+	c.items[key] = e
+	c.order = append(c.order, key) // Add key to the end of order slice
+}
+
+// resize accounts for delta bytes used by the entry of key, then evicts the
+// least recently used items other than key while over the memory limit
+func (c *Cache) resize(key string, e *entry, delta int64) {
+	e.size += delta
+	c.bytes += delta
+
+	for c.CacheOpts.MaxBytes > 0 && c.bytes > c.CacheOpts.MaxBytes && len(c.order) > 0 && c.order[0] != key {
+		c.evict()
+		c.memoryEvictions++
+	}
+}
+
+// Incr adds delta to the integer stored at key and returns the new value. A
+// missing or expired key is created holding initial before delta is added and
+// expires after opts.TTL; an existing key keeps its expiry.
+func (c *Cache) Incr(key []byte, delta, initial int64, opts WriteOpts) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	c.hot.record(strKey)
+
+	e := c.live(strKey)
+	current := initial
+	if e != nil {
+		if e.kind != KindString {
+			return 0, &util.WrongTypeError{Key: strKey}
+		}
+		n, err := strconv.ParseInt(string(e.value), 10, 64)
+		if err != nil {
+			return 0, &util.NotIntegerError{Key: strKey}
+		}
+		current = n
+	}
+
+	next := current + delta
+	if (delta > 0 && next < current) || (delta < 0 && next > current) {
+		return 0, &util.OverflowError{Key: strKey}
+	}
+
+	encoded := []byte(strconv.FormatInt(next, 10))
+	if e != nil {
+		delta := int64(len(encoded) - len(e.value))
+		e.value = encoded
+		e.version = c.nextVersion(opts.Version)
+		c.updateOrder(strKey)
+		c.resize(strKey, e, delta)
+	} else {
+		c.put(strKey, encoded, opts)
+	}
+	return next, nil
+}
+
+// Txn applies ops in order if every check holds and returns the version given
+// to the items it wrote, version or the next local version when 0. The first
+// failed check returns a ConflictError and nothing is written.
+func (c *Cache) Txn(checks []TxnCheck, ops []TxnOp, version uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, check := range checks {
+		if err := c.check(string(check.Key), check.Cond, check.Expected); err != nil {
+			return 0, err
+		}
+	}
+
+	version = c.nextVersion(version)
+	for _, op := range ops {
+		if op.Delete {
+			c.unlink(string(op.Key))
+			continue
+		}
+		c.put(string(op.Key), op.Value, WriteOpts{TTL: op.TTL, At: op.At, Version: version})
+	}
+	return version, nil
 }
 
 // Has checks if a key exists in the cache
@@ -89,14 +376,101 @@ func (c *Cache) Has(key []byte) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	if e, found := c.items[string(key)]; found {
+		return !e.expired()
+	}
+	return false
+}
+
+// Delete removes a key from the cache without invoking the eviction callback.
+// It reports whether the key was present.
+func (c *Cache) Delete(key []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, found := c.unlink(string(key))
+	return found
+}
+
+// DeleteIf removes a key from the cache if cond holds, with expected as the
+// version for CondVersion. A failed condition returns a ConflictError and a
+// missing key a KeyNotFoundError.
+func (c *Cache) DeleteIf(key []byte, cond Condition, expected uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	strKey := string(key)
-	if _, found := c.items[strKey]; found {
-		if c.CacheOpts.TTL > 0 && time.Since(c.timestamps[strKey]) > c.CacheOpts.TTL {
-			return false
+	if err := c.check(strKey, cond, expected); err != nil {
+		return err
+	}
+	if _, found := c.unlink(strKey); !found {
+		return &util.KeyNotFoundError{Key: strKey}
+	}
+	return nil
+}
+
+// InvalidateTag deletes every key carrying tag without invoking the eviction
+// callback and returns the deleted keys in lexical order
+func (c *Cache) InvalidateTag(tag string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := slices.Sorted(maps.Keys(c.tags[tag]))
+	for _, key := range keys {
+		c.unlink(key)
+	}
+	return keys
+}
+
+// InvalidatePrefix deletes every key starting with prefix without invoking
+// the eviction callback and returns the deleted keys in lexical order
+func (c *Cache) InvalidatePrefix(prefix []byte) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := []string{}
+	for key := range c.items {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
 		}
-		return true
 	}
-	return false
+	slices.Sort(keys)
+	for _, key := range keys {
+		c.unlink(key)
+	}
+	return keys
+}
+
+// Clear removes every item from the cache without invoking the eviction callback
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*entry)
+	c.order = []string{}
+	c.tags = make(map[string]map[string]struct{})
+	c.bytes = 0
+}
+
+// Len returns the number of items in the cache, including expired items not yet removed
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Bytes returns the approximate memory used by the keys and values in the cache
+func (c *Cache) Bytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.bytes
+}
+
+// AddRemoveListener registers fn to be called whenever an item is evicted or expires
+func (c *Cache) AddRemoveListener(fn RemoveListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
 }
 
 // Stats returns the cache hit, miss, and eviction counts
@@ -106,30 +480,171 @@ func (c *Cache) Stats() (hits, misses, evictions int) {
 	return c.hits, c.misses, c.evictions
 }
 
+// Metrics is a point in time copy of the counters and size of a cache
+type Metrics struct {
+	Hits   int
+	Misses int
+	// EvictedCapacity and EvictedMemory count the items evicted to stay within
+	// the capacity and the memory limit
+	EvictedCapacity int
+	EvictedMemory   int
+	Expired         int
+	Items           int // Items including expired items not yet removed
+	Bytes           int64
+}
+
+// Metrics returns the counters and size of the cache
+func (c *Cache) Metrics() Metrics {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Metrics{
+		Hits:            c.hits,
+		Misses:          c.misses,
+		EvictedCapacity: c.evictions - c.memoryEvictions,
+		EvictedMemory:   c.memoryEvictions,
+		Expired:         c.expirations,
+		Items:           len(c.items),
+		Bytes:           c.bytes,
+	}
+}
+
+// expired reports whether the TTL of an entry has elapsed
+func (e *entry) expired() bool {
+	return !e.expiry.IsZero() && time.Now().After(e.expiry)
+}
+
+// dead reports whether the TTL of an entry and its grace period have
+// elapsed, so not even Fetch serves it anymore
+func (e *entry) dead() bool {
+	return e.expired() && (e.hard.IsZero() || time.Now().After(e.hard))
+}
+
+// live returns the entry of key, nil if its TTL has elapsed. An entry past
+// its grace period too is expired first.
+func (c *Cache) live(key string) *entry {
+	e, found := c.items[key]
+	if !found {
+		return nil
+	}
+	if e.expired() {
+		if e.dead() {
+			c.remove(key, RemoveExpired)
+		}
+		return nil
+	}
+	return e
+}
+
+// servable returns the entry of key for Fetch, which serves it stale past its
+// TTL until its grace period elapsed too, expiring it then
+func (c *Cache) servable(key string) *entry {
+	e, found := c.items[key]
+	if !found {
+		return nil
+	}
+	if e.dead() {
+		c.remove(key, RemoveExpired)
+		return nil
+	}
+	return e
+}
+
+// check evaluates a write condition against the current item
+func (c *Cache) check(key string, cond Condition, expected uint64) error {
+	if cond == CondNone {
+		return nil
+	}
+
+	e := c.live(key)
+	switch {
+	case cond == CondAbsent && e != nil,
+		cond == CondPresent && e == nil,
+		cond == CondVersion && (e == nil || e.version != expected):
+		current := uint64(0)
+		if e != nil {
+			current = e.version
+		}
+		return &util.ConflictError{Key: key, Version: current}
+	}
+	return nil
+}
+
+// nextVersion records and returns the version for a write. Writes replicated
+// through raft pass their log index; local writes get the next local version.
+func (c *Cache) nextVersion(version uint64) uint64 {
+	if version == 0 {
+		version = c.version + 1
+	}
+	if version > c.version {
+		c.version = version
+	}
+	return version
+}
+
 // evict removes the least recently used item from the cache
 func (c *Cache) evict() {
 	if len(c.order) == 0 {
 		return
 	}
 	oldestKey := c.order[0]
-	c.remove(oldestKey)
+	c.remove(oldestKey, RemoveEvicted)
 	c.evictions++
 }
 
-// remove deletes an item from the cache
-func (c *Cache) remove(key string) {
-	if value, found := c.items[key]; found {
-		delete(c.items, key)
-		delete(c.timestamps, key)
-		if c.CacheOpts.OnEvict != nil {
-			c.CacheOpts.OnEvict(key, value)
+// remove deletes an item from the cache and invokes the eviction callback and remove listeners
+func (c *Cache) remove(key string, reason RemoveReason) {
+	value, found := c.unlink(key)
+	if !found {
+		return
+	}
+	if reason == RemoveExpired {
+		c.expirations++
+	}
+	if c.CacheOpts.OnEvict != nil {
+		c.CacheOpts.OnEvict(key, value)
+	}
+	for _, fn := range c.listeners {
+		fn(key, value, reason)
+	}
+}
+
+// unlink deletes an item and its bookkeeping from the cache
+func (c *Cache) unlink(key string) ([]byte, bool) {
+	e, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	delete(c.items, key)
+	c.untag(key, e)
+	c.bytes -= e.size
+	// Remove the key from the order slice
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
 		}
-		// Remove the key from the order slice
-		for i, k := range c.order {
-			if k == key {
-				c.order = append(c.order[:i], c.order[i+1:]...)
-				break
-			}
+	}
+	return e.value, true
+}
+
+// tag adds a key to the index of every tag of its entry
+func (c *Cache) tag(key string, e *entry) {
+	for _, tag := range e.tags {
+		keys, found := c.tags[tag]
+		if !found {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untag removes a key from the index of every tag of its entry
+func (c *Cache) untag(key string, e *entry) {
+	for _, tag := range e.tags {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
 		}
 	}
 }
@@ -0,0 +1,253 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIncrConcurrent tests that concurrent increments are not lost
+func TestIncrConcurrent(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Incr([]byte("hits"), 1, 0, WriteOpts{})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	value, err := c.Get([]byte("hits"))
+	assert.Nil(t, err)
+	assert.Equal(t, "100", string(value))
+}
+
+// TestIncrInitialAndTTL tests that a missing counter is created from the initial value with its TTL
+func TestIncrInitialAndTTL(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+
+	value, err := c.Incr([]byte("quota"), -1, 10, WriteOpts{TTL: 20 * time.Millisecond})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), value)
+
+	// The TTL is only applied on create
+	value, err = c.Incr([]byte("quota"), -1, 10, WriteOpts{TTL: time.Hour})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), value)
+
+	time.Sleep(30 * time.Millisecond)
+	value, err = c.Incr([]byte("quota"), -1, 10, WriteOpts{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), value)
+}
+
+// TestIncrNotInteger tests that incrementing a non-integer value fails
+func TestIncrNotInteger(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	assert.Nil(t, c.Put([]byte("name"), []byte("foo"), 0))
+
+	_, err := c.Incr([]byte("name"), 1, 0, WriteOpts{})
+	assert.IsType(t, &util.NotIntegerError{}, err)
+}
+
+// TestConditionalWrites tests versioned and presence conditions on writes and deletes
+func TestConditionalWrites(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	key := []byte("foo")
+
+	_, err := c.Set(key, []byte("v0"), WriteOpts{Cond: CondPresent})
+	assert.IsType(t, &util.ConflictError{}, err)
+
+	v1, err := c.Set(key, []byte("v1"), WriteOpts{Cond: CondAbsent, Version: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), v1)
+
+	_, err = c.Set(key, []byte("v1"), WriteOpts{Cond: CondAbsent})
+	assert.Equal(t, &util.ConflictError{Key: "foo", Version: 5}, err)
+
+	_, err = c.Set(key, []byte("v2"), WriteOpts{Cond: CondVersion, Expected: 4})
+	assert.IsType(t, &util.ConflictError{}, err)
+
+	v2, err := c.Set(key, []byte("v2"), WriteOpts{Cond: CondVersion, Expected: v1})
+	assert.Nil(t, err)
+	assert.Greater(t, v2, v1)
+
+	item, err := c.GetItem(key)
+	assert.Nil(t, err)
+	assert.Equal(t, Item{Value: []byte("v2"), Version: v2}, item)
+
+	assert.IsType(t, &util.ConflictError{}, c.DeleteIf(key, CondVersion, v1))
+	assert.Nil(t, c.DeleteIf(key, CondVersion, v2))
+	assert.False(t, c.Has(key))
+}
+
+// TestTxnAllOrNothing tests that a transaction with a failed check writes nothing
+func TestTxnAllOrNothing(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	v1, err := c.Set([]byte("user:1"), []byte("old@b.c"), WriteOpts{})
+	assert.Nil(t, err)
+	c.Put([]byte("email:old@b.c"), []byte("user:1"), 0)
+
+	ops := []TxnOp{
+		{Key: []byte("user:1"), Value: []byte("new@b.c")},
+		{Key: []byte("email:new@b.c"), Value: []byte("user:1")},
+		{Delete: true, Key: []byte("email:old@b.c")},
+	}
+
+	_, err = c.Txn([]TxnCheck{{Key: []byte("user:1"), Cond: CondVersion, Expected: v1 + 1}}, ops, 0)
+	assert.Equal(t, &util.ConflictError{Key: "user:1", Version: v1}, err)
+	value, _ := c.Get([]byte("user:1"))
+	assert.Equal(t, []byte("old@b.c"), value)
+	assert.False(t, c.Has([]byte("email:new@b.c")))
+	assert.True(t, c.Has([]byte("email:old@b.c")))
+
+	version, err := c.Txn([]TxnCheck{{Key: []byte("user:1"), Cond: CondVersion, Expected: v1}}, ops, 42)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), version)
+	item, _ := c.GetItem([]byte("email:new@b.c"))
+	assert.Equal(t, Item{Value: []byte("user:1"), Version: 42}, item)
+	assert.False(t, c.Has([]byte("email:old@b.c")))
+}
+
+// TestTxnContention tests that optimistic transactions racing on the same keys
+// never lose an update and keep the keys they write consistent
+func TestTxnContention(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	c.Put([]byte("a"), []byte("0"), 0)
+	c.Put([]byte("b"), []byte("0"), 0)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conflicts := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; {
+				item, err := c.GetItem([]byte("a"))
+				assert.Nil(t, err)
+				count, _ := strconv.Atoi(string(item.Value))
+				next := []byte(strconv.Itoa(count + 1))
+
+				_, err = c.Txn(
+					[]TxnCheck{{Key: []byte("a"), Cond: CondVersion, Expected: item.Version}},
+					[]TxnOp{{Key: []byte("a"), Value: next}, {Key: []byte("b"), Value: next}},
+					0,
+				)
+				if err != nil {
+					assert.IsType(t, &util.ConflictError{}, err)
+					mu.Lock()
+					conflicts++
+					mu.Unlock()
+					continue
+				}
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, _ := c.GetItem([]byte("a"))
+	b, _ := c.GetItem([]byte("b"))
+	assert.Equal(t, []byte("800"), a.Value)
+	assert.Equal(t, a, b)
+	t.Logf("%d conflicts retried", conflicts)
+}
+
+// TestInvalidateTag tests deleting keys by tag and keeping the tag index
+// clean when items are replaced, evicted or expire
+func TestInvalidateTag(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 3})
+	c.Set([]byte("product:1"), []byte("a"), WriteOpts{Tags: []string{"p1"}})
+	c.Set([]byte("list:shoes"), []byte("b"), WriteOpts{Tags: []string{"p1", "p2"}})
+	c.Set([]byte("list:hats"), []byte("c"), WriteOpts{Tags: []string{"p2"}})
+
+	// Replacing an item replaces its tags
+	c.Set([]byte("list:hats"), []byte("c"), WriteOpts{})
+	// Evicting an item drops it from the index
+	c.Set([]byte("other"), []byte("d"), WriteOpts{Tags: []string{"p2"}})
+	assert.False(t, c.Has([]byte("product:1")))
+
+	assert.Equal(t, []string{"list:shoes", "other"}, c.InvalidateTag("p2"))
+	assert.True(t, c.Has([]byte("list:hats")))
+	assert.Empty(t, c.InvalidateTag("p1"))
+	assert.Empty(t, c.tags)
+
+	c.Set([]byte("short"), []byte("e"), WriteOpts{TTL: time.Millisecond, Tags: []string{"p3"}})
+	time.Sleep(5 * time.Millisecond)
+	c.Get([]byte("short"))
+	assert.Empty(t, c.tags)
+}
+
+// TestInvalidatePrefix tests deleting every key with a prefix
+func TestInvalidatePrefix(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	c.Put([]byte("product:1:price"), []byte("9"), 0)
+	c.Put([]byte("product:1:name"), []byte("shoe"), 0)
+	c.Put([]byte("product:10:name"), []byte("hat"), 0)
+
+	assert.Equal(t, []string{"product:1:name", "product:1:price"}, c.InvalidatePrefix([]byte("product:1:")))
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, int64(len("product:10:name")+len("hat")), c.Bytes())
+}
+
+// TestMetrics tests that evictions are counted by reason
+func TestMetrics(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 3, MaxBytes: 10})
+	assert.Nil(t, c.Put([]byte("x"), []byte("1"), time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	c.Get([]byte("x"))
+
+	assert.Nil(t, c.Put([]byte("a"), []byte("1"), 0))
+	assert.Nil(t, c.Put([]byte("b"), []byte("2"), 0))
+	assert.Nil(t, c.Put([]byte("c"), []byte("3"), 0))
+	// Evicts a for the capacity, then b for the memory limit
+	assert.Nil(t, c.Put([]byte("d"), []byte("0123456"), 0))
+	c.Get([]byte("c"))
+	c.Get([]byte("a"))
+
+	m := c.Metrics()
+	assert.Equal(t, 1, m.Hits)
+	assert.Equal(t, 2, m.Misses)
+	assert.Equal(t, 1, m.EvictedCapacity)
+	assert.Equal(t, 1, m.EvictedMemory)
+	assert.Equal(t, 1, m.Expired)
+	assert.Equal(t, 2, m.Items)
+	assert.Equal(t, int64(10), m.Bytes)
+}
+
+// TestPeekLeavesState tests that peeking neither updates the usage of an item
+// nor expires it
+func TestPeekLeavesState(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 2})
+	assert.Nil(t, c.Put([]byte("a"), []byte("1"), 0))
+	assert.Nil(t, c.Put([]byte("b"), []byte("2"), 0))
+
+	item, err := c.Peek([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), item.Value)
+
+	// a is still the least recently used
+	assert.Nil(t, c.Put([]byte("c"), []byte("3"), 0))
+	_, err = c.Peek([]byte("a"))
+	assert.IsType(t, &util.KeyNotFoundError{}, err)
+
+	assert.Nil(t, c.Put([]byte("x"), []byte("1"), time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	_, err = c.Peek([]byte("x"))
+	assert.IsType(t, &util.ExpiredKeyError{}, err)
+
+	m := c.Metrics()
+	assert.Equal(t, 0, m.Hits)
+	assert.Equal(t, 0, m.Misses)
+	assert.Equal(t, 0, m.Expired)
+	assert.Equal(t, 2, m.Items)
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/dhyanio/discache/util"
+)
+
+// NoExpiry is the TTL reported for items that never expire
+const NoExpiry time.Duration = -1
+
+// Expiry describes when an item expires
+type Expiry struct {
+	// TTL is the remaining time to live, NoExpiry if the item never expires
+	TTL time.Duration
+	// Sliding is the window Touch extends the TTL to, 0 if the TTL is fixed
+	Sliding time.Duration
+}
+
+// Expiry returns when the item of key expires without updating its usage. A
+// missing or expired key returns a KeyNotFoundError.
+func (c *Cache) Expiry(key []byte) (Expiry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, found := c.items[string(key)]
+	if !found || e.expired() {
+		return Expiry{}, &util.KeyNotFoundError{Key: string(key)}
+	}
+	if e.expiry.IsZero() {
+		return Expiry{TTL: NoExpiry}, nil
+	}
+	return Expiry{TTL: time.Until(e.expiry), Sliding: e.sliding}, nil
+}
+
+// Expire sets the item of key to expire at, or never when at is zero. A
+// sliding window greater than 0 lets Touch extend the expiry to that long
+// after it is touched. An expiry in the past removes the item as expired. A
+// missing or expired key returns a KeyNotFoundError.
+func (c *Cache) Expire(key []byte, at time.Time, sliding time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e := c.live(strKey)
+	if e == nil {
+		return &util.KeyNotFoundError{Key: strKey}
+	}
+
+	e.expiry = at
+	e.hard = time.Time{}
+	e.sliding = sliding
+	if at.IsZero() {
+		e.sliding = 0
+	} else if !at.After(time.Now()) {
+		c.remove(strKey, RemoveExpired)
+	}
+	return nil
+}
+
+// Touch marks the live items of keys as used at, extending the expiry of
+// sliding items to their window after at, and returns how many were found
+func (c *Cache) Touch(at time.Time, keys ...[]byte) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	touched := 0
+	for _, key := range keys {
+		strKey := string(key)
+		e := c.live(strKey)
+		if e == nil {
+			continue
+		}
+		if e.sliding > 0 && at.Add(e.sliding).After(e.expiry) {
+			if !e.hard.IsZero() {
+				e.hard = e.hard.Add(at.Add(e.sliding).Sub(e.expiry)) // Keep the grace period past the expiry
+			}
+			e.expiry = at.Add(e.sliding)
+		}
+		c.updateOrder(strKey)
+		touched++
+	}
+	return touched
+}
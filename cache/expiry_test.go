@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExpireAndPersist tests reading and changing the expiry of items
+func TestExpireAndPersist(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	key := []byte("session")
+	assert.Nil(t, c.Put(key, []byte("v"), time.Minute))
+
+	exp, err := c.Expiry(key)
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Minute, exp.TTL, float64(time.Second))
+	assert.Zero(t, exp.Sliding)
+
+	assert.Nil(t, c.Expire(key, time.Time{}, 0))
+	exp, err = c.Expiry(key)
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiry, exp.TTL)
+
+	_, err = c.Expiry([]byte("missing"))
+	assert.IsType(t, &util.KeyNotFoundError{}, err)
+	assert.IsType(t, &util.KeyNotFoundError{}, c.Expire([]byte("missing"), time.Now().Add(time.Minute), 0))
+
+	var expired []string
+	c.AddRemoveListener(func(key string, _ []byte, reason RemoveReason) {
+		if reason == RemoveExpired {
+			expired = append(expired, key)
+		}
+	})
+	assert.Nil(t, c.Expire(key, time.Now().Add(-time.Second), 0))
+	assert.False(t, c.Has(key))
+	assert.Equal(t, []string{"session"}, expired)
+}
+
+// TestTouchSlidesExpiry tests that touching extends sliding items only
+func TestTouchSlidesExpiry(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	sliding, fixed := []byte("sliding"), []byte("fixed")
+	assert.Nil(t, c.Put(sliding, []byte("v"), 0))
+	assert.Nil(t, c.Put(fixed, []byte("v"), 0))
+
+	now := time.Now()
+	assert.Nil(t, c.Expire(sliding, now.Add(30*time.Millisecond), 50*time.Millisecond))
+	assert.Nil(t, c.Expire(fixed, now.Add(30*time.Millisecond), 0))
+
+	assert.Equal(t, 2, c.Touch(now.Add(20*time.Millisecond), sliding, fixed, []byte("missing")))
+	exp, err := c.Expiry(sliding)
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, exp.Sliding)
+	assert.Greater(t, exp.TTL, 60*time.Millisecond)
+
+	// A touch older than the current expiry never shortens it
+	c.Touch(now.Add(-time.Hour), sliding)
+	exp, _ = c.Expiry(sliding)
+	assert.Greater(t, exp.TTL, 60*time.Millisecond)
+
+	time.Sleep(40 * time.Millisecond)
+	assert.False(t, c.Has(fixed))
+	assert.True(t, c.Has(sliding))
+
+	// Snapshots keep the window
+	var buf bytes.Buffer
+	_, err = c.Snapshot().WriteTo(&buf)
+	assert.Nil(t, err)
+	restored := NewCache(CacheOpts{Capacity: 10})
+	assert.Nil(t, restored.Restore(&buf))
+	exp, err = restored.Expiry(sliding)
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, exp.Sliding)
+
+	// Writing a new value makes the expiry fixed again
+	assert.Nil(t, c.Put(sliding, []byte("w"), time.Minute))
+	exp, _ = c.Expiry(sliding)
+	assert.Zero(t, exp.Sliding)
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/dhyanio/discache/util"
+)
+
+// ErrNoLoader is returned by Fetch when the cache has no loader
+var ErrNoLoader = errors.New("cache has no loader")
+
+// Loader loads the value of key for Fetch along with its TTL, 0 using the
+// cache TTL. It runs without the cache locked.
+type Loader func(key []byte) ([]byte, time.Duration, error)
+
+// load is a call to the loader in flight, shared by every Fetch of its key
+type load struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// Fetch returns the value of key, loading it with the loader on a miss. Only
+// one load per key runs at a time and concurrent misses wait for it.
+//
+// An item past its TTL but within its grace period is stale: Fetch returns it
+// and refreshes it in the background. With Beta set, Fetch also refreshes
+// items shortly before their TTL elapses, with a probability growing as the
+// TTL nears and with the time the loader took, so the refreshes of a hot key
+// are spread out instead of happening at the same instant.
+func (c *Cache) Fetch(key []byte) ([]byte, error) {
+	if c.CacheOpts.Loader == nil {
+		return nil, ErrNoLoader
+	}
+
+	strKey := string(key)
+	c.mu.Lock()
+	c.hot.record(strKey)
+	if e := c.servable(strKey); e != nil {
+		if e.kind != KindString {
+			c.mu.Unlock()
+			return nil, &util.WrongTypeError{Key: strKey}
+		}
+		c.hits++
+		c.updateOrder(strKey)
+		if c.refreshDue(e, time.Now()) {
+			c.load(strKey, e.version)
+		}
+		value := e.value
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.misses++
+	l := c.load(strKey, 0)
+	c.mu.Unlock()
+
+	<-l.done
+	return l.value, l.err
+}
+
+// refreshDue reports whether Fetch should refresh a live item at now, which
+// is when it is stale or, with Beta set, when the XFetch early expiration
+// picks it
+func (c *Cache) refreshDue(e *entry, now time.Time) bool {
+	fresh := e.expiry
+	if fresh.IsZero() {
+		return false
+	}
+	if !now.Before(fresh) {
+		return true
+	}
+	if c.CacheOpts.Beta <= 0 || e.delta == 0 {
+		return false
+	}
+	early := time.Duration(float64(e.delta) * c.CacheOpts.Beta * -math.Log(1-c.random()))
+	return !now.Add(early).Before(fresh)
+}
+
+// load starts loading key unless a load of it is in flight, and returns the
+// load. The loaded value is only stored if the item still has version, 0
+// meaning it is missing, so a refresh never overwrites a newer write. The
+// cache must be locked.
+func (c *Cache) load(key string, version uint64) *load {
+	if l, found := c.loads[key]; found {
+		return l
+	}
+	l := &load{done: make(chan struct{})}
+	c.loads[key] = l
+
+	go func() {
+		start := time.Now()
+		value, ttl, err := c.CacheOpts.Loader([]byte(key))
+		took := time.Since(start)
+
+		c.mu.Lock()
+		delete(c.loads, key)
+		if err == nil {
+			current := uint64(0)
+			if e := c.servable(key); e != nil {
+				current = e.version
+			}
+			if current == version {
+				c.put(key, value, WriteOpts{TTL: ttl})
+				c.items[key].delta = took
+			}
+		}
+		c.mu.Unlock()
+
+		l.value, l.err = value, err
+		close(l.done)
+	}()
+	return l
+}
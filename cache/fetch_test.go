@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingLoader returns a loader counting its calls that loads "key-N" values
+// after waiting for delay
+func countingLoader(calls *atomic.Int32, delay time.Duration) Loader {
+	return func(key []byte) ([]byte, time.Duration, error) {
+		n := calls.Add(1)
+		time.Sleep(delay)
+		return []byte(fmt.Sprintf("%s-%d", key, n)), 0, nil
+	}
+}
+
+// TestFetchLoadsMissOnce tests that concurrent misses share one load
+func TestFetchLoadsMissOnce(t *testing.T) {
+	var calls atomic.Int32
+	c := NewCache(CacheOpts{Capacity: 10, TTL: time.Minute, Loader: countingLoader(&calls, 20*time.Millisecond)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.Fetch([]byte("k"))
+			assert.Nil(t, err)
+			assert.Equal(t, "k-1", string(value))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	failing := NewCache(CacheOpts{Capacity: 10, Loader: func([]byte) ([]byte, time.Duration, error) {
+		return nil, 0, errors.New("backend down")
+	}})
+	_, err := failing.Fetch([]byte("k"))
+	assert.EqualError(t, err, "backend down")
+	assert.False(t, failing.Has([]byte("k")))
+
+	_, err = NewCache(CacheOpts{Capacity: 10}).Fetch([]byte("k"))
+	assert.ErrorIs(t, err, ErrNoLoader)
+}
+
+// TestFetchServesStaleWhileRefreshing tests that stale items are served while
+// a single background refresh runs
+func TestFetchServesStaleWhileRefreshing(t *testing.T) {
+	var calls atomic.Int32
+	c := NewCache(CacheOpts{
+		Capacity: 10,
+		TTL:      20 * time.Millisecond,
+		Grace:    time.Minute,
+		Loader:   countingLoader(&calls, 30*time.Millisecond),
+	})
+
+	value, err := c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "k-1", string(value))
+
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		value, err = c.Fetch([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, "k-1", string(value), "stale value is served during the refresh")
+	}
+	assert.Eventually(t, func() bool {
+		value, _ := c.Fetch([]byte("k"))
+		return string(value) == "k-2"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// TestGraceOnlyServesFetch tests that an item past its TTL is missing for
+// every read but Fetch while its grace period runs
+func TestGraceOnlyServesFetch(t *testing.T) {
+	var calls atomic.Int32
+	c := NewCache(CacheOpts{Capacity: 10, Grace: time.Minute, Loader: countingLoader(&calls, 30*time.Millisecond)})
+
+	assert.Nil(t, c.Put([]byte("k"), []byte("old"), 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+
+	_, err := c.Get([]byte("k"))
+	assert.NotNil(t, err)
+	assert.False(t, c.Has([]byte("k")))
+	_, err = c.Expiry([]byte("k"))
+	assert.NotNil(t, err)
+	keys, _, err := c.Scan("", ScanOpts{})
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	value, err := c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(value), "Fetch serves the item stale")
+}
+
+// TestFetchRefreshKeepsNewerWrite tests that a refresh finishing after a write
+// does not overwrite it
+func TestFetchRefreshKeepsNewerWrite(t *testing.T) {
+	var calls atomic.Int32
+	c := NewCache(CacheOpts{Capacity: 10, Grace: time.Minute, Loader: countingLoader(&calls, 30*time.Millisecond)})
+
+	assert.Nil(t, c.Put([]byte("k"), []byte("old"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	value, err := c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(value))
+
+	assert.Nil(t, c.Put([]byte("k"), []byte("new"), time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load())
+	value, err = c.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(value))
+}
+
+// TestFetchRefreshesEarly tests the probabilistic early refresh of fresh items
+func TestFetchRefreshesEarly(t *testing.T) {
+	var calls atomic.Int32
+	c := NewCache(CacheOpts{Capacity: 10, TTL: 200 * time.Millisecond, Beta: 1, Loader: countingLoader(&calls, 10*time.Millisecond)})
+
+	_, err := c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+
+	// A draw close to 0 keeps a fresh item, one close to 1 refreshes it early
+	c.random = func() float64 { return 0.5 }
+	_, err = c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), calls.Load())
+
+	c.random = func() float64 { return 1 - 1e-15 }
+	value, err := c.Fetch([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "k-1", string(value))
+	assert.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// HotKey is a frequently accessed key along with its estimated access count
+type HotKey struct {
+	Key string
+	// Count estimates the accesses of the key, scaled up by the sampling rate.
+	// It may overestimate by up to Error but never underestimates the sampled
+	// accesses.
+	Count uint64
+	Error uint64
+}
+
+// hotCounter is a monitored key of the sketch
+type hotCounter struct {
+	key   string
+	count uint64
+	err   uint64
+	index int // Position in the heap
+}
+
+// hotHeap orders the monitored keys by ascending count
+type hotHeap []*hotCounter
+
+func (h hotHeap) Len() int           { return len(h) }
+func (h hotHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotHeap) Push(x any) {
+	counter := x.(*hotCounter)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *hotHeap) Pop() any {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return counter
+}
+
+// hotKeys is a Space-Saving sketch of the most accessed keys. It monitors a
+// fixed number of keys, and a key that is not monitored replaces the one
+// with the lowest count, inheriting that count as its error. Any key making
+// up more than 1/size of the sampled accesses is guaranteed to be monitored.
+type hotKeys struct {
+	mu       sync.Mutex
+	size     int
+	rate     int // One in rate accesses is sampled
+	counters map[string]*hotCounter
+	heap     hotHeap
+}
+
+// newHotKeys creates a sketch monitoring size keys and sampling one in rate
+// accesses, or nil if size is 0
+func newHotKeys(size, rate int) *hotKeys {
+	if size <= 0 {
+		return nil
+	}
+	return &hotKeys{
+		size:     size,
+		rate:     max(rate, 1),
+		counters: make(map[string]*hotCounter, size),
+	}
+}
+
+// record counts an access of key if it is sampled
+func (h *hotKeys) record(key string) {
+	if h == nil || (h.rate > 1 && rand.IntN(h.rate) != 0) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if counter, found := h.counters[key]; found {
+		counter.count++
+		heap.Fix(&h.heap, counter.index)
+		return
+	}
+	if len(h.heap) < h.size {
+		counter := &hotCounter{key: key, count: 1}
+		h.counters[key] = counter
+		heap.Push(&h.heap, counter)
+		return
+	}
+
+	// Replace the least counted key
+	counter := h.heap[0]
+	delete(h.counters, counter.key)
+	counter.key = key
+	counter.err = counter.count
+	counter.count++
+	h.counters[key] = counter
+	heap.Fix(&h.heap, 0)
+}
+
+// top returns the n keys with the highest counts in descending order
+func (h *hotKeys) top(n int) []HotKey {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	keys := make([]HotKey, len(h.heap))
+	for i, counter := range h.heap {
+		keys[i] = HotKey{
+			Key:   counter.key,
+			Count: counter.count * uint64(h.rate),
+			Error: counter.err * uint64(h.rate),
+		}
+	}
+	h.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		switch {
+		case a.Count > b.Count:
+			return -1
+		case a.Count < b.Count:
+			return 1
+		default:
+			return 0
+		}
+	})
+	if n > 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// reset forgets every monitored key
+func (h *hotKeys) reset() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counters = make(map[string]*hotCounter, h.size)
+	h.heap = nil
+}
+
+// HotKeys returns up to n of the most accessed keys in descending order of
+// their estimated count, every monitored key when n is 0. It returns nil
+// unless CacheOpts.HotKeys is set.
+func (c *Cache) HotKeys(n int) []HotKey {
+	return c.hot.top(n)
+}
+
+// ResetHotKeys forgets the accesses counted so far
+func (c *Cache) ResetHotKeys() {
+	c.hot.reset()
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHotKeys tests that the sketch finds the most accessed keys among many cold ones
+func TestHotKeys(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 1000, TTL: time.Minute, HotKeys: 8})
+	for i := 0; i < 500; i++ {
+		c.Get([]byte("hot"))
+		if i%2 == 0 {
+			assert.Nil(t, c.Put([]byte("warm"), []byte("v"), 0))
+		}
+		c.Get([]byte(fmt.Sprintf("cold:%d", i)))
+	}
+
+	top := c.HotKeys(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "hot", top[0].Key)
+	assert.GreaterOrEqual(t, top[0].Count, uint64(500))
+	assert.LessOrEqual(t, top[0].Count-top[0].Error, uint64(500))
+	assert.Equal(t, "warm", top[1].Key)
+	assert.Len(t, c.HotKeys(0), 8)
+
+	c.ResetHotKeys()
+	assert.Empty(t, c.HotKeys(0))
+	assert.Nil(t, NewCache(CacheOpts{Capacity: 10}).HotKeys(10))
+}
+
+// TestHotKeysSampling tests that sampled counts are scaled back up
+func TestHotKeysSampling(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10, TTL: time.Minute, HotKeys: 4, HotKeySampling: 10})
+	for i := 0; i < 20000; i++ {
+		c.Get([]byte("hot"))
+	}
+
+	top := c.HotKeys(1)
+	assert.Len(t, top, 1)
+	assert.Equal(t, "hot", top[0].Key)
+	assert.InDelta(t, 20000, float64(top[0].Count), 2000)
+	assert.Zero(t, top[0].Count%10)
+}
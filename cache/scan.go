@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"errors"
+	"iter"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultScanCount is the page size of a scan that does not set one
+const DefaultScanCount = 100
+
+// ErrBadPattern is returned when a scan match pattern is malformed
+var ErrBadPattern = errors.New("syntax error in match pattern")
+
+// ScanOpts filters the keys returned by a scan
+type ScanOpts struct {
+	// Prefix keeps only the keys starting with it
+	Prefix string
+	// Match keeps only the keys matching a glob pattern. '*' matches any
+	// sequence, '?' any character, [abc], [a-z] and [^a] a character class,
+	// and '\' escapes the next character.
+	Match string
+	// Count is the maximum number of keys of a page, 0 uses DefaultScanCount
+	Count int
+}
+
+// Scan returns a page of live keys greater than cursor in lexical order, and
+// the cursor of the next page, which is empty once the scan is done. An empty
+// cursor starts a new scan. Since the cursor is a key, a key present during
+// the whole scan is returned exactly once however the cache changes between
+// pages, while keys written or deleted meanwhile may or may not be returned.
+func (c *Cache) Scan(cursor string, opts ScanOpts) ([]string, string, error) {
+	if err := validPattern(opts.Match); err != nil {
+		return nil, "", err
+	}
+	count := opts.Count
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Keep the count smallest keys after the cursor, sorted
+	page := make([]string, 0, count+1)
+	more := false
+	for key, e := range c.items {
+		if (cursor != "" && key <= cursor) || e.expired() || !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		if opts.Match != "" && !matchPattern(opts.Match, key) {
+			continue
+		}
+		if len(page) == count && key > page[count-1] {
+			more = true
+			continue
+		}
+		i, _ := slices.BinarySearch(page, key)
+		page = slices.Insert(page, i, key)
+		if len(page) > count {
+			page = page[:count]
+			more = true
+		}
+	}
+
+	if !more {
+		return page, "", nil
+	}
+	return page, page[len(page)-1], nil
+}
+
+// Keys returns an iterator over the live keys in lexical order. It scans the
+// cache page by page without holding its lock while the loop body runs, so
+// the body may use the cache.
+func (c *Cache) Keys(opts ScanOpts) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		cursor := ""
+		for {
+			keys, next, err := c.Scan(cursor, opts)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+			if next == "" {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// Range returns an iterator over the live items in lexical order of their
+// keys, page by page like Keys. Items that are not strings are yielded with
+// their version and a nil value, and items removed since their page was
+// scanned are skipped. Range does not update the usage of the items, and
+// yields nothing for a malformed Match, which Keys and Scan report.
+func (c *Cache) Range(opts ScanOpts) iter.Seq2[string, Item] {
+	return func(yield func(string, Item) bool) {
+		for key, err := range c.Keys(opts) {
+			if err != nil {
+				return
+			}
+			item, found := c.peek(key)
+			if found && !yield(key, item) {
+				return
+			}
+		}
+	}
+}
+
+// peek returns the item of key without updating its usage
+func (c *Cache) peek(key string) (Item, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, found := c.items[key]
+	if !found || e.expired() {
+		return Item{}, false
+	}
+	return Item{Value: e.value, Version: e.version}, true
+}
+
+// validPattern checks that a match pattern is well formed
+func validPattern(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i == len(pattern) {
+				return ErrBadPattern
+			}
+		case '[':
+			end := classEnd(pattern, i)
+			if end < 0 {
+				return ErrBadPattern
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// classEnd returns the index of the ']' closing the character class opened at
+// start, or -1 if it is not closed
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	for first := true; i < len(pattern); i, first = i+1, false {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case ']':
+			if !first {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchPattern reports whether s matches the well formed glob pattern. It
+// only backtracks to the last '*', since an earlier one never needs to match
+// more.
+func matchPattern(pattern, s string) bool {
+	starP, starS := -1, -1
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p+1, i
+				p++
+				continue
+			default:
+				if n, w, ok := matchChar(pattern, p, s[i:]); ok {
+					p, i = n, i+w
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// Let the last '*' match one more character
+		_, w := utf8.DecodeRuneInString(s[starS:])
+		starS += w
+		p, i = starP, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchChar matches the pattern element at p other than '*' against the first
+// character of s. It returns the index of the next element and the width of
+// the matched character.
+func matchChar(pattern string, p int, s string) (int, int, bool) {
+	r, w := utf8.DecodeRuneInString(s)
+	switch pattern[p] {
+	case '?':
+		return p + 1, w, true
+	case '[':
+		end := classEnd(pattern, p)
+		return end + 1, w, matchClass(pattern[p+1:end], r)
+	case '\\':
+		p++
+	}
+	pr, pw := utf8.DecodeRuneInString(pattern[p:])
+	return p + pw, w, pr == r
+}
+
+// matchClass reports whether r is in the character class, given without its brackets
+func matchClass(class string, r rune) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+	for i := 0; i < len(class); {
+		if class[i] == '\\' {
+			i++
+		}
+		lo, w := utf8.DecodeRuneInString(class[i:])
+		i += w
+		hi := lo
+		if i+1 < len(class) && class[i] == '-' {
+			i++
+			if class[i] == '\\' && i+1 < len(class) {
+				i++
+			}
+			hi, w = utf8.DecodeRuneInString(class[i:])
+			i += w
+		}
+		if lo <= r && r <= hi {
+			return !negate
+		}
+	}
+	return negate
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScanPages tests that scanning pages through the live keys in lexical order
+func TestScanPages(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 100, TTL: time.Hour})
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, c.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte("v"), 0))
+	}
+	assert.Nil(t, c.Put([]byte("order:1"), []byte("v"), 0))
+	assert.Nil(t, c.Put([]byte("user:gone"), []byte("v"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	var keys []string
+	cursor, pages := "", 0
+	for {
+		page, next, err := c.Scan(cursor, ScanOpts{Prefix: "user:", Count: 10})
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(page), 10)
+		keys = append(keys, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 3, pages)
+	assert.Len(t, keys, 25)
+	assert.True(t, slices.IsSorted(keys))
+	assert.Equal(t, "user:24", keys[24])
+}
+
+// TestKeysUnderConcurrentWrites tests that a key present during a whole
+// iteration is returned exactly once while the loop body writes to the cache
+func TestKeysUnderConcurrentWrites(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 100, TTL: time.Hour})
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, c.Put([]byte(fmt.Sprintf("k%02d", i)), []byte("v"), 0))
+	}
+
+	seen := map[string]int{}
+	for key, err := range c.Keys(ScanOpts{Count: 7}) {
+		assert.Nil(t, err)
+		seen[key]++
+		// Rewriting keys and adding new ones must not repeat or skip keys
+		assert.Nil(t, c.Put([]byte(key), []byte("w"), 0))
+		assert.Nil(t, c.Put([]byte("a"+key), []byte("v"), 0))
+	}
+	for i := 0; i < 30; i++ {
+		assert.Equal(t, 1, seen[fmt.Sprintf("k%02d", i)])
+	}
+
+	var values []string
+	for key, item := range c.Range(ScanOpts{Prefix: "k0", Count: 3}) {
+		values = append(values, key+"="+string(item.Value))
+	}
+	assert.Equal(t, []string{"k00=w", "k01=w", "k02=w", "k03=w", "k04=w", "k05=w", "k06=w", "k07=w", "k08=w", "k09=w"}, values)
+}
+
+// TestScanMatch tests glob matching of scanned keys
+func TestScanMatch(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"*:1", "user:1", true},
+		{"u?er:*:name", "user:42:name", true},
+		{"u?er:*:name", "user:42:email", false},
+		{"*a*b*c", "xaybzbc", true},
+		{"*a*b*c", "xaybzbcd", false},
+		{"user:[0-4]", "user:3", true},
+		{"user:[0-4]", "user:7", false},
+		{"user:[^0-4]", "user:7", true},
+		{"h[ae]llo", "hello", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"caf?", "café", true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.match, matchPattern(tc.pattern, tc.key), tc.pattern+" "+tc.key)
+	}
+
+	c := NewCache(CacheOpts{Capacity: 10, TTL: time.Hour})
+	for _, key := range []string{"user:1:name", "user:1:email", "user:2:name"} {
+		assert.Nil(t, c.Put([]byte(key), []byte("v"), 0))
+	}
+	keys, next, err := c.Scan("", ScanOpts{Match: "user:*:name"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, []string{"user:1:name", "user:2:name"}, keys)
+
+	for _, pattern := range []string{"user:[0-4", `user\`, "[]"} {
+		_, _, err = c.Scan("", ScanOpts{Match: pattern})
+		assert.ErrorIs(t, err, ErrBadPattern, pattern)
+	}
+}
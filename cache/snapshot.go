@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"maps"
+	"time"
+)
+
+// record is the serialized form of an item in a snapshot
+type record struct {
+	Key     string
+	Kind    Kind
+	Value   []byte
+	Hash    map[string][]byte
+	List    [][]byte
+	Set     []string
+	ZSet    map[string]float64
+	Version uint64
+	Expiry  time.Time
+	Hard    time.Time
+	Sliding time.Duration
+	Tags    []string
+}
+
+// Snapshot is a point in time copy of the items of a cache
+type Snapshot struct {
+	records []record
+}
+
+// Snapshot copies every item of the cache Fetch still serves in LRU order, so it can be
+// written out while the cache keeps changing
+func (c *Cache) Snapshot() *Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := &Snapshot{records: make([]record, 0, len(c.order))}
+	for _, key := range c.order {
+		e := c.items[key]
+		if e.dead() {
+			continue
+		}
+		rec := record{
+			Key:     key,
+			Kind:    e.kind,
+			Value:   e.value,
+			Version: e.version,
+			Expiry:  e.expiry,
+			Hard:    e.hard,
+			Sliding: e.sliding,
+			Tags:    e.tags,
+		}
+		switch e.kind {
+		case KindHash:
+			rec.Hash = maps.Clone(e.hash)
+		case KindList:
+			rec.List = append([][]byte{}, e.list...)
+		case KindSet:
+			for member := range e.set {
+				rec.Set = append(rec.Set, member)
+			}
+		case KindZSet:
+			rec.ZSet = maps.Clone(e.zset)
+		}
+		s.records = append(s.records, rec)
+	}
+	return s
+}
+
+// WriteTo writes the snapshot to w
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	enc := gob.NewEncoder(cw)
+	if err := enc.Encode(len(s.records)); err != nil {
+		return cw.n, err
+	}
+	for i := range s.records {
+		if err := enc.Encode(&s.records[i]); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// Restore replaces the items of the cache with a snapshot written by
+// Snapshot.WriteTo. Items that expired since are dropped.
+func (c *Cache) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var count int
+	if err := dec.Decode(&count); err != nil {
+		return err
+	}
+	if count < 0 {
+		return errors.New("invalid snapshot item count")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*entry)
+	c.order = []string{}
+	c.tags = make(map[string]map[string]struct{})
+	c.bytes = 0
+	for i := 0; i < count; i++ {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+
+		e := &entry{
+			kind:    rec.Kind,
+			value:   rec.Value,
+			hash:    rec.Hash,
+			list:    rec.List,
+			zset:    rec.ZSet,
+			version: c.nextVersion(rec.Version),
+			expiry:  rec.Expiry,
+			hard:    rec.Hard,
+			sliding: rec.Sliding,
+			tags:    rec.Tags,
+		}
+		if e.dead() {
+			continue
+		}
+
+		size := int64(len(e.value))
+		switch e.kind {
+		case KindHash:
+			if e.hash == nil {
+				e.hash = make(map[string][]byte)
+			}
+			for field, value := range e.hash {
+				size += int64(len(field) + len(value))
+			}
+		case KindList:
+			for _, value := range e.list {
+				size += int64(len(value))
+			}
+		case KindSet:
+			e.set = make(map[string]struct{}, len(rec.Set))
+			for _, member := range rec.Set {
+				e.set[member] = struct{}{}
+				size += int64(len(member))
+			}
+		case KindZSet:
+			if e.zset == nil {
+				e.zset = make(map[string]float64)
+			}
+			for member := range e.zset {
+				size += int64(len(member)) + scoreSize
+			}
+		}
+		c.insert(rec.Key, e)
+		c.resize(rec.Key, e, size)
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
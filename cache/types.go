@@ -0,0 +1,509 @@
+package cache
+
+import (
+	"sort"
+
+	"github.com/dhyanio/discache/util"
+)
+
+// Kind is the kind of value stored at a key
+type Kind int
+
+const (
+	// KindString is an opaque byte value
+	KindString Kind = iota
+	// KindHash maps fields to values
+	KindHash
+	// KindList is an ordered list of values
+	KindList
+	// KindSet is an unordered set of unique members
+	KindSet
+	// KindZSet is a set of unique members ordered by score
+	KindZSet
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindHash:
+		return "hash"
+	case KindList:
+		return "list"
+	case KindSet:
+		return "set"
+	case KindZSet:
+		return "zset"
+	default:
+		return "unknown"
+	}
+}
+
+// scoreSize is the memory accounted for the score of a sorted set member
+const scoreSize = 8
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// Type returns the kind of value stored at key
+func (c *Cache) Type(key []byte) (Kind, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.live(string(key))
+	if e == nil {
+		return 0, &util.KeyNotFoundError{Key: string(key)}
+	}
+	return e.kind, nil
+}
+
+// HSet sets field of the hash stored at key and reports whether the field is
+// new. A missing key is created expiring after opts.TTL.
+func (c *Cache) HSet(key, field, value []byte, opts WriteOpts) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindHash, true, opts)
+	if err != nil {
+		return false, err
+	}
+
+	delta := int64(len(value))
+	old, found := e.hash[string(field)]
+	if found {
+		delta -= int64(len(old))
+	} else {
+		delta += int64(len(field))
+	}
+	e.hash[string(field)] = value
+	c.modified(strKey, e, delta, opts)
+	return !found, nil
+}
+
+// HGet returns field of the hash stored at key
+func (c *Cache) HGet(key, field []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindHash)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		if value, found := e.hash[string(field)]; found {
+			return value, nil
+		}
+	}
+	return nil, &util.KeyNotFoundError{Key: string(key)}
+}
+
+// HDel removes fields from the hash stored at key and returns how many existed
+func (c *Cache) HDel(key []byte, fields [][]byte, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindHash, false, opts)
+	if e == nil || err != nil {
+		return 0, err
+	}
+
+	removed, delta := 0, int64(0)
+	for _, field := range fields {
+		if value, found := e.hash[string(field)]; found {
+			delete(e.hash, string(field))
+			delta -= int64(len(field) + len(value))
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.modified(strKey, e, delta, opts)
+	}
+	return removed, nil
+}
+
+// HGetAll returns every field of the hash stored at key, empty if it does not exist
+func (c *Cache) HGetAll(key []byte) (map[string][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindHash)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string][]byte)
+	if e != nil {
+		for field, value := range e.hash {
+			fields[field] = value
+		}
+	}
+	return fields, nil
+}
+
+// LPush prepends values to the list stored at key and returns its length. A
+// missing key is created expiring after opts.TTL.
+func (c *Cache) LPush(key []byte, values [][]byte, opts WriteOpts) (int, error) {
+	return c.push(key, values, true, opts)
+}
+
+// RPush appends values to the list stored at key and returns its length. A
+// missing key is created expiring after opts.TTL.
+func (c *Cache) RPush(key []byte, values [][]byte, opts WriteOpts) (int, error) {
+	return c.push(key, values, false, opts)
+}
+
+// push adds values to the head or the tail of a list
+func (c *Cache) push(key []byte, values [][]byte, head bool, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindList, true, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	delta := int64(0)
+	for _, value := range values {
+		delta += int64(len(value))
+	}
+	if head {
+		// Values are pushed one after the other, so the last one ends up first
+		list := make([][]byte, 0, len(values)+len(e.list))
+		for i := len(values) - 1; i >= 0; i-- {
+			list = append(list, values[i])
+		}
+		e.list = append(list, e.list...)
+	} else {
+		e.list = append(e.list, values...)
+	}
+	c.modified(strKey, e, delta, opts)
+	return len(e.list), nil
+}
+
+// LPop removes and returns the first value of the list stored at key
+func (c *Cache) LPop(key []byte, opts WriteOpts) ([]byte, error) {
+	return c.pop(key, true, opts)
+}
+
+// RPop removes and returns the last value of the list stored at key
+func (c *Cache) RPop(key []byte, opts WriteOpts) ([]byte, error) {
+	return c.pop(key, false, opts)
+}
+
+// pop removes a value from the head or the tail of a list
+func (c *Cache) pop(key []byte, head bool, opts WriteOpts) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindList, false, opts)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, &util.KeyNotFoundError{Key: strKey}
+	}
+
+	var value []byte
+	if head {
+		value, e.list = e.list[0], e.list[1:]
+	} else {
+		value, e.list = e.list[len(e.list)-1], e.list[:len(e.list)-1]
+	}
+	c.modified(strKey, e, -int64(len(value)), opts)
+	return value, nil
+}
+
+// LRange returns the values of the list stored at key between start and stop
+// inclusive. Negative indexes count from the end of the list.
+func (c *Cache) LRange(key []byte, start, stop int) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindList)
+	if err != nil || e == nil {
+		return [][]byte{}, err
+	}
+
+	n := len(e.list)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return [][]byte{}, nil
+	}
+	return append([][]byte{}, e.list[start:stop+1]...), nil
+}
+
+// SAdd adds members to the set stored at key and returns how many were new. A
+// missing key is created expiring after opts.TTL.
+func (c *Cache) SAdd(key []byte, members [][]byte, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindSet, true, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	added, delta := 0, int64(0)
+	for _, member := range members {
+		if _, found := e.set[string(member)]; !found {
+			e.set[string(member)] = struct{}{}
+			delta += int64(len(member))
+			added++
+		}
+	}
+	c.modified(strKey, e, delta, opts)
+	return added, nil
+}
+
+// SRem removes members from the set stored at key and returns how many existed
+func (c *Cache) SRem(key []byte, members [][]byte, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindSet, false, opts)
+	if e == nil || err != nil {
+		return 0, err
+	}
+
+	removed, delta := 0, int64(0)
+	for _, member := range members {
+		if _, found := e.set[string(member)]; found {
+			delete(e.set, string(member))
+			delta -= int64(len(member))
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.modified(strKey, e, delta, opts)
+	}
+	return removed, nil
+}
+
+// SMembers returns the members of the set stored at key in lexical order
+func (c *Cache) SMembers(key []byte) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindSet)
+	if err != nil || e == nil {
+		return [][]byte{}, err
+	}
+
+	members := make([]string, 0, len(e.set))
+	for member := range e.set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return result, nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key
+func (c *Cache) SIsMember(key, member []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindSet)
+	if err != nil || e == nil {
+		return false, err
+	}
+	_, found := e.set[string(member)]
+	return found, nil
+}
+
+// ZAdd adds members to the sorted set stored at key, updating the score of
+// existing ones, and returns how many were new. A missing key is created
+// expiring after opts.TTL.
+func (c *Cache) ZAdd(key []byte, members []ZMember, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindZSet, true, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	added, delta := 0, int64(0)
+	for _, m := range members {
+		if _, found := e.zset[string(m.Member)]; !found {
+			delta += int64(len(m.Member)) + scoreSize
+			added++
+		}
+		e.zset[string(m.Member)] = m.Score
+	}
+	c.modified(strKey, e, delta, opts)
+	return added, nil
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many existed
+func (c *Cache) ZRem(key []byte, members [][]byte, opts WriteOpts) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strKey := string(key)
+	e, err := c.collection(strKey, KindZSet, false, opts)
+	if e == nil || err != nil {
+		return 0, err
+	}
+
+	removed, delta := 0, int64(0)
+	for _, member := range members {
+		if _, found := e.zset[string(member)]; found {
+			delete(e.zset, string(member))
+			delta -= int64(len(member)) + scoreSize
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.modified(strKey, e, delta, opts)
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of member in the sorted set stored at key
+func (c *Cache) ZScore(key, member []byte) (float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindZSet)
+	if err != nil {
+		return 0, err
+	}
+	if e != nil {
+		if score, found := e.zset[string(member)]; found {
+			return score, nil
+		}
+	}
+	return 0, &util.KeyNotFoundError{Key: string(key)}
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key with a
+// score between minScore and maxScore inclusive, ordered by score then member
+func (c *Cache) ZRangeByScore(key []byte, minScore, maxScore float64) ([]ZMember, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), KindZSet)
+	if err != nil || e == nil {
+		return []ZMember{}, err
+	}
+
+	members := []ZMember{}
+	for member, score := range e.zset {
+		if score >= minScore && score <= maxScore {
+			members = append(members, ZMember{Member: []byte(member), Score: score})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return string(members[i].Member) < string(members[j].Member)
+	})
+	return members, nil
+}
+
+// Card returns the number of fields, values or members of the collection
+// stored at key, 0 if it does not exist
+func (c *Cache) Card(key []byte, kind Kind) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.read(string(key), kind)
+	if err != nil || e == nil {
+		return 0, err
+	}
+	return e.len(), nil
+}
+
+// collection returns the live entry of key if it holds kind. A missing key is
+// created when create is set and nil is returned otherwise.
+func (c *Cache) collection(key string, kind Kind, create bool, opts WriteOpts) (*entry, error) {
+	c.hot.record(key)
+	e := c.live(key)
+	if e != nil {
+		if e.kind != kind {
+			return nil, &util.WrongTypeError{Key: key}
+		}
+		return e, nil
+	}
+	if !create {
+		return nil, nil
+	}
+
+	e = c.newEntry(kind, opts)
+	switch kind {
+	case KindHash:
+		e.hash = make(map[string][]byte)
+	case KindSet:
+		e.set = make(map[string]struct{})
+	case KindZSet:
+		e.zset = make(map[string]float64)
+	}
+	c.insert(key, e)
+	return e, nil
+}
+
+// read returns the live entry of key for a read if it holds kind, nil if it does not exist
+func (c *Cache) read(key string, kind Kind) (*entry, error) {
+	c.hot.record(key)
+	e := c.live(key)
+	if e == nil {
+		c.misses++
+		return nil, nil
+	}
+	if e.kind != kind {
+		return nil, &util.WrongTypeError{Key: key}
+	}
+	c.hits++
+	c.updateOrder(key)
+	return e, nil
+}
+
+// modified records a change of delta bytes to a collection. Empty
+// collections are removed like they never existed.
+func (c *Cache) modified(key string, e *entry, delta int64, opts WriteOpts) {
+	e.version = c.nextVersion(opts.Version)
+	if e.len() == 0 {
+		c.unlink(key)
+		return
+	}
+	c.updateOrder(key)
+	c.resize(key, e, delta)
+}
+
+// len returns the number of elements of a collection
+func (e *entry) len() int {
+	switch e.kind {
+	case KindHash:
+		return len(e.hash)
+	case KindList:
+		return len(e.list)
+	case KindSet:
+		return len(e.set)
+	case KindZSet:
+		return len(e.zset)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHash tests setting, reading and deleting hash fields
+func TestHash(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	key := []byte("user:1")
+
+	created, err := c.HSet(key, []byte("name"), []byte("ada"), WriteOpts{})
+	assert.Nil(t, err)
+	assert.True(t, created)
+	created, _ = c.HSet(key, []byte("name"), []byte("grace"), WriteOpts{})
+	assert.False(t, created)
+	c.HSet(key, []byte("lang"), []byte("cobol"), WriteOpts{})
+
+	value, err := c.HGet(key, []byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("grace"), value)
+	_, err = c.HGet(key, []byte("age"))
+	assert.IsType(t, &util.KeyNotFoundError{}, err)
+
+	all, _ := c.HGetAll(key)
+	assert.Equal(t, map[string][]byte{"name": []byte("grace"), "lang": []byte("cobol")}, all)
+
+	removed, _ := c.HDel(key, [][]byte{[]byte("name"), []byte("lang"), []byte("age")}, WriteOpts{})
+	assert.Equal(t, 2, removed)
+	assert.False(t, c.Has(key), "empty hashes are removed")
+}
+
+// TestList tests pushing, popping and ranging over lists
+func TestList(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	key := []byte("jobs")
+
+	n, _ := c.RPush(key, [][]byte{[]byte("b"), []byte("c")}, WriteOpts{})
+	assert.Equal(t, 2, n)
+	n, _ = c.LPush(key, [][]byte{[]byte("a"), []byte("z")}, WriteOpts{})
+	assert.Equal(t, 4, n)
+
+	values, _ := c.LRange(key, 0, -1)
+	assert.Equal(t, [][]byte{[]byte("z"), []byte("a"), []byte("b"), []byte("c")}, values)
+	values, _ = c.LRange(key, -2, 10)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, values)
+	values, _ = c.LRange(key, 3, 1)
+	assert.Empty(t, values)
+
+	value, _ := c.LPop(key, WriteOpts{})
+	assert.Equal(t, []byte("z"), value)
+	value, _ = c.RPop(key, WriteOpts{})
+	assert.Equal(t, []byte("c"), value)
+	n, _ = c.Card(key, KindList)
+	assert.Equal(t, 2, n)
+
+	c.LPop(key, WriteOpts{})
+	c.LPop(key, WriteOpts{})
+	_, err := c.LPop(key, WriteOpts{})
+	assert.IsType(t, &util.KeyNotFoundError{}, err)
+}
+
+// TestSetAndSortedSet tests set membership and sorted set ranges by score
+func TestSetAndSortedSet(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+
+	added, _ := c.SAdd([]byte("tags"), [][]byte{[]byte("go"), []byte("raft"), []byte("go")}, WriteOpts{})
+	assert.Equal(t, 2, added)
+	found, _ := c.SIsMember([]byte("tags"), []byte("raft"))
+	assert.True(t, found)
+	members, _ := c.SMembers([]byte("tags"))
+	assert.Equal(t, [][]byte{[]byte("go"), []byte("raft")}, members)
+	removed, _ := c.SRem([]byte("tags"), [][]byte{[]byte("raft")}, WriteOpts{})
+	assert.Equal(t, 1, removed)
+
+	board := []byte("board")
+	added, _ = c.ZAdd(board, []ZMember{{Member: []byte("bob"), Score: 20}, {Member: []byte("amy"), Score: 10}, {Member: []byte("cat"), Score: 20}}, WriteOpts{})
+	assert.Equal(t, 3, added)
+	added, _ = c.ZAdd(board, []ZMember{{Member: []byte("amy"), Score: 30}}, WriteOpts{})
+	assert.Equal(t, 0, added)
+
+	score, _ := c.ZScore(board, []byte("amy"))
+	assert.Equal(t, float64(30), score)
+	ranged, _ := c.ZRangeByScore(board, 15, 25)
+	assert.Equal(t, []ZMember{{Member: []byte("bob"), Score: 20}, {Member: []byte("cat"), Score: 20}}, ranged)
+}
+
+// TestWrongType tests that operations on a key holding another kind fail
+func TestWrongType(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	c.Put([]byte("name"), []byte("ada"), 0)
+	c.SAdd([]byte("tags"), [][]byte{[]byte("go")}, WriteOpts{})
+
+	_, err := c.HSet([]byte("name"), []byte("f"), []byte("v"), WriteOpts{})
+	assert.IsType(t, &util.WrongTypeError{}, err)
+	_, err = c.LRange([]byte("tags"), 0, -1)
+	assert.IsType(t, &util.WrongTypeError{}, err)
+	_, err = c.Get([]byte("tags"))
+	assert.IsType(t, &util.WrongTypeError{}, err)
+	_, err = c.Incr([]byte("tags"), 1, 0, WriteOpts{})
+	assert.IsType(t, &util.WrongTypeError{}, err)
+
+	c.Put([]byte("tags"), []byte("go"), 0)
+	kind, _ := c.Type([]byte("tags"))
+	assert.Equal(t, KindString, kind, "a set replaces a value of any kind")
+}
+
+// TestMaxBytes tests that collections count toward the memory limit
+func TestMaxBytes(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 100, MaxBytes: 64})
+
+	c.Put([]byte("old"), bytes.Repeat([]byte("x"), 20), 0)
+	c.RPush([]byte("list"), [][]byte{bytes.Repeat([]byte("y"), 20)}, WriteOpts{})
+	assert.Equal(t, int64(3+20+4+20), c.Bytes())
+	assert.True(t, c.Has([]byte("old")))
+
+	// Growing the list past the limit evicts the least recently used key
+	c.RPush([]byte("list"), [][]byte{bytes.Repeat([]byte("z"), 20)}, WriteOpts{})
+	assert.False(t, c.Has([]byte("old")))
+	assert.Equal(t, int64(4+40), c.Bytes())
+
+	c.RPop([]byte("list"), WriteOpts{})
+	c.RPop([]byte("list"), WriteOpts{})
+	assert.Equal(t, int64(0), c.Bytes())
+}
+
+// TestSnapshotRestore tests that every kind of value survives a snapshot
+func TestSnapshotRestore(t *testing.T) {
+	c := NewCache(CacheOpts{Capacity: 10})
+	c.Set([]byte("name"), []byte("ada"), WriteOpts{Version: 3})
+	c.HSet([]byte("user"), []byte("lang"), []byte("go"), WriteOpts{Version: 4})
+	c.RPush([]byte("jobs"), [][]byte{[]byte("a"), []byte("b")}, WriteOpts{Version: 5})
+	c.SAdd([]byte("tags"), [][]byte{[]byte("x")}, WriteOpts{Version: 6})
+	c.ZAdd([]byte("board"), []ZMember{{Member: []byte("amy"), Score: 1.5}}, WriteOpts{Version: 7})
+	c.Put([]byte("gone"), []byte("soon"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.Get([]byte("gone")) // Expire it so both caches account for the same items
+
+	var buf bytes.Buffer
+	_, err := c.Snapshot().WriteTo(&buf)
+	assert.Nil(t, err)
+
+	restored := NewCache(CacheOpts{Capacity: 10})
+	restored.Put([]byte("stale"), []byte("value"), 0)
+	assert.Nil(t, restored.Restore(&buf))
+
+	assert.Equal(t, 5, restored.Len())
+	assert.Equal(t, c.Bytes(), restored.Bytes())
+	item, _ := restored.GetItem([]byte("name"))
+	assert.Equal(t, Item{Value: []byte("ada"), Version: 3}, item)
+	value, _ := restored.HGet([]byte("user"), []byte("lang"))
+	assert.Equal(t, []byte("go"), value)
+	jobs, _ := restored.LRange([]byte("jobs"), 0, -1)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, jobs)
+	found, _ := restored.SIsMember([]byte("tags"), []byte("x"))
+	assert.True(t, found)
+	score, _ := restored.ZScore([]byte("board"), []byte("amy"))
+	assert.Equal(t, 1.5, score)
+	assert.False(t, restored.Has([]byte("stale")))
+
+	// New local writes continue after the highest restored version
+	version, _ := restored.Set([]byte("next"), []byte("v"), WriteOpts{})
+	assert.Equal(t, uint64(8), version)
+}
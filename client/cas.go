@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// ErrConflict is returned when the condition of a conditional write does not hold
+var ErrConflict = errors.New("condition of the conditional write does not hold")
+
+// ConflictError reports a failed conditional write along with the current
+// version of the key, 0 if the key does not exist. It matches ErrConflict.
+type ConflictError struct {
+	Key     []byte
+	Version uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict on key [%s] at version %d", e.Key, e.Version)
+}
+
+// Is makes errors.Is(err, ErrConflict) report true for a ConflictError
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// GetWithVersion gets the value for the key along with its version from the
+// server. The near cache is bypassed since it does not track versions. A
+// missing key returns a nil value and version 0.
+func (c *Client) GetWithVersion(ctx context.Context, key []byte) ([]byte, uint64, error) {
+	cmd := &transport.CommandGet{
+		Key:    key,
+		Strong: c.ReadConsistency == ConsistencyStrong,
+	}
+
+	var resp *transport.ResponseGet
+	err := c.do(ctx, opRead, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseGetResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.Status {
+	case transport.StatusOK:
+		return resp.Value, resp.Version, nil
+	case transport.StatusExpired, transport.StatusKeyNotFound:
+		return nil, 0, nil
+	default:
+		return nil, 0, statusError(resp.Status)
+	}
+}
+
+// SetIfVersion sets the key only if it is currently at version and returns its new version
+func (c *Client) SetIfVersion(ctx context.Context, key, value []byte, ttl int, version uint64) (uint64, error) {
+	return c.setIf(ctx, key, value, ttl, transport.CondVersion, version)
+}
+
+// SetIfAbsent sets the key only if it does not exist and returns its new version
+func (c *Client) SetIfAbsent(ctx context.Context, key, value []byte, ttl int) (uint64, error) {
+	return c.setIf(ctx, key, value, ttl, transport.CondAbsent, 0)
+}
+
+// SetIfPresent sets the key only if it exists and returns its new version
+func (c *Client) SetIfPresent(ctx context.Context, key, value []byte, ttl int) (uint64, error) {
+	return c.setIf(ctx, key, value, ttl, transport.CondPresent, 0)
+}
+
+// DeleteIfVersion deletes the key only if it is currently at version
+func (c *Client) DeleteIfVersion(ctx context.Context, key []byte, version uint64) error {
+	cmd := &transport.CommandDeleteIf{
+		Key:     key,
+		Version: version,
+	}
+	_, err := c.writeIf(ctx, key, cmd.Bytes())
+	return err
+}
+
+// setIf sends a conditional set. Like increments it is never resent once it
+// reached a server, since a retry could conflict with its own first attempt.
+func (c *Client) setIf(ctx context.Context, key, value []byte, ttl int, cond transport.Condition, version uint64) (uint64, error) {
+	cmd := &transport.CommandSetIf{
+		Key:     key,
+		Value:   value,
+		TTL:     ttl,
+		Cond:    cond,
+		Version: version,
+	}
+	return c.writeIf(ctx, key, cmd.Bytes())
+}
+
+// writeIf sends a conditional write and maps its response to the new version
+func (c *Client) writeIf(ctx context.Context, key, cmd []byte) (uint64, error) {
+	var resp *transport.ResponseVersion
+	err := c.do(ctx, opWriteOnce, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd); err != nil {
+			return err
+		}
+		resp, err = transport.ParseVersionResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if c.near != nil {
+		c.near.invalidate(key)
+	}
+	switch resp.Status {
+	case transport.StatusOK:
+		return resp.Version, nil
+	case transport.StatusConflict:
+		return 0, &ConflictError{Key: key, Version: resp.Version}
+	default:
+		return 0, statusError(resp.Status)
+	}
+}
@@ -2,100 +2,469 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
+	"github.com/dhyanio/discache/trace"
 	"github.com/dhyanio/discache/transport"
 	"github.com/dhyanio/gogger"
 )
 
+// ErrBusy is returned when the server rejected a request because a connection
+// or rate limit was reached; it is safe to retry later
+var ErrBusy = errors.New("server is busy")
+
+// ErrNoAuth is returned when the server requires a password the client did
+// not send or that was wrong
+var ErrNoAuth = errors.New("authentication required")
+
 // Options is the configuration for the client
 type Options struct {
 	Log *gogger.Logger
+
+	// MinConns is the number of connections kept open even when idle
+	MinConns int
+	// MaxConns caps the number of connections open at once; callers block when it is reached
+	MaxConns int
+	// DialTimeout bounds how long establishing a new connection may take
+	DialTimeout time.Duration
+	// IdleTimeout closes connections that sat idle for longer, down to MinConns
+	IdleTimeout time.Duration
+	// HealthCheckInterval is how often idle connections are checked and reaped, negative disables it
+	HealthCheckInterval time.Duration
+
+	// ReadTimeout bounds read operations such as Get when ctx carries no earlier deadline, 0 disables it
+	ReadTimeout time.Duration
+	// WriteTimeout bounds write operations such as Put when ctx carries no earlier deadline, 0 disables it
+	WriteTimeout time.Duration
+
+	// ReadConsistency selects whether reads may be served by followers
+	ReadConsistency Consistency
+	// MaxRetries is how many other members are tried after a connection failure
+	MaxRetries int
+	// RetryBackoff is the base delay between retries, doubled on every attempt
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the delay between retries
+	MaxRetryBackoff time.Duration
+	// RefreshInterval is how often the cluster topology is refreshed, negative disables it
+	RefreshInterval time.Duration
+
+	// NearCacheSize is the capacity of the in-process near cache, 0 disables it
+	NearCacheSize int
+	// NearCacheTTL bounds how long a value is served from the near cache, 0 keeps it until invalidated or evicted
+	NearCacheTTL time.Duration
+
+	// TLSConfig connects to the nodes over TLS, nil connects over plain TCP
+	TLSConfig *tls.Config
+	// Password authenticates every connection with AUTH, empty sends none
+	Password string
+
+	// Tracer traces the requests of the client, nil traces nothing. Either way
+	// the span carried by ctx, if any, is propagated to the server.
+	Tracer *trace.Tracer
+}
+
+// setDefaults fills unset options with their default values
+func (o *Options) setDefaults() {
+	if o.MaxConns <= 0 {
+		o.MaxConns = defaultMaxConns
+	}
+	if o.MinConns <= 0 {
+		o.MinConns = defaultMinConns
+	}
+	if o.MinConns > o.MaxConns {
+		o.MinConns = o.MaxConns
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = defaultIdleTimeout
+	}
+	if o.HealthCheckInterval == 0 {
+		o.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	} else if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if o.RefreshInterval == 0 {
+		o.RefreshInterval = defaultRefreshInterval
+	}
 }
 
-// Client is the client to interact with the server
+// Client is the client to interact with a discache node or cluster. It is safe for concurrent use.
 type Client struct {
 	Options
-	conn net.Conn
+	cluster *cluster
+	near    *nearCache // nil when the near cache is disabled
 }
 
-// NewFromConn creates a new client from an existing connection
+// NewFromConn creates a new client from an existing connection. The client
+// uses only that connection and serializes requests over it.
 func NewFromConn(conn net.Conn) *Client {
+	opts := Options{MinConns: 1, MaxConns: 1, HealthCheckInterval: -1, MaxRetries: -1, RefreshInterval: -1}
+	opts.setDefaults()
+
+	addr := conn.RemoteAddr().String()
+	p := &pool{
+		opts:  opts,
+		slots: make(chan struct{}, 1),
+		idle:  []*poolConn{{Conn: conn}},
+		done:  make(chan struct{}),
+		dial: func(context.Context) (net.Conn, error) {
+			return nil, errors.New("connection is closed and cannot be redialed")
+		},
+	}
+	c := &cluster{
+		opts:    opts,
+		seeds:   []string{addr},
+		members: []string{addr},
+		pools:   map[string]*pool{addr: p},
+		done:    make(chan struct{}),
+	}
 	return &Client{
-		conn: conn,
+		Options: opts,
+		cluster: c,
 	}
 }
 
-// New creates a new client
+// New creates a new client for a single endpoint. If the endpoint is part of
+// a cluster the other members are discovered and used as well.
 func New(endpoint string, opts Options) (*Client, error) {
-	conn, err := net.Dial("tcp", endpoint)
+	return NewCluster([]string{endpoint}, opts)
+}
+
+// NewCluster creates a new client from a list of seed endpoints. The cluster
+// members and leader are discovered from the seeds; writes are routed to the
+// leader and reads according to opts.ReadConsistency.
+func NewCluster(seeds []string, opts Options) (*Client, error) {
+	opts.setDefaults()
+
+	c, err := newCluster(seeds, opts)
 	if err != nil {
-		opts.Log.Fatal().Msgf("failed to create discache client: %s", err.Error())
+		return nil, fmt.Errorf("failed to create discache client: %w", err)
 	}
-	return &Client{
+
+	client := &Client{
 		Options: opts,
-		conn:    conn,
-	}, nil
+		cluster: c,
+	}
+	if opts.NearCacheSize > 0 {
+		client.near = newNearCache(c, opts)
+	}
+	return client, nil
 }
 
-// Get gets the value for the key from the server
+// Get gets the value for the key from the near cache or the server
 func (c *Client) Get(ctx context.Context, key []byte) ([]byte, error) {
-	cmd := &transport.CommandGet{
-		Key: key,
+	var epoch uint64
+	var member string
+	if c.near != nil {
+		if value, ok := c.near.get(key); ok {
+			return value, nil
+		}
+		epoch, member = c.near.begin()
 	}
 
-	_, err := c.conn.Write(cmd.Bytes())
-	if err != nil {
-		return nil, err
+	cmd := &transport.CommandGet{
+		Key:    key,
+		Strong: c.ReadConsistency == ConsistencyStrong,
 	}
 
-	resp, err := transport.ParseGetResponse(c.conn)
+	// Eventual reads of a near cache go to the member streaming its
+	// invalidations, which has applied every write it did not invalidate yet
+	prefer := member
+	if cmd.Strong {
+		prefer = ""
+	}
+	var resp *transport.ResponseGet
+	served, err := c.doOn(ctx, opRead, prefer, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseGetResponse(conn)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.Status == transport.StatusExpired {
-		c.Log.Warn().Msgf("key [%s] expired", key)
+		c.warnf("key [%s] expired", key)
 		return nil, nil
 	}
 
 	if resp.Status == transport.StatusKeyNotFound {
-		c.Log.Warn().Msgf("key [%s] not present", key)
+		c.warnf("key [%s] not present", key)
 		return nil, nil
 	}
 
 	if resp.Status != transport.StatusOK {
-		return nil, fmt.Errorf("server responsed with not OK status [%s]", resp.Status)
+		return nil, statusError(resp.Status)
 	}
 
+	// Other followers may lag behind it, and their values would stay cached
+	// if the invalidation of a newer write arrived before the read began
+	if c.near != nil && (cmd.Strong || served == member) {
+		c.near.store(key, resp.Value, epoch)
+	}
 	return resp.Value, nil
 }
 
 // Put puts the key value pair in the server
 func (c *Client) Put(ctx context.Context, key, value []byte, ttl int) error {
+	return c.PutWithTags(ctx, key, value, ttl)
+}
+
+// PutWithTags puts the key value pair in the server, tagged so it can be
+// deleted along with every other key carrying one of its tags
+func (c *Client) PutWithTags(ctx context.Context, key, value []byte, ttl int, tags ...string) error {
 	cmd := &transport.CommandSet{
 		Key:   key,
 		Value: value,
 		TTL:   ttl,
 	}
-
-	_, err := c.conn.Write(cmd.Bytes())
-	if err != nil {
-		return err
+	for _, tag := range tags {
+		cmd.Tags = append(cmd.Tags, []byte(tag))
 	}
 
-	resp, err := transport.ParseSetResponse(c.conn)
+	var resp *transport.ResponseSet
+	err := c.do(ctx, opWrite, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseSetResponse(conn)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if resp.Status != transport.StatusOK {
-		return fmt.Errorf("server responsed with noe OK status [%s]", resp.Status)
+		return statusError(resp.Status)
+	}
+
+	if c.near != nil {
+		c.near.invalidate(key)
 	}
 	return nil
 }
 
-// Close closes the connection
+// Close closes all connections of the client
 func (c *Client) Close() error {
-	return c.conn.Close()
+	if c.near != nil {
+		c.near.close()
+	}
+	return c.cluster.close()
+}
+
+// opKind classifies an operation for routing, timeouts and retries
+type opKind int
+
+const (
+	// opRead is routed per ReadConsistency and bounded by ReadTimeout
+	opRead opKind = iota
+	// opWrite is routed to the leader, bounded by WriteTimeout and safe to resend
+	opWrite
+	// opWriteOnce is an opWrite that must not be resent once any of it reached a server
+	opWriteOnce
+)
+
+// do runs a request/response exchange against the member chosen for the
+// operation, bounded by the operation timeout if it is earlier than the ctx
+// deadline. When a member cannot be reached the topology is refreshed and the
+// exchange retried on another member after an exponential backoff.
+func (c *Client) do(ctx context.Context, op opKind, fn func(conn net.Conn) error) error {
+	_, err := c.doOn(ctx, op, "", fn)
+	return err
+}
+
+// doOn is do trying member before the member chosen for the operation, unless
+// member is empty or failed, and returns the member that served the exchange
+func (c *Client) doOn(ctx context.Context, op opKind, member string, fn func(conn net.Conn) error) (served string, err error) {
+	ctx, span := c.Tracer.Start(ctx, "client")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	timeout := c.WriteTimeout
+	if op == opRead {
+		timeout = c.ReadTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	failed := make(map[string]bool)
+	var errs []error
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.cluster.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			c.cluster.refresh(ctx)
+		}
+
+		addr := member
+		if addr == "" || failed[addr] {
+			addr = c.pick(op != opRead, failed)
+		}
+		if addr == "" {
+			clear(failed)
+			addr = c.pick(op != opRead, failed)
+		}
+
+		span.SetAttribute("addr", addr)
+		span.SetAttribute("attempts", strconv.Itoa(attempt+1))
+
+		sent := false
+		p, err := c.cluster.pool(addr)
+		if err == nil {
+			sent, err = exchange(ctx, p, fn)
+			if err == nil {
+				return addr, nil
+			}
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrClosed) {
+			return "", err
+		}
+		if sent && op == opWriteOnce {
+			return "", fmt.Errorf("connection failed after the request was sent, it may have been applied: %w", err)
+		}
+
+		errs = append(errs, err)
+		failed[addr] = true
+		c.cluster.forget(addr)
+	}
+	return "", fmt.Errorf("%w: %w", ErrNoNodes, errors.Join(errs...))
+}
+
+// pick returns the first candidate member that has not failed yet
+func (c *Client) pick(write bool, failed map[string]bool) string {
+	for _, addr := range c.cluster.candidates(write) {
+		if !failed[addr] {
+			return addr
+		}
+	}
+	return ""
+}
+
+// exchange runs a single request/response exchange on a pooled connection
+// and reports whether any of the request was sent. The ctx deadline is
+// applied as the socket deadline and cancelling ctx aborts any blocked I/O. A
+// connection is only reused if the exchange completed or never wrote a byte;
+// otherwise it may be left in the middle of a frame and is discarded.
+func exchange(ctx context.Context, p *pool, fn func(conn net.Conn) error) (bool, error) {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := ctx.Err(); err != nil {
+		p.put(pc)
+		return false, err
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if err := pc.SetDeadline(deadline); err != nil {
+		p.discard(pc)
+		return false, err
+	}
+
+	aborted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetDeadline(time.Unix(1, 0))
+		close(aborted)
+	})
+
+	tc := &trackedConn{Conn: pc, span: trace.SpanFromContext(ctx), trace: trace.SpanContextFromContext(ctx)}
+	err = fn(tc)
+	if !stop() {
+		<-aborted
+	}
+	sent := tc.written > 0
+
+	if err != nil {
+		// The socket deadline may trip a moment before ctx notices it expired
+		var netErr net.Error
+		if hasDeadline && errors.As(err, &netErr) && netErr.Timeout() {
+			<-ctx.Done()
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+			if !sent && pc.SetDeadline(time.Time{}) == nil {
+				p.put(pc)
+				return false, err
+			}
+		}
+		p.discard(pc)
+		return sent, err
+	}
+
+	if err := pc.SetDeadline(time.Time{}); err != nil {
+		p.discard(pc)
+		return sent, nil
+	}
+	p.put(pc)
+	return sent, nil
+}
+
+// trackedConn counts the bytes written to a connection during an exchange,
+// and prefixes the request with the trace header when it is traced
+type trackedConn struct {
+	net.Conn
+	written int
+	span    *trace.Span       // Client span named after the command once it is known
+	trace   trace.SpanContext // Propagated to the server, if valid
+}
+
+// Write writes to the underlying connection and records how much was sent
+func (t *trackedConn) Write(b []byte) (int, error) {
+	if t.written > 0 || len(b) == 0 {
+		n, err := t.Conn.Write(b)
+		t.written += n
+		return n, err
+	}
+
+	t.span.SetName("client " + transport.Command(b[0]).String())
+	framed := transport.WithTrace(t.trace, b)
+	n, err := t.Conn.Write(framed)
+	t.written += n
+	// Report only the bytes of b, the header is not the caller's
+	return max(n-(len(framed)-len(b)), 0), err
+}
+
+// statusError returns the error of a response whose status is not OK
+func statusError(status transport.Status) error {
+	switch status {
+	case transport.StatusBusy:
+		return fmt.Errorf("server responsed with status [%s]: %w", status, ErrBusy)
+	case transport.StatusNoAuth:
+		return fmt.Errorf("server responsed with status [%s]: %w", status, ErrNoAuth)
+	}
+	return fmt.Errorf("server responsed with not OK status [%s]", status)
+}
+
+// warnf logs a warning if the client has a logger
+func (c *Client) warnf(format string, v ...any) {
+	if c.Log != nil {
+		c.Log.Warn().Msgf(format, v...)
+	}
 }
@@ -0,0 +1,920 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/trace"
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer is a minimal in-memory discache server speaking the transport protocol
+type fakeServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	items    map[string][]byte
+	versions map[string]uint64
+	version  uint64 // Version of the last write
+	conns    int
+	sets     int
+	gets     int
+	subs     []net.Conn // Connections subscribed to invalidations
+	events   []transport.Event
+	watch    []net.Conn // Connections watching every key
+	leader   string     // Leader reported by cluster info, the server itself when empty
+	peers    []string
+	leases   map[string]uint64 // Token of every held lease
+	renews   int
+	tags     map[string][][]byte // Tags of every key
+	traces   []trace.SpanContext // Trace header of every traced command
+	busy     bool                // Answer sets busy
+	password string              // Required before sets when not empty
+}
+
+// newFakeServer starts a fake server on a random local port
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &fakeServer{ln: ln, items: make(map[string][]byte), versions: make(map[string]uint64)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) addr() string { return s.ln.Addr().String() }
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	password := s.password
+	s.mu.Unlock()
+	authed := password == ""
+	for {
+		cmd, err := transport.ParseCommand(conn)
+		if err != nil {
+			return
+		}
+		if traced, ok := cmd.(*transport.CommandTraced); ok {
+			s.mu.Lock()
+			s.traces = append(s.traces, traced.Context)
+			s.mu.Unlock()
+			cmd = traced.Command
+		}
+		switch v := cmd.(type) {
+		case *transport.CommandAuth:
+			resp := transport.ResponseStatus{Status: transport.StatusOK}
+			authed = string(v.Password) == password
+			if !authed {
+				resp.Status = transport.StatusNoAuth
+			}
+			conn.Write(resp.Bytes())
+		case *transport.CommandSet:
+			if !authed {
+				resp := transport.ResponseSet{Status: transport.StatusNoAuth}
+				conn.Write(resp.Bytes())
+				continue
+			}
+			s.mu.Lock()
+			if s.busy {
+				s.mu.Unlock()
+				resp := transport.ResponseSet{Status: transport.StatusBusy}
+				conn.Write(resp.Bytes())
+				continue
+			}
+			s.version++
+			s.items[string(v.Key)] = v.Value
+			s.versions[string(v.Key)] = s.version
+			if s.tags == nil {
+				s.tags = make(map[string][][]byte)
+			}
+			s.tags[string(v.Key)] = v.Tags
+			s.sets++
+			for _, sub := range s.subs {
+				inv := transport.Invalidation{Key: v.Key}
+				sub.Write(inv.Bytes())
+			}
+			ev := transport.Event{Type: transport.EventSet, Index: uint64(len(s.events) + 1), Key: v.Key}
+			s.events = append(s.events, ev)
+			for _, w := range s.watch {
+				w.Write(ev.Bytes())
+			}
+			s.mu.Unlock()
+			resp := transport.ResponseSet{Status: transport.StatusOK}
+			conn.Write(resp.Bytes())
+		case *transport.CommandGet:
+			s.mu.Lock()
+			value, ok := s.items[string(v.Key)]
+			s.gets++
+			resp := transport.ResponseGet{Status: transport.StatusOK, Value: value, Version: s.versions[string(v.Key)]}
+			s.mu.Unlock()
+			if !ok {
+				resp.Status = transport.StatusKeyNotFound
+			}
+			conn.Write(resp.Bytes())
+		case *transport.CommandTxn:
+			conn.Write(s.txn(v).Bytes())
+		case *transport.CommandLease:
+			conn.Write(s.lease(v).Bytes())
+		case *transport.CommandScan:
+			conn.Write(s.scan(v).Bytes())
+		case *transport.CommandInvalidate:
+			s.mu.Lock()
+			resp := transport.ResponseInvalidate{Status: transport.StatusOK}
+			for key := range s.items {
+				match := strings.HasPrefix(key, string(v.Match))
+				if v.By == transport.InvalidateByTag {
+					match = slices.ContainsFunc(s.tags[key], func(tag []byte) bool { return bytes.Equal(tag, v.Match) })
+				}
+				if match {
+					delete(s.items, key)
+					resp.Count++
+				}
+			}
+			s.mu.Unlock()
+			conn.Write(resp.Bytes())
+		case *transport.CommandClusterInfo:
+			s.mu.Lock()
+			resp := transport.ResponseClusterInfo{Status: transport.StatusOK, Leader: s.leader}
+			if resp.Leader == "" {
+				resp.Leader = s.addr()
+			}
+			for _, addr := range append([]string{s.addr()}, s.peers...) {
+				resp.Members = append(resp.Members, transport.Member{ID: addr, Addr: addr, Voter: true})
+			}
+			s.mu.Unlock()
+			conn.Write(resp.Bytes())
+		case *transport.CommandInvalidations:
+			s.mu.Lock()
+			resp := transport.ResponseStatus{Status: transport.StatusOK}
+			conn.Write(resp.Bytes())
+			s.subs = append(s.subs, conn)
+			s.mu.Unlock()
+		case *transport.CommandWatch:
+			s.mu.Lock()
+			resp := transport.ResponseStatus{Status: transport.StatusOK}
+			conn.Write(resp.Bytes())
+			for _, ev := range s.events {
+				if v.FromIndex > 0 && ev.Index >= v.FromIndex {
+					conn.Write(ev.Bytes())
+				}
+			}
+			s.watch = append(s.watch, conn)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// txn applies a transaction if its version guards hold
+func (s *fakeServer) txn(cmd *transport.CommandTxn) *transport.ResponseTxn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, check := range cmd.Checks {
+		current := s.versions[string(check.Key)]
+		if (check.Cond == transport.CondVersion && current != check.Version) ||
+			(check.Cond == transport.CondAbsent && current != 0) ||
+			(check.Cond == transport.CondPresent && current == 0) {
+			return &transport.ResponseTxn{Status: transport.StatusConflict, Version: current, Key: check.Key}
+		}
+	}
+
+	s.version++
+	for _, op := range cmd.Ops {
+		if op.Type == transport.TxnDelete {
+			delete(s.items, string(op.Key))
+			delete(s.versions, string(op.Key))
+			continue
+		}
+		s.items[string(op.Key)] = op.Value
+		s.versions[string(op.Key)] = s.version
+	}
+	return &transport.ResponseTxn{Status: transport.StatusOK, Version: s.version}
+}
+
+// lease applies a lease command, granting the next version as token
+// scan returns the page of keys after the cursor, ignoring Match
+func (s *fakeServer) scan(cmd *transport.CommandScan) *transport.ResponseScan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.items {
+		if key > string(cmd.Cursor) && strings.HasPrefix(key, string(cmd.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	count := int(cmd.Count)
+	if count <= 0 {
+		count = 100
+	}
+	resp := &transport.ResponseScan{Status: transport.StatusOK, Keys: [][]byte{}}
+	if len(keys) > count {
+		keys = keys[:count]
+		resp.Cursor = []byte(keys[len(keys)-1])
+	}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, []byte(key))
+	}
+	return resp
+}
+
+func (s *fakeServer) lease(cmd *transport.CommandLease) *transport.ResponseLease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leases == nil {
+		s.leases = make(map[string]uint64)
+	}
+	token, held := s.leases[string(cmd.Name)]
+	switch {
+	case cmd.Op == transport.LeaseAcquire && held:
+		return &transport.ResponseLease{Status: transport.StatusConflict, Token: token}
+	case cmd.Op == transport.LeaseAcquire:
+		s.version++
+		s.leases[string(cmd.Name)] = s.version
+		return &transport.ResponseLease{Status: transport.StatusOK, Token: s.version, TTL: cmd.TTL}
+	case !held || token != cmd.Token:
+		return &transport.ResponseLease{Status: transport.StatusKeyNotFound}
+	case cmd.Op == transport.LeaseRelease:
+		delete(s.leases, string(cmd.Name))
+	default:
+		s.renews++
+	}
+	return &transport.ResponseLease{Status: transport.StatusOK, Token: token, TTL: cmd.TTL}
+}
+
+// onlyPool returns the connection pool of a client talking to a single node
+func onlyPool(t *testing.T, c *Client) *pool {
+	c.cluster.mu.RLock()
+	defer c.cluster.mu.RUnlock()
+	assert.Len(t, c.cluster.pools, 1)
+	for _, p := range c.cluster.pools {
+		return p
+	}
+	return nil
+}
+
+// TestNewReturnsDialError tests that New returns an error instead of exiting
+func TestNewReturnsDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := New(addr, Options{DialTimeout: time.Second})
+	assert.NotNil(t, err)
+	assert.Nil(t, c)
+}
+
+// TestClientConcurrentUse tests that a client can be shared across goroutines
+func TestClientConcurrentUse(t *testing.T) {
+	srv := newFakeServer(t)
+
+	c, err := New(srv.addr(), Options{MaxConns: 4})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("key_%d", i))
+			value := []byte(fmt.Sprintf("value_%d", i))
+
+			assert.Nil(t, c.Put(context.Background(), key, value, 0))
+			got, err := c.Get(context.Background(), key)
+			assert.Nil(t, err)
+			assert.Equal(t, value, got)
+		}(i)
+	}
+	wg.Wait()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.LessOrEqual(t, srv.conns, 4)
+}
+
+// TestPoolReapsDeadConnections tests that the health check drops closed connections
+func TestPoolReapsDeadConnections(t *testing.T) {
+	client, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+
+	c := NewFromConn(client)
+	server.Close()
+
+	p := onlyPool(t, c)
+	p.reapOnce()
+	assert.Empty(t, p.idle)
+
+	_, err := c.Get(context.Background(), []byte("foo"))
+	assert.NotNil(t, err)
+}
+
+// newSilentServer starts a server that accepts commands but never responds
+func newSilentServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// TestClientHonorsContextDeadline tests that a hung server does not block the caller past ctx
+func TestClientHonorsContextDeadline(t *testing.T) {
+	c, err := New(newSilentServer(t), Options{MaxConns: 1, DialTimeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The request was written so the connection was dropped rather than reused
+	p := onlyPool(t, c)
+	p.mu.Lock()
+	assert.Empty(t, p.idle)
+	p.mu.Unlock()
+}
+
+// TestClientReturnsErrBusy tests that busy responses are reported as ErrBusy
+func TestClientReturnsErrBusy(t *testing.T) {
+	s := newFakeServer(t)
+	s.busy = true
+	c, err := New(s.addr(), Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	err = c.Put(context.Background(), []byte("foo"), []byte("bar"), 0)
+	assert.ErrorIs(t, err, ErrBusy)
+}
+
+// TestClientAuthenticates tests that every connection is authenticated with
+// the password and a wrong one is reported as ErrNoAuth
+func TestClientAuthenticates(t *testing.T) {
+	s := newFakeServer(t)
+	s.mu.Lock()
+	s.password = "secret"
+	s.mu.Unlock()
+
+	c, err := New(s.addr(), Options{Password: "secret"})
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Nil(t, c.Put(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	_, err = New(s.addr(), Options{Password: "wrong"})
+	assert.ErrorIs(t, err, ErrNoAuth)
+}
+
+// TestClientDefaultTimeout tests that per-operation default timeouts apply without a ctx deadline
+func TestClientDefaultTimeout(t *testing.T) {
+	c, err := New(newSilentServer(t), Options{WriteTimeout: 50 * time.Millisecond, DialTimeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	err = c.Put(context.Background(), []byte("foo"), []byte("bar"), 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestClientCancelledBeforeWriteKeepsConn tests that cancelling before anything is sent keeps the connection
+func TestClientCancelledBeforeWriteKeepsConn(t *testing.T) {
+	srv := newFakeServer(t)
+
+	c, err := New(srv.addr(), Options{MaxConns: 1})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.Nil(t, c.Put(context.Background(), []byte("foo"), []byte("bar"), 0))
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.Equal(t, 1, srv.conns)
+}
+
+// TestClusterRoutesWritesToLeader tests that writes go to the discovered leader
+func TestClusterRoutesWritesToLeader(t *testing.T) {
+	follower := newFakeServer(t)
+	leader := newFakeServer(t)
+	follower.leader = leader.addr()
+	follower.peers = []string{leader.addr()}
+	leader.peers = []string{follower.addr()}
+
+	c, err := NewCluster([]string{follower.addr()}, Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Put(context.Background(), []byte("foo"), []byte("bar"), 0))
+	}
+
+	leader.mu.Lock()
+	defer leader.mu.Unlock()
+	assert.Equal(t, 10, leader.sets)
+}
+
+// TestClusterFailover tests that the client moves to another member when one goes away
+func TestClusterFailover(t *testing.T) {
+	first := newFakeServer(t)
+	second := newFakeServer(t)
+	first.peers = []string{second.addr()}
+	second.leader = second.addr()
+	second.peers = []string{first.addr()}
+
+	c, err := NewCluster([]string{first.addr(), second.addr()}, Options{RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	assert.Nil(t, c.Put(context.Background(), []byte("foo"), []byte("bar"), 0))
+
+	// Kill the leader along with its pooled connections
+	first.ln.Close()
+	c.cluster.mu.Lock()
+	for _, p := range c.cluster.pools {
+		p.close()
+	}
+	c.cluster.pools = make(map[string]*pool)
+	c.cluster.mu.Unlock()
+
+	assert.Nil(t, c.Put(context.Background(), []byte("foo"), []byte("baz"), 0))
+
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	assert.Equal(t, []byte("baz"), second.items["foo"])
+}
+
+// waitNearCacheLive waits until the near cache of c is subscribed to invalidations
+func waitNearCacheLive(t *testing.T, c *Client) {
+	assert.Eventually(t, func() bool {
+		c.near.mu.Lock()
+		defer c.near.mu.Unlock()
+		return c.near.member != ""
+	}, time.Second, time.Millisecond)
+}
+
+// TestNearCacheInvalidatedByOtherClient tests that a write on one client invalidates the near cache of another
+func TestNearCacheInvalidatedByOtherClient(t *testing.T) {
+	srv := newFakeServer(t)
+
+	reader, err := New(srv.addr(), Options{NearCacheSize: 16})
+	assert.Nil(t, err)
+	defer reader.Close()
+	waitNearCacheLive(t, reader)
+
+	writer, err := New(srv.addr(), Options{})
+	assert.Nil(t, err)
+	defer writer.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, writer.Put(ctx, []byte("foo"), []byte("v1"), 0))
+
+	// The first read may race with the invalidation of the write above and not be cached
+	assert.Eventually(t, func() bool {
+		reader.Get(ctx, []byte("foo"))
+		_, ok := reader.near.get([]byte("foo"))
+		return ok
+	}, time.Second, time.Millisecond)
+
+	srv.mu.Lock()
+	gets := srv.gets
+	srv.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		value, err := reader.Get(ctx, []byte("foo"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), value)
+	}
+	srv.mu.Lock()
+	assert.Equal(t, gets, srv.gets, "repeated reads should be served by the near cache")
+	srv.mu.Unlock()
+
+	assert.Nil(t, writer.Put(ctx, []byte("foo"), []byte("v2"), 0))
+	assert.Eventually(t, func() bool {
+		value, err := reader.Get(ctx, []byte("foo"))
+		return err == nil && string(value) == "v2"
+	}, time.Second, time.Millisecond)
+}
+
+// TestNearCacheFlushedWhenStreamLost tests that values are not served while invalidations can not be received
+func TestNearCacheFlushedWhenStreamLost(t *testing.T) {
+	srv := newFakeServer(t)
+
+	c, err := New(srv.addr(), Options{NearCacheSize: 16, RetryBackoff: time.Hour, MaxRetryBackoff: time.Hour})
+	assert.Nil(t, err)
+	defer c.Close()
+	waitNearCacheLive(t, c)
+
+	ctx := context.Background()
+	assert.Nil(t, c.Put(ctx, []byte("foo"), []byte("bar"), 0))
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+
+	srv.mu.Lock()
+	for _, sub := range srv.subs {
+		sub.Close()
+	}
+	srv.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		_, ok := c.near.get([]byte("foo"))
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+// TestNearCacheReadsFromStreamingMember tests that eventual reads of a near
+// cache go to the member streaming its invalidations, so a lagging member
+// cannot fill it with a value no invalidation will ever drop
+func TestNearCacheReadsFromStreamingMember(t *testing.T) {
+	first := newFakeServer(t)
+	second := newFakeServer(t)
+	first.peers = []string{second.addr()}
+	second.peers = []string{first.addr()}
+
+	c, err := NewCluster([]string{first.addr(), second.addr()}, Options{NearCacheSize: 16})
+	assert.Nil(t, err)
+	defer c.Close()
+	waitNearCacheLive(t, c)
+
+	c.near.mu.Lock()
+	streaming, lagging := first, second
+	if c.near.member == second.addr() {
+		streaming, lagging = second, first
+	}
+	c.near.mu.Unlock()
+	streaming.mu.Lock()
+	streaming.items["foo"] = []byte("v2")
+	streaming.mu.Unlock()
+	lagging.mu.Lock()
+	lagging.items["foo"] = []byte("v1")
+	lagging.mu.Unlock()
+
+	for i := 0; i < 4; i++ {
+		value, err := c.Get(context.Background(), []byte("foo"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2"), value)
+	}
+	lagging.mu.Lock()
+	defer lagging.mu.Unlock()
+	assert.Zero(t, lagging.gets)
+}
+
+// TestWatchResumesAfterStreamLoss tests that a watch delivers every event exactly once across reconnects
+func TestWatchResumesAfterStreamLoss(t *testing.T) {
+	srv := newFakeServer(t)
+
+	c, err := New(srv.addr(), Options{RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	w, err := c.Watch(context.Background(), []byte(""), WatchOptions{Prefix: true})
+	assert.Nil(t, err)
+	defer w.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Put(ctx, []byte("a"), []byte("1"), 0))
+	assert.Nil(t, c.Put(ctx, []byte("b"), []byte("2"), 0))
+
+	for _, want := range []string{"a", "b"} {
+		ev := <-w.Events
+		assert.Equal(t, transport.EventSet, ev.Type)
+		assert.Equal(t, want, string(ev.Key))
+	}
+
+	// Break the stream, the watch resumes and must not redeliver a or b
+	srv.mu.Lock()
+	for _, conn := range srv.watch {
+		conn.Close()
+	}
+	srv.watch = nil
+	srv.mu.Unlock()
+
+	assert.Nil(t, c.Put(ctx, []byte("c"), []byte("3"), 0))
+	select {
+	case ev := <-w.Events:
+		assert.Equal(t, "c", string(ev.Key))
+		assert.Equal(t, uint64(3), ev.Index)
+	case <-time.After(time.Second):
+		t.Fatal("watch did not resume")
+	}
+
+	w.Close()
+	for range w.Events {
+	}
+	assert.Nil(t, w.Err())
+}
+
+// TestWatchResumeIgnoresLocalEvents tests that expirations and evictions,
+// which are not replayed, do not shift the position a watch resumes from
+func TestWatchResumeIgnoresLocalEvents(t *testing.T) {
+	srv := newFakeServer(t)
+
+	c, err := New(srv.addr(), Options{RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	w, err := c.Watch(context.Background(), []byte(""), WatchOptions{Prefix: true})
+	assert.Nil(t, err)
+	defer w.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Put(ctx, []byte("a"), []byte("1"), 0))
+	assert.Equal(t, "a", string((<-w.Events).Key))
+
+	// The server expires a key while it applies the next write, without retaining the event
+	srv.mu.Lock()
+	expire := transport.Event{Type: transport.EventExpire, Index: 2, Key: []byte("x")}
+	for _, conn := range srv.watch {
+		conn.Write(expire.Bytes())
+	}
+	srv.mu.Unlock()
+	assert.Equal(t, transport.EventExpire, (<-w.Events).Type)
+
+	srv.mu.Lock()
+	for _, conn := range srv.watch {
+		conn.Close()
+	}
+	srv.watch = nil
+	srv.mu.Unlock()
+
+	// b shares the index of the expiration and must still be delivered
+	assert.Nil(t, c.Put(ctx, []byte("b"), []byte("2"), 0))
+	select {
+	case ev := <-w.Events:
+		assert.Equal(t, "b", string(ev.Key))
+	case <-time.After(time.Second):
+		t.Fatal("watch did not resume")
+	}
+}
+
+// TestTxnRetriesUnderContention tests that clients racing optimistic
+// transactions on the same keys see conflicts and lose no update
+func TestTxnRetriesUnderContention(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var conflicts atomic.Int64
+	for i := 0; i < 4; i++ {
+		c, err := New(s.addr(), Options{})
+		assert.Nil(t, err)
+		defer c.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; {
+				value, version, err := c.GetWithVersion(ctx, []byte("count"))
+				assert.Nil(t, err)
+				count, _ := strconv.Atoi(string(value))
+				next := []byte(strconv.Itoa(count + 1))
+
+				txn := c.Txn().Set([]byte("count"), next, 0).Set([]byte("count:copy"), next, 0)
+				if version == 0 {
+					txn.IfAbsent([]byte("count"))
+				} else {
+					txn.IfVersion([]byte("count"), version)
+				}
+				_, err = txn.Commit(ctx)
+				if errors.Is(err, ErrConflict) {
+					var conflict *ConflictError
+					assert.True(t, errors.As(err, &conflict))
+					assert.Equal(t, []byte("count"), conflict.Key)
+					conflicts.Add(1)
+					continue
+				}
+				assert.Nil(t, err)
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, []byte("200"), s.items["count"])
+	assert.Equal(t, s.items["count"], s.items["count:copy"])
+	assert.Equal(t, s.versions["count"], s.versions["count:copy"])
+	t.Logf("%d conflicts retried", conflicts.Load())
+}
+
+// TestLeaseKeptAliveUntilLost tests that a lease is renewed in the background,
+// excludes other owners and signals when the server no longer grants it
+func TestLeaseKeptAliveUntilLost(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+
+	c, err := New(s.addr(), Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	l, err := c.Acquire(ctx, []byte("nightly"), 150*time.Millisecond)
+	assert.Nil(t, err)
+
+	other, err := New(s.addr(), Options{})
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = other.Acquire(ctx, []byte("nightly"), time.Second)
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+
+	// Outlive the TTL a few times over while renewals keep the lease
+	select {
+	case <-l.Lost():
+		t.Fatalf("lease lost while renewed: %v", l.Err())
+	case <-time.After(400 * time.Millisecond):
+	}
+	s.mu.Lock()
+	assert.GreaterOrEqual(t, s.renews, 2)
+	// Expire the lease on the server, the next renew notices the loss
+	delete(s.leases, "nightly")
+	s.mu.Unlock()
+
+	select {
+	case <-l.Lost():
+		assert.ErrorIs(t, l.Err(), ErrLeaseLost)
+	case <-time.After(time.Second):
+		t.Fatal("lease loss not signaled")
+	}
+
+	taken, err := other.Acquire(ctx, []byte("nightly"), time.Second)
+	assert.Nil(t, err)
+	assert.Greater(t, taken.Token, l.Token)
+	assert.Nil(t, taken.Release(ctx))
+	assert.ErrorIs(t, l.Release(ctx), ErrLeaseLost)
+}
+
+// TestLeaseLostWhenServerUnreachable tests that a lease counts as lost once
+// it could not be renewed within its TTL
+func TestLeaseLostWhenServerUnreachable(t *testing.T) {
+	s := newFakeServer(t)
+	c, err := New(s.addr(), Options{MaxRetries: -1})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	l, err := c.Acquire(context.Background(), []byte("nightly"), 100*time.Millisecond)
+	assert.Nil(t, err)
+	s.ln.Close()
+	onlyPool(t, c).close()
+
+	select {
+	case <-l.Lost():
+		assert.ErrorIs(t, l.Err(), ErrLeaseLost)
+	case <-time.After(time.Second):
+		t.Fatal("lease loss not signaled")
+	}
+}
+
+// TestInvalidateTagFlushesNearCache tests that a bulk invalidation deletes the
+// tagged keys and drops them from the near cache of the client that sent it
+func TestInvalidateTagFlushesNearCache(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+
+	c, err := New(s.addr(), Options{NearCacheSize: 10})
+	assert.Nil(t, err)
+	defer c.Close()
+	waitNearCacheLive(t, c)
+
+	assert.Nil(t, c.PutWithTags(ctx, []byte("product:1"), []byte("shoe"), 0, "p1"))
+	assert.Nil(t, c.PutWithTags(ctx, []byte("list:new"), []byte("shoe,hat"), 0, "p1", "p2"))
+	assert.Nil(t, c.Put(ctx, []byte("list:old"), []byte("hat"), 0))
+	assert.Eventually(t, func() bool {
+		c.Get(ctx, []byte("product:1"))
+		_, ok := c.near.get([]byte("product:1"))
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	n, err := c.InvalidateTag(ctx, "p1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	value, err := c.Get(ctx, []byte("product:1"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	n, err = c.InvalidatePrefix(ctx, []byte("list:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+// TestKeysPagesUnderConcurrentWrites tests that iterating keys returns every
+// key present during the whole iteration exactly once while keys change
+func TestKeysPagesUnderConcurrentWrites(t *testing.T) {
+	s := newFakeServer(t)
+	ctx := context.Background()
+
+	c, err := New(s.addr(), Options{})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, c.Put(ctx, []byte(fmt.Sprintf("user:%02d", i)), []byte("v"), 0))
+	}
+	assert.Nil(t, c.Put(ctx, []byte("order:1"), []byte("v"), 0))
+
+	var keys []string
+	for key, err := range c.Keys(ctx, ScanOptions{Prefix: []byte("user:"), Count: 10}) {
+		assert.Nil(t, err)
+		keys = append(keys, string(key))
+		if len(keys) == 5 {
+			// Move a key behind the cursor and delete one ahead of it
+			assert.Nil(t, c.Put(ctx, []byte("user:00a"), []byte("v"), 0))
+			s.mu.Lock()
+			delete(s.items, "user:20")
+			s.mu.Unlock()
+		}
+	}
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		if i != 20 {
+			want = append(want, fmt.Sprintf("user:%02d", i))
+		}
+	}
+	assert.Equal(t, want, keys)
+}
+
+// TestTraceContextPropagated tests that requests carry the context of the
+// client span, or of the caller span when the client does not trace
+func TestTraceContextPropagated(t *testing.T) {
+	s := newFakeServer(t)
+	exporter := trace.NewInMemoryExporter()
+	tracer := trace.NewTracer(trace.TracerOpts{Exporter: exporter})
+
+	c, err := New(s.addr(), Options{Tracer: tracer})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	assert.Nil(t, c.Put(ctx, []byte("foo"), []byte("bar"), 0))
+	_, err = c.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	parent.End()
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "client SET", spans[0].Name)
+	assert.Equal(t, "client GET", spans[1].Name)
+	assert.Equal(t, s.addr(), spans[1].Attributes["addr"])
+	s.mu.Lock()
+	assert.Equal(t, []trace.SpanContext{spans[0].Context(), spans[1].Context()}, s.traces)
+	s.mu.Unlock()
+	for _, span := range spans[:2] {
+		assert.Equal(t, parent.Context().TraceID, span.TraceID)
+		assert.Equal(t, parent.Context().SpanID, span.Parent)
+	}
+
+	// A client without a tracer still propagates the span of the caller
+	untraced, err := New(s.addr(), Options{})
+	assert.Nil(t, err)
+	defer untraced.Close()
+	_, err = untraced.Get(ctx, []byte("foo"))
+	assert.Nil(t, err)
+	_, err = untraced.Get(context.Background(), []byte("foo"))
+	assert.Nil(t, err)
+
+	s.mu.Lock()
+	assert.Len(t, s.traces, 3)
+	assert.Equal(t, parent.Context(), s.traces[2])
+	s.mu.Unlock()
+}
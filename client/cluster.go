@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+const (
+	defaultMaxRetries      = 3
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultMaxRetryBackoff = 2 * time.Second
+	defaultRefreshInterval = 30 * time.Second
+)
+
+// Consistency selects which cluster members may serve reads
+type Consistency int
+
+const (
+	// ConsistencyEventual serves reads from any member, possibly returning stale values
+	ConsistencyEventual Consistency = iota
+	// ConsistencyStrong serves reads from the leader only
+	ConsistencyStrong
+)
+
+// ErrNoNodes is returned when no cluster member could be reached
+var ErrNoNodes = errors.New("no reachable discache nodes")
+
+// cluster tracks the members of a discache cluster and a connection pool per member
+type cluster struct {
+	opts  Options
+	seeds []string
+
+	mu      sync.RWMutex
+	pools   map[string]*pool
+	members []string // Client addresses of the known members
+	leader  string   // Client address of the leader, empty when unknown
+
+	next       atomic.Uint32 // Round robin cursor for eventual reads
+	refreshing atomic.Bool
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// newCluster creates a cluster from a seed list and discovers its members. A
+// seed that does not support discovery is used as a plain single endpoint.
+func newCluster(seeds []string, opts Options) (*cluster, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("at least one seed endpoint is required")
+	}
+
+	c := &cluster{
+		opts:    opts,
+		seeds:   slices.Clone(seeds),
+		pools:   make(map[string]*pool),
+		members: slices.Clone(seeds),
+		done:    make(chan struct{}),
+	}
+
+	var errs []error
+	for _, seed := range seeds {
+		if _, err := c.pool(seed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(seeds) {
+		return nil, errors.Join(errs...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+	c.refresh(ctx)
+	cancel()
+
+	if opts.RefreshInterval > 0 {
+		go c.refreshLoop()
+	}
+	return c, nil
+}
+
+// pool returns the connection pool for addr, creating it on first use
+func (c *cluster) pool(addr string) (*pool, error) {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	connect := func(ctx context.Context) (net.Conn, error) {
+		return dial(ctx, c.opts, addr)
+	}
+	p, err := newPool(c.opts, connect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to [%s]: %w", addr, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.pools[addr]; ok {
+		p.close()
+		return existing, nil
+	}
+	select {
+	case <-c.done:
+		p.close()
+		return nil, ErrClosed
+	default:
+	}
+	c.pools[addr] = p
+	return p, nil
+}
+
+// candidates returns the members to try in order. Writes and strong reads go
+// to the leader first; eventual reads are spread round robin over all members.
+func (c *cluster) candidates(write bool) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	addrs := slices.Clone(c.members)
+	if len(addrs) == 0 {
+		addrs = slices.Clone(c.seeds)
+	}
+
+	if write || c.opts.ReadConsistency == ConsistencyStrong {
+		if c.leader != "" {
+			addrs = slices.DeleteFunc(addrs, func(a string) bool { return a == c.leader })
+			addrs = append([]string{c.leader}, addrs...)
+		}
+		return addrs
+	}
+
+	n := int(c.next.Add(1) % uint32(len(addrs)))
+	return slices.Concat(addrs[n:], addrs[:n])
+}
+
+// refresh asks the known members for the cluster topology and adopts the
+// first answer. Members that have left the cluster have their pools closed.
+func (c *cluster) refresh(ctx context.Context) error {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer c.refreshing.Store(false)
+
+	c.mu.RLock()
+	addrs := slices.Clone(c.members)
+	if c.leader != "" {
+		addrs = append([]string{c.leader}, addrs...)
+	}
+	c.mu.RUnlock()
+	for _, seed := range c.seeds {
+		if !slices.Contains(addrs, seed) {
+			addrs = append(addrs, seed)
+		}
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		info, err := c.clusterInfo(ctx, addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.update(info)
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// clusterInfo queries a single member for the cluster topology
+func (c *cluster) clusterInfo(ctx context.Context, addr string) (*transport.ResponseClusterInfo, error) {
+	p, err := c.pool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &transport.CommandClusterInfo{}
+	var resp *transport.ResponseClusterInfo
+	_, err = exchange(ctx, p, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseClusterInfoResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != transport.StatusOK || len(resp.Members) == 0 {
+		return nil, fmt.Errorf("[%s] responded to cluster info with status [%s]", addr, resp.Status)
+	}
+	return resp, nil
+}
+
+// update replaces the known topology with info
+func (c *cluster) update(info *transport.ResponseClusterInfo) {
+	members := make([]string, 0, len(info.Members))
+	for _, m := range info.Members {
+		members = append(members, m.Addr)
+	}
+
+	c.mu.Lock()
+	c.members = members
+	c.leader = info.Leader
+	var stale []*pool
+	for addr, p := range c.pools {
+		if !slices.Contains(members, addr) && !slices.Contains(c.seeds, addr) {
+			stale = append(stale, p)
+			delete(c.pools, addr)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, p := range stale {
+		p.close()
+	}
+}
+
+// forget drops what is known about a failed member so it is rediscovered
+func (c *cluster) forget(addr string) {
+	c.mu.Lock()
+	if c.leader == addr {
+		c.leader = ""
+	}
+	c.mu.Unlock()
+}
+
+// refreshLoop periodically refreshes the topology until the cluster is closed
+func (c *cluster) refreshLoop() {
+	ticker := time.NewTicker(c.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.opts.DialTimeout)
+			if err := c.refresh(ctx); err != nil && c.opts.Log != nil {
+				c.opts.Log.Warn().Msgf("failed to refresh discache cluster topology: %s", err.Error())
+			}
+			cancel()
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt n, exponential with full jitter
+func (c *cluster) backoff(attempt int) time.Duration {
+	d := c.opts.RetryBackoff << attempt
+	if d <= 0 || d > c.opts.MaxRetryBackoff {
+		d = c.opts.MaxRetryBackoff
+	}
+	return rand.N(d) + 1
+}
+
+// close closes every member pool and stops the refresh loop
+func (c *cluster) close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	c.mu.Lock()
+	pools := c.pools
+	c.pools = make(map[string]*pool)
+	c.mu.Unlock()
+
+	var errs []error
+	for _, p := range pools {
+		if err := p.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
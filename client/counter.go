@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// IncrOptions configures how a missing counter is created
+type IncrOptions struct {
+	// Initial is the value a missing key holds before the delta is added
+	Initial int64
+	// TTL in seconds of a key created by the increment, 0 uses the server default
+	TTL int
+}
+
+// Incr atomically increments the integer stored at key by one and returns the new value
+func (c *Client) Incr(ctx context.Context, key []byte) (int64, error) {
+	return c.IncrBy(ctx, key, 1, IncrOptions{})
+}
+
+// Decr atomically decrements the integer stored at key by one and returns the new value
+func (c *Client) Decr(ctx context.Context, key []byte) (int64, error) {
+	return c.IncrBy(ctx, key, -1, IncrOptions{})
+}
+
+// IncrBy atomically adds delta to the integer stored at key and returns the
+// new value. The increment is applied through raft so it is linearizable
+// across the cluster. It is never resent once it reached a server, so an
+// error may be returned for an increment that was applied.
+func (c *Client) IncrBy(ctx context.Context, key []byte, delta int64, opts IncrOptions) (int64, error) {
+	cmd := &transport.CommandIncr{
+		Key:     key,
+		Delta:   delta,
+		Initial: opts.Initial,
+		TTL:     opts.TTL,
+	}
+
+	var resp *transport.ResponseIncr
+	err := c.do(ctx, opWriteOnce, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseIncrResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if c.near != nil {
+		c.near.invalidate(key)
+	}
+	if resp.Status != transport.StatusOK {
+		return 0, statusError(resp.Status)
+	}
+	return resp.Value, nil
+}
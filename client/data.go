@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// ErrWrongType is returned when a command runs on a key holding another kind of value
+var ErrWrongType = errors.New("key holds the wrong kind of value")
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// HSet sets field of the hash stored at key and reports whether the field is new
+func (c *Client) HSet(ctx context.Context, key, field, value []byte) (bool, error) {
+	n, err := c.dataInt(ctx, transport.OpHSet, key, field, value)
+	return n == 1, err
+}
+
+// HGet returns field of the hash stored at key, nil if it does not exist
+func (c *Client) HGet(ctx context.Context, key, field []byte) ([]byte, error) {
+	return c.dataValue(ctx, transport.OpHGet, key, field)
+}
+
+// HDel removes fields from the hash stored at key and returns how many existed
+func (c *Client) HDel(ctx context.Context, key []byte, fields ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpHDel, key, fields...)
+}
+
+// HGetAll returns every field of the hash stored at key
+func (c *Client) HGetAll(ctx context.Context, key []byte) (map[string][]byte, error) {
+	resp, err := c.data(ctx, transport.OpHGetAll, key)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string][]byte)
+	for i := 0; resp != nil && i+1 < len(resp.Values); i += 2 {
+		fields[string(resp.Values[i])] = resp.Values[i+1]
+	}
+	return fields, nil
+}
+
+// HLen returns the number of fields of the hash stored at key
+func (c *Client) HLen(ctx context.Context, key []byte) (int, error) {
+	return c.dataInt(ctx, transport.OpHLen, key)
+}
+
+// LPush prepends values to the list stored at key and returns its length
+func (c *Client) LPush(ctx context.Context, key []byte, values ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpLPush, key, values...)
+}
+
+// RPush appends values to the list stored at key and returns its length
+func (c *Client) RPush(ctx context.Context, key []byte, values ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpRPush, key, values...)
+}
+
+// LPop removes and returns the first value of the list stored at key, nil if it is empty
+func (c *Client) LPop(ctx context.Context, key []byte) ([]byte, error) {
+	return c.pop(ctx, transport.OpLPop, key)
+}
+
+// RPop removes and returns the last value of the list stored at key, nil if it is empty
+func (c *Client) RPop(ctx context.Context, key []byte) ([]byte, error) {
+	return c.pop(ctx, transport.OpRPop, key)
+}
+
+// LRange returns the values of the list stored at key between start and stop
+// inclusive. Negative indexes count from the end of the list.
+func (c *Client) LRange(ctx context.Context, key []byte, start, stop int) ([][]byte, error) {
+	resp, err := c.data(ctx, transport.OpLRange, key, []byte(strconv.Itoa(start)), []byte(strconv.Itoa(stop)))
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// LLen returns the length of the list stored at key
+func (c *Client) LLen(ctx context.Context, key []byte) (int, error) {
+	return c.dataInt(ctx, transport.OpLLen, key)
+}
+
+// SAdd adds members to the set stored at key and returns how many were new
+func (c *Client) SAdd(ctx context.Context, key []byte, members ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpSAdd, key, members...)
+}
+
+// SRem removes members from the set stored at key and returns how many existed
+func (c *Client) SRem(ctx context.Context, key []byte, members ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpSRem, key, members...)
+}
+
+// SMembers returns the members of the set stored at key in lexical order
+func (c *Client) SMembers(ctx context.Context, key []byte) ([][]byte, error) {
+	resp, err := c.data(ctx, transport.OpSMembers, key)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// SIsMember reports whether member belongs to the set stored at key
+func (c *Client) SIsMember(ctx context.Context, key, member []byte) (bool, error) {
+	n, err := c.dataInt(ctx, transport.OpSIsMember, key, member)
+	return n == 1, err
+}
+
+// SCard returns the number of members of the set stored at key
+func (c *Client) SCard(ctx context.Context, key []byte) (int, error) {
+	return c.dataInt(ctx, transport.OpSCard, key)
+}
+
+// ZAdd adds members to the sorted set stored at key, updating the score of
+// existing ones, and returns how many were new
+func (c *Client) ZAdd(ctx context.Context, key []byte, members ...ZMember) (int, error) {
+	args := make([][]byte, 0, 2*len(members))
+	for _, m := range members {
+		args = append(args, transport.FormatScore(m.Score), m.Member)
+	}
+	return c.dataInt(ctx, transport.OpZAdd, key, args...)
+}
+
+// ZRem removes members from the sorted set stored at key and returns how many existed
+func (c *Client) ZRem(ctx context.Context, key []byte, members ...[]byte) (int, error) {
+	return c.dataInt(ctx, transport.OpZRem, key, members...)
+}
+
+// ZScore returns the score of member in the sorted set stored at key and
+// reports whether it exists
+func (c *Client) ZScore(ctx context.Context, key, member []byte) (float64, bool, error) {
+	value, err := c.dataValue(ctx, transport.OpZScore, key, member)
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+	score, err := transport.ParseScore(value)
+	if err != nil {
+		return 0, false, err
+	}
+	return score, true, nil
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key with a
+// score between minScore and maxScore inclusive, ordered by score then member
+func (c *Client) ZRangeByScore(ctx context.Context, key []byte, minScore, maxScore float64) ([]ZMember, error) {
+	resp, err := c.data(ctx, transport.OpZRangeByScore, key, transport.FormatScore(minScore), transport.FormatScore(maxScore))
+	if err != nil || resp == nil {
+		return nil, err
+	}
+
+	members := []ZMember{}
+	for i := 0; i+1 < len(resp.Values); i += 2 {
+		score, err := transport.ParseScore(resp.Values[i+1])
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: resp.Values[i], Score: score})
+	}
+	return members, nil
+}
+
+// ZCard returns the number of members of the sorted set stored at key
+func (c *Client) ZCard(ctx context.Context, key []byte) (int, error) {
+	return c.dataInt(ctx, transport.OpZCard, key)
+}
+
+// pop removes a value from the head or the tail of a list
+func (c *Client) pop(ctx context.Context, op transport.DataOp, key []byte) ([]byte, error) {
+	return c.dataValue(ctx, op, key)
+}
+
+// dataValue runs a data command answered with a single value
+func (c *Client) dataValue(ctx context.Context, op transport.DataOp, key []byte, args ...[]byte) ([]byte, error) {
+	resp, err := c.data(ctx, op, key, args...)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	if len(resp.Values) != 1 {
+		return nil, fmt.Errorf("server responsed with %d values to %s", len(resp.Values), op)
+	}
+	return resp.Values[0], nil
+}
+
+// dataInt runs a data command answered with a count
+func (c *Client) dataInt(ctx context.Context, op transport.DataOp, key []byte, args ...[]byte) (int, error) {
+	resp, err := c.data(ctx, op, key, args...)
+	if err != nil || resp == nil {
+		return 0, err
+	}
+	return int(resp.Int), nil
+}
+
+// data runs a data command and returns its response, nil when the key or
+// field does not exist. Writes are never resent once they reached a server,
+// since pushes and pops are not idempotent.
+func (c *Client) data(ctx context.Context, op transport.DataOp, key []byte, args ...[]byte) (*transport.ResponseData, error) {
+	cmd := &transport.CommandData{
+		Op:   op,
+		Key:  key,
+		Args: args,
+	}
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+
+	kind := opRead
+	if op.Write() {
+		kind = opWriteOnce
+	}
+
+	var resp *transport.ResponseData
+	err := c.do(ctx, kind, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseDataResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Write() && c.near != nil {
+		c.near.invalidate(key)
+	}
+	switch resp.Status {
+	case transport.StatusOK:
+		return resp, nil
+	case transport.StatusKeyNotFound, transport.StatusExpired:
+		return nil, nil
+	case transport.StatusWrongType:
+		return nil, fmt.Errorf("%s on key [%s]: %w", op, key, ErrWrongType)
+	default:
+		return nil, statusError(resp.Status)
+	}
+}
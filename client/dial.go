@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// dial connects to the node at addr, over TLS if opts has a TLS config, and
+// authenticates the connection if opts has a password
+func dial(ctx context.Context, opts Options, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if opts.TLSConfig != nil {
+		d := tls.Dialer{Config: opts.TLSConfig}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Password == "" {
+		return conn, nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := authenticate(conn, opts.Password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// authenticate sends AUTH with password over conn
+func authenticate(conn net.Conn, password string) error {
+	if _, err := conn.Write((&transport.CommandAuth{Password: []byte(password)}).Bytes()); err != nil {
+		return err
+	}
+	resp, err := transport.ParseStatusResponse(conn)
+	if err != nil {
+		return err
+	}
+	if resp.Status != transport.StatusOK {
+		return statusError(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// ErrKeyNotFound is returned when the key a command needs does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// NoExpiry is the TTL returned for keys that never expire
+const NoExpiry time.Duration = -1
+
+// TTL returns the remaining time to live of key, NoExpiry if it never expires
+func (c *Client) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	cmd := &transport.CommandTTL{Key: key}
+
+	var resp *transport.ResponseTTL
+	err := c.do(ctx, opRead, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseTTLResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	switch resp.Status {
+	case transport.StatusOK:
+	case transport.StatusKeyNotFound, transport.StatusExpired:
+		return 0, ErrKeyNotFound
+	default:
+		return 0, statusError(resp.Status)
+	}
+	if resp.TTL < 0 {
+		return NoExpiry, nil
+	}
+	return time.Duration(resp.TTL) * time.Millisecond, nil
+}
+
+// Expire sets key to expire after ttl, replacing its previous expiry
+func (c *Client) Expire(ctx context.Context, key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+	return c.expire(ctx, &transport.CommandExpire{Key: key, TTL: ttl.Milliseconds()})
+}
+
+// ExpireAt sets key to expire at the given time. A time in the past deletes it.
+func (c *Client) ExpireAt(ctx context.Context, key []byte, at time.Time) error {
+	if at.UnixMilli() <= 0 {
+		return errors.New("expiry time must be after the Unix epoch")
+	}
+	return c.expire(ctx, &transport.CommandExpire{Key: key, At: at.UnixMilli()})
+}
+
+// ExpireSliding sets key to expire after ttl, and every read served by a
+// server to extend it to ttl again. Writing a new value makes the expiry fixed.
+func (c *Client) ExpireSliding(ctx context.Context, key []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+	return c.expire(ctx, &transport.CommandExpire{Key: key, TTL: ttl.Milliseconds(), Sliding: true})
+}
+
+// Persist removes the expiry of key so it never expires
+func (c *Client) Persist(ctx context.Context, key []byte) error {
+	return c.expire(ctx, &transport.CommandExpire{Key: key})
+}
+
+// expire sends an expire command
+func (c *Client) expire(ctx context.Context, cmd *transport.CommandExpire) error {
+	var resp *transport.ResponseStatus
+	err := c.do(ctx, opWrite, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseStatusResponse(conn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch resp.Status {
+	case transport.StatusOK:
+		return nil
+	case transport.StatusKeyNotFound, transport.StatusExpired:
+		return ErrKeyNotFound
+	default:
+		return statusError(resp.Status)
+	}
+}
+
+// Touch marks keys as used, extending the expiry of sliding keys, and
+// returns how many of them exist
+func (c *Client) Touch(ctx context.Context, keys ...[]byte) (int, error) {
+	cmd := &transport.CommandTouch{Keys: keys}
+
+	var resp *transport.ResponseTouch
+	err := c.do(ctx, opWrite, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseTouchResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Status != transport.StatusOK {
+		return 0, statusError(resp.Status)
+	}
+	return int(resp.Count), nil
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// HotKey is a frequently accessed key along with its estimated access count,
+// which may overestimate by up to Error
+type HotKey struct {
+	Key   []byte
+	Count uint64
+	Error uint64
+}
+
+// HotKeys returns up to n of the keys most accessed on the node serving the
+// request in descending order of count, every monitored key when n is 0.
+// Each node counts the reads it serves and the writes it applies.
+func (c *Client) HotKeys(ctx context.Context, n int) ([]HotKey, error) {
+	cmd := &transport.CommandHotKeys{Count: int32(n)}
+
+	var resp *transport.ResponseHotKeys
+	err := c.do(ctx, opRead, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseHotKeysResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != transport.StatusOK {
+		return nil, statusError(resp.Status)
+	}
+	keys := make([]HotKey, len(resp.Keys))
+	for i, key := range resp.Keys {
+		keys[i] = HotKey(key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// InvalidateTag deletes every key put with tag in one replicated operation and
+// returns how many keys were deleted
+func (c *Client) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	if tag == "" {
+		return 0, errors.New("tag must not be empty")
+	}
+	return c.invalidate(ctx, transport.InvalidateByTag, []byte(tag))
+}
+
+// InvalidatePrefix deletes every key starting with prefix in one replicated
+// operation and returns how many keys were deleted
+func (c *Client) InvalidatePrefix(ctx context.Context, prefix []byte) (int, error) {
+	if len(prefix) == 0 {
+		return 0, errors.New("prefix must not be empty")
+	}
+	return c.invalidate(ctx, transport.InvalidateByPrefix, prefix)
+}
+
+// invalidate sends a bulk invalidation. The near cache does not know which of
+// its keys match, so it is flushed.
+func (c *Client) invalidate(ctx context.Context, by transport.InvalidateBy, match []byte) (int, error) {
+	cmd := &transport.CommandInvalidate{
+		By:    by,
+		Match: match,
+	}
+
+	var resp *transport.ResponseInvalidate
+	err := c.do(ctx, opWrite, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseInvalidateResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if c.near != nil {
+		c.near.flush()
+	}
+	if resp.Status != transport.StatusOK {
+		return 0, statusError(resp.Status)
+	}
+	return int(resp.Count), nil
+}
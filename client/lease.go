@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+var (
+	// ErrLeaseHeld is returned when acquiring a lease held by someone else
+	ErrLeaseHeld = errors.New("lease is held by another owner")
+	// ErrLeaseLost is returned once a lease expired or was taken over
+	ErrLeaseLost = errors.New("lease was lost")
+)
+
+// Lease is a held distributed lease. It is kept alive in the background until
+// it is released or lost; Lost signals the loss. Work protected by the lease
+// should pass Token along to the resources it changes, so they can reject
+// requests of an older holder that did not notice the loss yet.
+type Lease struct {
+	Name  []byte
+	Token uint64 // Fencing token, increasing with every acquire of any lease
+	TTL   time.Duration
+
+	client *Client
+	lost   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	err      error
+	lostOnce sync.Once
+	doneOnce sync.Once
+}
+
+// Acquire acquires the lease name for ttl and keeps it alive by renewing it
+// every third of ttl. ErrLeaseHeld is returned if someone else holds it.
+func (c *Client) Acquire(ctx context.Context, name []byte, ttl time.Duration) (*Lease, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("lease TTL %s is shorter than a millisecond", ttl)
+	}
+
+	// The lease may have been granted as soon as the request was sent
+	start := time.Now()
+	cmd := &transport.CommandLease{
+		Op:   transport.LeaseAcquire,
+		Name: name,
+		TTL:  ttl.Milliseconds(),
+	}
+	resp, err := c.lease(ctx, opWriteOnce, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lease{
+		Name:   name,
+		Token:  resp.Token,
+		TTL:    ttl,
+		client: c,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.keepAlive(start.Add(ttl))
+	return l, nil
+}
+
+// Lost returns a channel closed once the lease is lost
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns why the lease was lost, nil while it is held or after Release
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Release stops keeping the lease alive and releases it so others can
+// acquire it right away. ErrLeaseLost is returned if it was already lost.
+func (l *Lease) Release(ctx context.Context) error {
+	l.doneOnce.Do(func() { close(l.done) })
+	l.wg.Wait()
+
+	cmd := &transport.CommandLease{
+		Op:    transport.LeaseRelease,
+		Name:  l.Name,
+		Token: l.Token,
+	}
+	_, err := l.client.lease(ctx, opWrite, cmd)
+	return err
+}
+
+// keepAlive renews the lease until it is released, or lost because a renew
+// was rejected or deadline passed without a successful renew
+func (l *Lease) keepAlive(deadline time.Time) {
+	defer l.wg.Done()
+
+	wait := l.TTL / 3
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-time.After(time.Until(deadline)):
+			l.lose(fmt.Errorf("%w: not renewed within %s", ErrLeaseLost, l.TTL))
+			return
+		case <-time.After(wait):
+		}
+
+		sent := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		cmd := &transport.CommandLease{
+			Op:    transport.LeaseRenew,
+			Name:  l.Name,
+			Token: l.Token,
+			TTL:   l.TTL.Milliseconds(),
+		}
+		_, err := l.client.lease(ctx, opWrite, cmd)
+		cancel()
+
+		switch {
+		case err == nil:
+			deadline = sent.Add(l.TTL)
+			wait = l.TTL / 3
+		case errors.Is(err, ErrLeaseLost):
+			l.lose(err)
+			return
+		default:
+			l.client.warnf("failed to renew lease [%s]: %s", l.Name, err.Error())
+			wait = l.TTL / 10
+		}
+	}
+}
+
+// lose marks the lease as lost with err
+func (l *Lease) lose(err error) {
+	l.lostOnce.Do(func() {
+		l.mu.Lock()
+		l.err = err
+		l.mu.Unlock()
+		close(l.lost)
+	})
+}
+
+// lease sends a lease command and maps its status to an error
+func (c *Client) lease(ctx context.Context, op opKind, cmd *transport.CommandLease) (*transport.ResponseLease, error) {
+	var resp *transport.ResponseLease
+	err := c.do(ctx, op, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseLeaseResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.Status {
+	case transport.StatusOK:
+		return resp, nil
+	case transport.StatusConflict:
+		return nil, fmt.Errorf("%w: lease [%s] has token %d", ErrLeaseHeld, cmd.Name, resp.Token)
+	case transport.StatusKeyNotFound:
+		return nil, fmt.Errorf("%w: lease [%s] with token %d", ErrLeaseLost, cmd.Name, cmd.Token)
+	default:
+		return nil, statusError(resp.Status)
+	}
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+)
+
+// nearCache is an in-process L1 cache kept coherent by the server's
+// invalidation stream. Entries are only served while subscribed; whenever the
+// stream breaks the near cache is flushed since invalidations may be lost.
+type nearCache struct {
+	cache   *cache.Cache
+	cluster *cluster
+	opts    Options
+
+	mu     sync.Mutex
+	epoch  uint64 // Bumped on every invalidation so in-flight fetches can detect races
+	member string // Client address of the member streaming invalidations, empty until established
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newNearCache creates a near cache and starts subscribing to invalidations
+func newNearCache(c *cluster, opts Options) *nearCache {
+	nc := &nearCache{
+		cache: cache.NewCache(cache.CacheOpts{
+			Capacity: opts.NearCacheSize,
+			TTL:      opts.NearCacheTTL,
+		}),
+		cluster: c,
+		opts:    opts,
+		done:    make(chan struct{}),
+	}
+	nc.wg.Add(1)
+	go nc.subscribe()
+	return nc
+}
+
+// get returns a cached value while the invalidation stream is live
+func (nc *nearCache) get(key []byte) ([]byte, bool) {
+	nc.mu.Lock()
+	live := nc.member != ""
+	nc.mu.Unlock()
+	if !live {
+		return nil, false
+	}
+
+	value, err := nc.cache.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// begin returns the epoch to pass to store once a fetch started now
+// completes, and the member streaming invalidations, empty if none is
+func (nc *nearCache) begin() (uint64, string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.epoch, nc.member
+}
+
+// store caches a fetched value unless an invalidation arrived since the fetch began
+func (nc *nearCache) store(key, value []byte, epoch uint64) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.member != "" && nc.epoch == epoch {
+		nc.cache.Put(key, value, 0)
+	}
+}
+
+// invalidate drops a key from the near cache
+func (nc *nearCache) invalidate(key []byte) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.epoch++
+	nc.cache.Delete(key)
+}
+
+// flush drops every key from the near cache
+func (nc *nearCache) flush() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.epoch++
+	nc.cache.Clear()
+}
+
+// setLive flushes the near cache and marks the invalidation stream up on
+// member, or down if member is empty
+func (nc *nearCache) setLive(member string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.epoch++
+	nc.member = member
+	nc.cache.Clear()
+}
+
+// subscribe keeps an invalidation stream open to one of the cluster members,
+// reconnecting with backoff until the near cache is closed
+func (nc *nearCache) subscribe() {
+	defer nc.wg.Done()
+
+	failures := 0
+	for {
+		established, err := nc.stream(nc.cluster.candidates(false)[0])
+		nc.setLive("")
+		if established {
+			failures = 0
+		}
+
+		select {
+		case <-nc.done:
+			return
+		default:
+		}
+		if nc.opts.Log != nil {
+			nc.opts.Log.Warn().Msgf("near cache invalidation stream lost: %s", err.Error())
+		}
+
+		select {
+		case <-nc.done:
+			return
+		case <-time.After(nc.cluster.backoff(min(failures, 16))):
+		}
+		failures++
+	}
+}
+
+// stream subscribes to invalidations on addr and applies them until the
+// stream fails or the near cache is closed. It reports whether the
+// subscription was established.
+func (nc *nearCache) stream(addr string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nc.opts.DialTimeout)
+	conn, err := dial(ctx, nc.opts, addr)
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-nc.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	cmd := &transport.CommandInvalidations{}
+	if _, err := conn.Write(cmd.Bytes()); err != nil {
+		return false, err
+	}
+	resp, err := transport.ParseStatusResponse(conn)
+	if err != nil {
+		return false, err
+	}
+	if resp.Status != transport.StatusOK {
+		return false, fmt.Errorf("[%s] refused invalidation subscription with status [%s]", addr, resp.Status)
+	}
+	nc.setLive(addr)
+
+	for {
+		inv, err := transport.ParseInvalidation(conn)
+		if err != nil {
+			return true, err
+		}
+		nc.invalidate(inv.Key)
+	}
+}
+
+// close stops the invalidation stream
+func (nc *nearCache) close() {
+	close(nc.done)
+	nc.wg.Wait()
+}
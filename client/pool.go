@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinConns            = 1
+	defaultMaxConns            = 8
+	defaultDialTimeout         = 5 * time.Second
+	defaultIdleTimeout         = 5 * time.Minute
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckProbe           = time.Millisecond
+)
+
+// ErrClosed is returned when an operation is attempted on a closed client
+var ErrClosed = errors.New("discache client is closed")
+
+// dialFunc opens a new connection to the server
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
+// poolConn is a pooled connection along with the time it was last returned to the pool
+type poolConn struct {
+	net.Conn
+	lastUsed time.Time
+}
+
+// healthy probes an idle connection for a remote close. A read on a healthy idle
+// connection times out since the server never sends unsolicited data.
+func (pc *poolConn) healthy() bool {
+	if err := pc.SetReadDeadline(time.Now().Add(healthCheckProbe)); err != nil {
+		return false
+	}
+	defer pc.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	_, err := pc.Read(b[:])
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// pool is a bounded set of reusable connections to a single endpoint
+type pool struct {
+	opts Options
+	dial dialFunc
+
+	slots chan struct{} // one slot per connection that may be checked out
+
+	mu     sync.Mutex
+	idle   []*poolConn
+	active int // connections currently checked out
+	closed bool
+	done   chan struct{}
+}
+
+// newPool creates a pool, dials the minimum number of connections and starts the reaper
+func newPool(opts Options, dial dialFunc) (*pool, error) {
+	p := &pool{
+		opts:  opts,
+		dial:  dial,
+		slots: make(chan struct{}, opts.MaxConns),
+		done:  make(chan struct{}),
+	}
+
+	for i := 0; i < opts.MinConns; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+		conn, err := dial(ctx)
+		cancel()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.idle = append(p.idle, &poolConn{Conn: conn, lastUsed: time.Now()})
+	}
+
+	if opts.HealthCheckInterval > 0 {
+		go p.reap()
+	}
+	return p, nil
+}
+
+// get checks out a connection, reusing an idle one when available. It blocks
+// while MaxConns connections are in use until one is returned or ctx is done.
+func (p *pool) get(ctx context.Context) (*poolConn, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+		return nil, ErrClosed
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrClosed
+	}
+	p.active++
+	if n := len(p.idle); n > 0 {
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return pc, nil
+	}
+	p.mu.Unlock()
+
+	dialCtx, cancel := context.WithTimeout(ctx, p.opts.DialTimeout)
+	defer cancel()
+
+	conn, err := p.dial(dialCtx)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return &poolConn{Conn: conn}, nil
+}
+
+// put returns a healthy connection to the pool
+func (p *pool) put(pc *poolConn) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		pc.Close()
+		p.release()
+		return
+	}
+	pc.lastUsed = time.Now()
+	p.idle = append(p.idle, pc)
+	p.mu.Unlock()
+	p.release()
+}
+
+// discard closes a connection whose stream state can no longer be trusted
+func (p *pool) discard(pc *poolConn) {
+	pc.Close()
+	p.release()
+}
+
+// release frees the slot held by a checked out connection
+func (p *pool) release() {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// reap periodically closes idle connections past IdleTimeout, drops connections
+// that fail the health check, and tops the pool back up to MinConns
+func (p *pool) reap() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.reapOnce()
+		}
+	}
+}
+
+// reapOnce runs a single reaper pass
+func (p *pool) reapOnce() {
+	p.mu.Lock()
+	candidates := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	kept := make([]*poolConn, 0, len(candidates))
+	for _, pc := range candidates {
+		expired := p.opts.IdleTimeout > 0 && time.Since(pc.lastUsed) > p.opts.IdleTimeout
+		if (expired && len(kept) >= p.opts.MinConns) || !pc.healthy() {
+			pc.Close()
+			continue
+		}
+		kept = append(kept, pc)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		for _, pc := range kept {
+			pc.Close()
+		}
+		return
+	}
+	p.idle = append(p.idle, kept...)
+	missing := p.opts.MinConns - len(p.idle) - p.active
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), p.opts.DialTimeout)
+		conn, err := p.dial(ctx)
+		cancel()
+		if err != nil {
+			if p.opts.Log != nil {
+				p.opts.Log.Warn().Msgf("failed to refill discache connection pool: %s", err.Error())
+			}
+			return
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.idle = append(p.idle, &poolConn{Conn: conn, lastUsed: time.Now()})
+		p.mu.Unlock()
+	}
+}
+
+// close closes all idle connections and stops the reaper. Connections that
+// are checked out are closed when they are returned.
+func (p *pool) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	var errs []error
+	for _, pc := range idle {
+		if err := pc.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
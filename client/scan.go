@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// ScanOptions filters the keys returned by Scan and Keys
+type ScanOptions struct {
+	// Prefix keeps only the keys starting with it
+	Prefix []byte
+	// Match keeps only the keys matching a glob pattern with '*', '?',
+	// character classes like [a-z] and '\' escapes
+	Match string
+	// Count is the number of keys per page, 0 uses the server default
+	Count int
+}
+
+// Scan returns a page of keys greater than cursor in lexical order, and the
+// cursor of the next page, which is nil once the scan is done. A nil cursor
+// starts a new scan. Keys present during the whole scan are returned exactly
+// once, even when pages are served by different nodes.
+func (c *Client) Scan(ctx context.Context, cursor []byte, opts ScanOptions) ([][]byte, []byte, error) {
+	cmd := &transport.CommandScan{
+		Cursor: cursor,
+		Prefix: opts.Prefix,
+		Match:  []byte(opts.Match),
+		Count:  int32(min(opts.Count, transport.MaxScanCount)),
+	}
+
+	var resp *transport.ResponseScan
+	err := c.do(ctx, opRead, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseScanResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.Status != transport.StatusOK {
+		return nil, nil, statusError(resp.Status)
+	}
+	if len(resp.Cursor) == 0 {
+		return resp.Keys, nil, nil
+	}
+	return resp.Keys, resp.Cursor, nil
+}
+
+// Keys returns an iterator over the keys in lexical order that fetches pages
+// with Scan as the loop advances. An error ends the iteration after it is
+// yielded.
+func (c *Client) Keys(ctx context.Context, opts ScanOptions) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		var cursor []byte
+		for {
+			keys, next, err := c.Scan(ctx, cursor, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, key := range keys {
+				if !yield(key, nil) {
+					return
+				}
+			}
+			if next == nil {
+				return
+			}
+			cursor = next
+		}
+	}
+}
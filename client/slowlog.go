@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// SlowLogEntry is a command that took longer than the slow log threshold of
+// the node that served it
+type SlowLogEntry struct {
+	ID       uint64
+	Time     time.Time
+	Client   string
+	Command  string
+	Key      []byte // First bytes of the key the command addressed, if any
+	Duration time.Duration
+	Apply    time.Duration // Part of Duration spent replicating through raft
+}
+
+// SlowLog returns up to n of the slowest recent commands of the node serving
+// the request, most recent first, every entry when n is 0
+func (c *Client) SlowLog(ctx context.Context, n int) ([]SlowLogEntry, error) {
+	resp, err := c.slowLog(ctx, &transport.CommandSlowLog{Op: transport.SlowLogGet, Count: int32(n)})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]SlowLogEntry, len(resp.Entries))
+	for i, e := range resp.Entries {
+		entries[i] = SlowLogEntry{
+			ID:       e.ID,
+			Time:     time.UnixMicro(e.Time),
+			Client:   e.Client,
+			Command:  e.Command,
+			Key:      e.Key,
+			Duration: time.Duration(e.Duration) * time.Microsecond,
+			Apply:    time.Duration(e.Apply) * time.Microsecond,
+		}
+	}
+	return entries, nil
+}
+
+// ResetSlowLog empties the slow log of the node serving the request
+func (c *Client) ResetSlowLog(ctx context.Context) error {
+	_, err := c.slowLog(ctx, &transport.CommandSlowLog{Op: transport.SlowLogReset})
+	return err
+}
+
+// slowLog sends a slow log command and checks the status of its response
+func (c *Client) slowLog(ctx context.Context, cmd *transport.CommandSlowLog) (*transport.ResponseSlowLog, error) {
+	var resp *transport.ResponseSlowLog
+	err := c.do(ctx, opRead, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseSlowLogResponse(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status != transport.StatusOK {
+		return nil, statusError(resp.Status)
+	}
+	return resp, nil
+}
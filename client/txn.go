@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// Txn builds a transaction: a set of version guards and writes applied as a
+// single raft log entry, so either every write is applied on every replica
+// or none is. Build one with Client.Txn and send it with Commit.
+type Txn struct {
+	client *Client
+	cmd    transport.CommandTxn
+}
+
+// Txn starts building a transaction
+func (c *Client) Txn() *Txn {
+	return &Txn{client: c}
+}
+
+// IfVersion guards the transaction on key being at version
+func (t *Txn) IfVersion(key []byte, version uint64) *Txn {
+	t.cmd.Checks = append(t.cmd.Checks, transport.TxnCheck{Key: key, Cond: transport.CondVersion, Version: version})
+	return t
+}
+
+// IfAbsent guards the transaction on key not existing
+func (t *Txn) IfAbsent(key []byte) *Txn {
+	t.cmd.Checks = append(t.cmd.Checks, transport.TxnCheck{Key: key, Cond: transport.CondAbsent})
+	return t
+}
+
+// IfPresent guards the transaction on key existing
+func (t *Txn) IfPresent(key []byte) *Txn {
+	t.cmd.Checks = append(t.cmd.Checks, transport.TxnCheck{Key: key, Cond: transport.CondPresent})
+	return t
+}
+
+// Set adds a write of key with a TTL in seconds to the transaction
+func (t *Txn) Set(key, value []byte, ttl int) *Txn {
+	t.cmd.Ops = append(t.cmd.Ops, transport.TxnOp{Type: transport.TxnSet, Key: key, Value: value, TTL: ttl})
+	return t
+}
+
+// Delete adds a delete of key to the transaction
+func (t *Txn) Delete(key []byte) *Txn {
+	t.cmd.Ops = append(t.cmd.Ops, transport.TxnOp{Type: transport.TxnDelete, Key: key})
+	return t
+}
+
+// Commit sends the transaction and returns the version of the keys it wrote.
+// If a guard does not hold nothing is written and a *ConflictError naming the
+// first failed key is returned. Like other conditional writes it is never
+// resent once it reached a server.
+func (t *Txn) Commit(ctx context.Context) (uint64, error) {
+	c := t.client
+
+	var resp *transport.ResponseTxn
+	err := c.do(ctx, opWriteOnce, func(conn net.Conn) error {
+		var err error
+		if _, err = conn.Write(t.cmd.Bytes()); err != nil {
+			return err
+		}
+		resp, err = transport.ParseTxnResponse(conn)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if c.near != nil {
+		for _, op := range t.cmd.Ops {
+			c.near.invalidate(op.Key)
+		}
+	}
+	switch resp.Status {
+	case transport.StatusOK:
+		return resp.Version, nil
+	case transport.StatusConflict:
+		return 0, &ConflictError{Key: resp.Key, Version: resp.Version}
+	default:
+		return 0, statusError(resp.Status)
+	}
+}
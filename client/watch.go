@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+const defaultWatchBuffer = 64
+
+// ErrWatchCompacted is returned when a watch can not be resumed because the
+// server no longer retains the events since its last position
+var ErrWatchCompacted = errors.New("watch position was compacted, events may have been missed")
+
+// WatchOptions configures a watch
+type WatchOptions struct {
+	// Prefix watches every key starting with the watched key
+	Prefix bool
+	// FromIndex replays the retained events starting at this raft index, 0 only streams new events
+	FromIndex uint64
+	// Buffer is the capacity of the events channel
+	Buffer int
+}
+
+// Watch is a subscription to the keyspace events of a key or key prefix. It
+// reconnects to another member and resumes from its last position when the
+// stream breaks. Expirations and evictions are produced by each member on its
+// own, so those happening while the stream is down are not delivered.
+type Watch struct {
+	// Events delivers the events in raft log order. It is closed when the watch ends.
+	Events <-chan transport.Event
+
+	events  chan transport.Event
+	client  *Client
+	cmd     transport.CommandWatch
+	lastIdx uint64 // Index of the last delivered event of the raft log
+	lastN   int    // How many events of the raft log of lastIdx were delivered
+
+	mu   sync.Mutex
+	err  error
+	done chan struct{}
+	once sync.Once
+}
+
+// Watch subscribes to the set, delete, TTL, expire and evict events of key, or of
+// every key with that prefix. The watch ends when ctx is done, Close is called
+// or it can not be resumed.
+func (c *Client) Watch(ctx context.Context, key []byte, opts WatchOptions) (*Watch, error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultWatchBuffer
+	}
+
+	events := make(chan transport.Event, opts.Buffer)
+	w := &Watch{
+		Events: events,
+		events: events,
+		client: c,
+		cmd: transport.CommandWatch{
+			Key:       key,
+			Prefix:    opts.Prefix,
+			FromIndex: opts.FromIndex,
+		},
+		done: make(chan struct{}),
+	}
+
+	// Establish the first stream synchronously so setup errors are returned
+	conn, err := w.subscribe(ctx, c.cluster.candidates(false)[0])
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Close()
+		case <-w.done:
+		}
+	}()
+	go w.run(conn)
+	return w, nil
+}
+
+// Err returns the reason the watch ended, nil if it was closed by the caller
+func (w *Watch) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close ends the watch
+func (w *Watch) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// run delivers events from conn and resubscribes whenever the stream breaks
+func (w *Watch) run(conn net.Conn) {
+	defer close(w.events)
+
+	failures := 0
+	for {
+		if conn != nil {
+			err := w.stream(conn)
+			conn.Close()
+			if w.closed() {
+				return
+			}
+			w.warnf("watch stream lost: %s", err.Error())
+			failures = 0
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(w.client.cluster.backoff(min(failures, 16))):
+		}
+		failures++
+
+		ctx, cancel := context.WithTimeout(context.Background(), w.client.DialTimeout)
+		var err error
+		conn, err = w.subscribe(ctx, w.client.cluster.candidates(false)[0])
+		cancel()
+		if errors.Is(err, ErrWatchCompacted) {
+			w.fail(err)
+			return
+		}
+		if err != nil {
+			w.warnf("failed to resume watch: %s", err.Error())
+		}
+	}
+}
+
+// subscribe opens a stream on addr resuming from the last delivered event
+func (w *Watch) subscribe(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, w.client.Options, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := w.cmd
+	if w.lastIdx > 0 {
+		cmd.FromIndex = w.lastIdx
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(cmd.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := transport.ParseStatusResponse(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	switch resp.Status {
+	case transport.StatusOK:
+		return conn, nil
+	case transport.StatusCompacted:
+		conn.Close()
+		return nil, ErrWatchCompacted
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("[%s] refused watch with status [%s]", addr, resp.Status)
+	}
+}
+
+// stream delivers the events read from conn until it fails or the watch is closed
+func (w *Watch) stream(conn net.Conn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-w.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// Events of the last delivered index are replayed on resume, skip the ones
+	// already seen. Expirations and evictions are never replayed, so they do
+	// not count towards the position.
+	skip, skipIdx := w.lastN, w.lastIdx
+	for {
+		ev, err := transport.ParseEvent(conn)
+		if err != nil {
+			return err
+		}
+		local := ev.Type.Local()
+		if !local && ev.Index == skipIdx && skip > 0 {
+			skip--
+			continue
+		}
+
+		select {
+		case w.events <- *ev:
+		case <-w.done:
+			return nil
+		}
+		if local {
+			continue
+		}
+		if ev.Index == w.lastIdx {
+			w.lastN++
+		} else {
+			w.lastIdx, w.lastN = ev.Index, 1
+		}
+	}
+}
+
+// fail ends the watch with err
+func (w *Watch) fail(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+	w.Close()
+}
+
+// closed reports whether the watch was closed
+func (w *Watch) closed() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// warnf logs a warning if the client has a logger
+func (w *Watch) warnf(format string, v ...any) {
+	w.client.warnf(format, v...)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dhyanio/discache/config"
+	"github.com/spf13/cobra"
+)
+
+// configCmd creates the config command
+func configCmd() *cobra.Command {
+	command := cobra.Command{
+		Use:   "config",
+		Short: "Inspect node config files",
+		Long:  "Inspect the YAML or TOML files nodes are started with by 'discache start node --config'",
+	}
+	command.AddCommand(&configValidateCmd)
+	return &command
+}
+
+// configValidateCmd checks a config file without starting a node
+var configValidateCmd = cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate a node config file",
+	Long:  "Validate the config file (default $" + configEnv + ") with the DISCACHE_* variables of the environment applied, reporting every invalid setting",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := os.Getenv(configEnv)
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			fmt.Println("Error: no config file given. Pass it as an argument or set " + configEnv + ".")
+			os.Exit(1)
+		}
+
+		c, err := config.Load(path)
+		if err == nil {
+			err = c.Validate()
+		}
+		if err != nil {
+			fmt.Printf("Error: %s is invalid:\n%v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", path)
+	},
+}
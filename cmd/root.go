@@ -34,4 +34,5 @@ func Execute() {
 func init() {
 	rootCmd.AddCommand(versionCmd())
 	rootCmd.AddCommand(startCmd())
+	rootCmd.AddCommand(configCmd())
 }
@@ -1,20 +1,32 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
+	"github.com/dhyanio/discache/aof"
 	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/config"
 	"github.com/dhyanio/discache/rafter"
+	"github.com/dhyanio/discache/trace"
 	"github.com/dhyanio/gogger"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 const (
-	loggerFilePath = "discache.log"
-	cacheCapabity  = 10
-	cacheTTL       = 5 * time.Second
+	nodeServerPort = ":9080" // Port a standalone node serves clients on by default
+	standaloneID   = "standalone"
+	configEnv      = "DISCACHE_CONFIG" // Environment variable naming the config file when --config is not set
+)
+
+var (
+	configFile string             // YAML or TOML file the node settings are loaded from
+	cfg        = config.Default() // Settings of the node, bound to the flags
 )
 
 var evictFunc = func(key string, value []byte) {
@@ -26,75 +38,343 @@ func startCmd() *cobra.Command {
 	command := cobra.Command{
 		Use:   "start",
 		Short: "Start cache server nodes",
-		Long:  "Start cache server nodes, either as a leader or a node connected to a leader",
+		Long:  "Start cache server nodes, either as a leader or a node connected to a leader, or a standalone node without raft",
 	}
+	addServerFlags(&nodeCmd)
+	addNodeFlags(&nodeCmd)
+	addRaftFlags(&nodeCmd)
+	addServerFlags(&standaloneCmd)
+	standaloneCmd.Flags().StringVar(&cfg.AOF.Path, "aof", cfg.AOF.Path, "append-only file persisting writes across restarts, kept in memory only if empty")
+	standaloneCmd.Flags().StringVar(&cfg.AOF.Fsync, "appendfsync", cfg.AOF.Fsync, "when writes are flushed to the append-only file: always, everysec or no")
+	standaloneCmd.Flags().Int64Var(&cfg.AOF.RewriteMinSize, "aof-rewrite-min-size", cfg.AOF.RewriteMinSize, "size the append-only file must reach before it is rewritten")
+	standaloneCmd.Flags().IntVar(&cfg.AOF.RewriteGrowth, "aof-rewrite-growth", cfg.AOF.RewriteGrowth, "growth in percent since the last rewrite that rewrites the append-only file, negative never rewrites it")
 	command.AddCommand(&nodeCmd)
+	command.AddCommand(&standaloneCmd)
 	return &command
 }
 
+// addServerFlags adds the flags of the cache and admin servers of a node to command
+func addServerFlags(command *cobra.Command) {
+	f := command.Flags()
+	f.StringVar(&configFile, "config", "", "YAML file, or TOML file if it ends in .toml, the settings are loaded from, overridden by DISCACHE_* variables and flags (default $"+configEnv+")")
+	f.StringVar(&cfg.Node.ListenAddr, "listen-addr", cfg.Node.ListenAddr, "address clients connect to (default the client address of --id in --peers)")
+	f.StringVar(&cfg.Node.AdminAddr, "admin-addr", cfg.Node.AdminAddr, "address of the admin HTTP server serving pprof, health, status and metrics (default <node host>:9100)")
+	f.StringVar(&cfg.Log.File, "log-file", cfg.Log.File, "file the node logs to")
+	f.StringVar(&cfg.Log.TraceFile, "trace-file", cfg.Log.TraceFile, "file to append the spans of traced commands to as JSON lines, tracing is off if empty")
+	f.IntVar(&cfg.Cache.Capacity, "cache-capacity", cfg.Cache.Capacity, "keys held before the least recently used is evicted")
+	f.DurationVar(&cfg.Cache.TTL, "cache-ttl", cfg.Cache.TTL, "TTL of keys written without one, 0 keeps them until evicted")
+	f.Int64Var(&cfg.Cache.MaxBytes, "cache-max-bytes", cfg.Cache.MaxBytes, "approximate memory limit of keys and values, 0 is unlimited")
+	f.StringVar(&cfg.Cache.Eviction, "cache-eviction", cfg.Cache.Eviction, "eviction policy of the cache, only lru")
+	f.DurationVar(&cfg.SlowLog.Threshold, "slowlog-threshold", cfg.SlowLog.Threshold, "commands taking at least this long are kept in the slow log, 0 disables it")
+	f.IntVar(&cfg.SlowLog.Size, "slowlog-size", cfg.SlowLog.Size, "entries kept in the slow log before the oldest is dropped")
+	f.IntVar(&cfg.Limits.MaxConns, "max-conns", cfg.Limits.MaxConns, "client connections served at once, 0 is unlimited")
+	f.IntVar(&cfg.Limits.MaxInFlight, "max-inflight", cfg.Limits.MaxInFlight, "commands handled at once per connection before the next are answered busy, 0 is unlimited")
+	f.DurationVar(&cfg.Limits.IdleTimeout, "idle-timeout", cfg.Limits.IdleTimeout, "close connections waiting this long for their next command, 0 disables it")
+	f.DurationVar(&cfg.Limits.ReadTimeout, "read-timeout", cfg.Limits.ReadTimeout, "time a client has to send the rest of a command once it started, 0 disables it")
+	f.DurationVar(&cfg.Limits.WriteTimeout, "write-timeout", cfg.Limits.WriteTimeout, "time given to writing a response or event to a client, 0 disables it")
+	f.Float64Var(&cfg.Limits.RateLimit, "rate-limit", cfg.Limits.RateLimit, "commands per second allowed per client, the user of its certificate or its IP, before they are answered busy, 0 disables it")
+	f.IntVar(&cfg.Limits.RateBurst, "rate-burst", cfg.Limits.RateBurst, "commands a client may send at once above the rate limit")
+	f.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "PEM certificate to serve clients over TLS with")
+	f.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "PEM private key of the TLS certificate")
+	f.StringVar(&cfg.TLS.CAFile, "tls-ca", cfg.TLS.CAFile, "PEM bundle verifying client certificates and the leader writes are forwarded to")
+	f.StringVar(&cfg.Auth.Password, "password", cfg.Auth.Password, "password clients must AUTH with, prefer DISCACHE_AUTH_PASSWORD to keep it out of the process list")
+	f.DurationVar(&cfg.Node.ShutdownTimeout, "shutdown-timeout", cfg.Node.ShutdownTimeout, "time given to in-flight commands to finish on SIGINT or SIGTERM before connections are dropped")
+}
+
+// addNodeFlags adds the identity and cluster flags of a raft node to command
+func addNodeFlags(command *cobra.Command) {
+	f := command.Flags()
+	f.StringVar(&cfg.Node.ID, "id", cfg.Node.ID, "raft ID of the node")
+	f.StringVar(&cfg.Node.RaftAddr, "raft-addr", cfg.Node.RaftAddr, "address of the raft transport of the node")
+	f.BoolVar(&cfg.Node.Bootstrap, "bootstrap", cfg.Node.Bootstrap, "bootstrap a new cluster with the peers, on a single node of the cluster")
+	f.StringSliceVar(&cfg.Node.Peers, "peers", cfg.Node.Peers, "voters of the cluster as id=raft_host:port/client_host:port (default node1 to node3 on 127.0.0.1:8080-8082 serving clients on 9080-9082)")
+	f.BoolVar(&cfg.Node.TransferLeadership, "transfer-leadership", cfg.Node.TransferLeadership, "hand leadership to another voter on shutdown")
+	f.StringVar(&cfg.Node.DataDir, "data-dir", cfg.Node.DataDir, "directory of the raft log, stable and snapshot stores (default data/<id>)")
+}
+
+// addRaftFlags adds the raft tuning flags of a node to command
+func addRaftFlags(command *cobra.Command) {
+	c := &cfg.Raft
+	command.Flags().DurationVar(&c.HeartbeatTimeout, "raft-heartbeat-timeout", c.HeartbeatTimeout, "time without contact from the leader before a follower starts an election")
+	command.Flags().DurationVar(&c.ElectionTimeout, "raft-election-timeout", c.ElectionTimeout, "time without contact from the leader before a candidate starts an election")
+	command.Flags().DurationVar(&c.LeaderLeaseTimeout, "raft-leader-lease-timeout", c.LeaderLeaseTimeout, "time the leader keeps leading without contact from a quorum")
+	command.Flags().DurationVar(&c.CommitTimeout, "raft-commit-timeout", c.CommitTimeout, "time without a write before the leader heartbeats the commit index")
+	command.Flags().Uint64Var(&c.SnapshotThreshold, "raft-snapshot-threshold", c.SnapshotThreshold, "log entries since the last snapshot that trigger a new one")
+	command.Flags().DurationVar(&c.SnapshotInterval, "raft-snapshot-interval", c.SnapshotInterval, "how often the snapshot threshold is checked")
+	command.Flags().IntVar(&c.SnapshotRetain, "raft-snapshot-retain", c.SnapshotRetain, "snapshots kept in the data directory")
+	command.Flags().Uint64Var(&c.TrailingLogs, "raft-trailing-logs", c.TrailingLogs, "log entries kept after a snapshot so slow followers can catch up without it")
+	command.Flags().IntVar(&c.MaxAppendEntries, "raft-max-append-entries", c.MaxAppendEntries, "log entries sent to a follower in one request, at most 1024")
+	command.Flags().IntVar(&c.TransportPool, "raft-transport-pool", c.TransportPool, "connections kept to every other node")
+	command.Flags().DurationVar(&c.TransportTimeout, "raft-transport-timeout", c.TransportTimeout, "I/O deadline of the raft transport")
+}
+
+// loadConfig overrides the defaults with the config file, then the
+// environment, then the flags set on the command line, then the positional
+// arguments applied by args if not nil, and validates the result
+func loadConfig(command *cobra.Command, args func() error) error {
+	// Parsing the flags already wrote them over the defaults, so they are set
+	// again once the file and the environment were read
+	type setFlag struct {
+		flag  *pflag.Flag
+		value string
+		slice []string
+	}
+	var set []setFlag
+	command.Flags().Visit(func(f *pflag.Flag) {
+		s := setFlag{flag: f, value: f.Value.String()}
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			s.slice = v.GetSlice()
+		}
+		set = append(set, s)
+	})
+
+	if configFile == "" {
+		configFile = os.Getenv(configEnv)
+	}
+	if configFile != "" {
+		if err := cfg.ReadFile(configFile); err != nil {
+			return err
+		}
+	}
+	if err := cfg.ReadEnv(); err != nil {
+		return fmt.Errorf("invalid environment:\n%w", err)
+	}
+
+	for _, s := range set {
+		var err error
+		if v, ok := s.flag.Value.(pflag.SliceValue); ok {
+			err = v.Replace(s.slice)
+		} else {
+			err = s.flag.Value.Set(s.value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", s.flag.Name, err)
+		}
+	}
+	if args != nil {
+		if err := args(); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	return nil
+}
+
 // nodeCmd creates the node command
 var nodeCmd = cobra.Command{
-	Use: "node [nodeName] [nodeEndpoint] [leader [leaderName]]",
-	// Use:   "node [name] [leader [leaderPort]]",
+	Use:   "node [nodeName [nodeEndpoint [leader leaderName]]]",
 	Short: "Start a node server, optionally specifying a leader",
-	Long:  "Start a node server on the specified port, optionally specifying a leader name",
-	Args:  cobra.MinimumNArgs(1), // At least the node port is required
+	Long: `Start a raft node serving clients, configured by --config, DISCACHE_* variables and flags, in increasing precedence.
+The positional arguments are kept for compatibility: nodeName sets --id, nodeEndpoint sets --raft-addr, and
+'leader leaderName' sets --bootstrap=false.`,
+	Args: cobra.MaximumNArgs(4),
 	Run: func(cmd *cobra.Command, args []string) {
-		nodeName := args[0]
-		nodeEndpoint := args[1]
 		leaderName := ""
-		isLeader := true
-
-		if len(args) > 2 {
-			// Validate if "leader" keyword is provided with correct format
-			if args[2] == "leader" {
-				if len(args) == 4 {
-					leaderName = args[3]
-					isLeader = false
-				} else {
-					// Print error if "leader" keyword is used but the name is missing
-					fmt.Println("Error: 'leader' specified without name. Use 'discache start node [port]' for a standalone node or 'discache start node [nodeName] [nodeEndpoint] leader [leaderName]' for a node with leader.")
-					os.Exit(1)
+		positional := func() error {
+			if len(args) > 0 {
+				cfg.Node.ID = args[0]
+			}
+			if len(args) > 1 {
+				cfg.Node.RaftAddr = args[1]
+			}
+			if len(args) > 2 {
+				// Validate if "leader" keyword is provided with correct format
+				if args[2] != "leader" {
+					return fmt.Errorf("unknown argument '%s'. Use 'leader [leaderName]' after the endpoint to specify a leader", args[2])
+				}
+				if len(args) != 4 {
+					return errors.New("'leader' specified without name. Use 'discache start node [nodeName] [nodeEndpoint] leader [leaderName]' for a node with leader")
 				}
-			} else {
-				// Print error if invalid argument is provided after the port
-				fmt.Printf("Error: Unknown argument '%s'. Use 'leader [leaderName]' after the name to specify a leader.\n", args[1])
-				os.Exit(1)
+				leaderName = args[3]
+				cfg.Node.Bootstrap = false
 			}
+			return nil
+		}
+		if err := loadConfig(cmd, positional); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cfg.Node.ID == "" || cfg.Node.RaftAddr == "" {
+			fmt.Println("Error: a node needs an ID and a raft address. Set node.id and node.raft_addr, --id and --raft-addr, or pass them as arguments.")
+			os.Exit(1)
 		}
 
-		log, err := gogger.NewLogger(loggerFilePath, gogger.INFO)
+		peers, clientAddrs, err := cfg.Node.RaftPeers()
 		if err != nil {
-			fmt.Printf("Error: Unknown argument '%s'. Use 'leader [leaderName]' after the name to specify a leader.\n", args[1])
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
+		tlsConfig, err := cfg.TLS.Config()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		log := newLogger()
 
 		opts := rafter.RaftServerOpts{
-			ID:         nodeName,
-			ListenAddr: nodeEndpoint,
-			IsLeader:   isLeader,
-			LeaderAddr: leaderName,
-			Log:        log,
+			ID:          cfg.Node.ID,
+			ListenAddr:  cfg.Node.RaftAddr,
+			ClientAddr:  cfg.Node.ListenAddr,
+			IsLeader:    cfg.Node.Bootstrap,
+			LeaderAddr:  leaderName,
+			Peers:       peers,
+			ClientAddrs: clientAddrs,
+			Log:         log,
+			AdminAddr:   cfg.Node.AdminAddr,
+			Tracer:      newTracer(),
+
+			SlowLogThreshold: cfg.SlowLog.Threshold,
+			SlowLogSize:      cfg.SlowLog.Size,
+
+			Limits: cfg.Limits,
+
+			TransferLeadership: cfg.Node.TransferLeadership,
+
+			DataDir: cfg.Node.DataDir,
+			Raft:    cfg.Raft,
+
+			TLSConfig: tlsConfig,
+			Password:  cfg.Auth.Password,
 		}
 		startServer(opts)
 	},
 }
 
-// startServer starts a server with the specified role, port, and leader port
-func startServer(opts rafter.RaftServerOpts) {
-	// Initialize cache with capacity 5, TTL 5 seconds, and custom eviction callback
+// standaloneCmd starts a single node without raft
+var standaloneCmd = cobra.Command{
+	Use:   "standalone [listenAddr]",
+	Short: "Start a standalone node without raft",
+	Long:  "Start a single node serving clients on listenAddr (default 127.0.0.1:9080) without raft, optionally persisting writes to an append-only file",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		positional := func() error {
+			if len(args) > 0 {
+				cfg.Node.ListenAddr = args[0]
+			}
+			return nil
+		}
+		if err := loadConfig(cmd, positional); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cfg.Node.ListenAddr == "" {
+			cfg.Node.ListenAddr = "127.0.0.1" + nodeServerPort
+		}
+		id := cfg.Node.ID
+		if id == "" {
+			id = standaloneID
+		}
+
+		// Checked by loadConfig
+		fsync, _ := aof.ParseFsyncPolicy(cfg.AOF.Fsync)
+		tlsConfig, err := cfg.TLS.Config()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		log := newLogger()
+
+		opts := rafter.StandaloneOpts{
+			ID:         id,
+			ListenAddr: cfg.Node.ListenAddr,
+			Log:        log,
+			AdminAddr:  cfg.Node.AdminAddr,
+			Tracer:     newTracer(),
+
+			AOFPath: cfg.AOF.Path,
+			AOF:     aof.Options{Fsync: fsync, RewriteMinSize: cfg.AOF.RewriteMinSize, RewriteGrowth: cfg.AOF.RewriteGrowth},
+
+			SlowLogThreshold: cfg.SlowLog.Threshold,
+			SlowLogSize:      cfg.SlowLog.Size,
+
+			Limits: cfg.Limits,
+
+			TLSConfig: tlsConfig,
+			Password:  cfg.Auth.Password,
+		}
+		fsm := rafter.NewRaftFSM(newCache())
+		run(log, opts.ID, func() (*rafter.Node, error) {
+			return rafter.Standalone(fsm, opts)
+		})
+	},
+}
+
+// newLogger creates the logger writing to the log file
+func newLogger() *gogger.Logger {
+	log, err := gogger.NewLogger(cfg.Log.File, gogger.INFO)
+	if err != nil {
+		fmt.Printf("Error: failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	return log
+}
+
+// newTracer creates the tracer writing spans to the trace file, nil if none
+// is set
+func newTracer() *trace.Tracer {
+	if cfg.Log.TraceFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(cfg.Log.TraceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Printf("Error: failed to open trace file: %v\n", err)
+		os.Exit(1)
+	}
+	return trace.NewTracer(trace.TracerOpts{Exporter: trace.NewJSONExporter(f)})
+}
+
+// newCache creates the cache of a node. The eviction policy was validated to
+// be LRU, the one the cache implements.
+func newCache() *cache.Cache {
 	cacheOpts := cache.CacheOpts{
-		Capacity: cacheCapabity,
-		TTL:      cacheTTL,
-		OnEvict:  evictFunc,
+		Capacity:       cfg.Cache.Capacity,
+		TTL:            cfg.Cache.TTL,
+		MaxBytes:       cfg.Cache.MaxBytes,
+		OnEvict:        evictFunc,
+		HotKeys:        cfg.Cache.HotKeys,
+		HotKeySampling: cfg.Cache.HotKeySampling,
 	}
-	cc := cache.NewCache(cacheOpts)
-	raftSever(cc, opts)
+	return cache.NewCache(cacheOpts)
+}
+
+// startServer starts a server with the specified role, port, and leader port
+func startServer(opts rafter.RaftServerOpts) {
+	raftSever(newCache(), opts)
 }
 
-// raftServer using raft Server and raft's own Transport layer
+// raftServer using raft Server and raft's own Transport layer, until SIGINT
+// or SIGTERM shuts it down
 func raftSever(cc *cache.Cache, opts rafter.RaftServerOpts) {
 	raftFSM := rafter.NewRaftFSM(cc)
-	rafter.Rafting(raftFSM, opts)
-	select {}
+	run(opts.Log, opts.ID, func() (*rafter.Node, error) {
+		return rafter.Rafting(raftFSM, opts)
+	})
+}
+
+// run starts a node with start and serves until SIGINT or SIGTERM shuts it
+// down gracefully
+func run(log *gogger.Logger, id string, start func() (*rafter.Node, error)) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	node, err := start()
+	if err != nil {
+		log.Fatal().Msgf("failed to start node %s: %v", id, err)
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Info().Msgf("shutting down node %s", id)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Node.ShutdownTimeout)
+	err = node.Shutdown(shutdownCtx)
+	cancel()
+	if err != nil {
+		log.Error().Msgf("failed to shut down node %s cleanly: %v", id, err)
+		os.Exit(1)
+	}
+	log.Info().Msgf("node %s shut down", id)
 }
@@ -0,0 +1,488 @@
+// Package config loads the settings of a discache node from a YAML or TOML
+// file, overridden by DISCACHE_* environment variables.
+//
+// Every setting lives in a section of the file, a table in TOML, and is named
+// after its YAML key, so cache.capacity is read from DISCACHE_CACHE_CAPACITY and
+// raft.election_timeout from DISCACHE_RAFT_ELECTION_TIMEOUT. Durations use
+// the Go syntax, such as 500ms or 5s, and lists are comma separated.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dhyanio/discache/aof"
+	"github.com/dhyanio/discache/rafter"
+	"github.com/dhyanio/discache/server"
+	"github.com/hashicorp/raft"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables overriding the file
+const envPrefix = "DISCACHE"
+
+// EvictionLRU evicts the least recently used keys, the only eviction policy
+// the cache implements
+const EvictionLRU = "lru"
+
+// Config is the configuration of a node
+type Config struct {
+	Node    Node              `yaml:"node"`
+	Cache   Cache             `yaml:"cache"`
+	Raft    rafter.RaftConfig `yaml:"raft"`
+	AOF     AOF               `yaml:"aof"`
+	Limits  server.Limits     `yaml:"limits"`
+	SlowLog SlowLog           `yaml:"slowlog"`
+	TLS     TLS               `yaml:"tls"`
+	Auth    Auth              `yaml:"auth"`
+	Log     Log               `yaml:"log"`
+}
+
+// Node is the identity, addresses and cluster membership of a node
+type Node struct {
+	ID         string `yaml:"id"`
+	RaftAddr   string `yaml:"raft_addr"`   // Address of the raft transport
+	ListenAddr string `yaml:"listen_addr"` // Address clients connect to, the client address of the node in Peers if empty
+	AdminAddr  string `yaml:"admin_addr"`  // Address of the admin HTTP server, the node host on port 9100 if empty
+	DataDir    string `yaml:"data_dir"`    // Directory of the raft stores, data/<id> if empty
+
+	// Bootstrap makes the node bootstrap a new cluster with Peers; a single
+	// node of the cluster does
+	Bootstrap bool `yaml:"bootstrap"`
+	// Peers are the voters of the cluster as id=raft_addr/client_addr, node1
+	// to node3 on 127.0.0.1:8080-8082 serving clients on 9080-9082 if empty.
+	// Followers forward writes to the client address of the leader.
+	Peers []string `yaml:"peers"`
+
+	TransferLeadership bool          `yaml:"transfer_leadership"` // Hand leadership to another voter on shutdown
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout"`    // Time given to in-flight commands to finish on shutdown
+}
+
+// Cache sizes the cache of a node
+type Cache struct {
+	Capacity       int           `yaml:"capacity"`         // Keys held before the eviction policy removes one
+	TTL            time.Duration `yaml:"ttl"`              // TTL of keys written without one, 0 keeps them until evicted
+	MaxBytes       int64         `yaml:"max_bytes"`        // Approximate memory limit of keys and values, 0 is unlimited
+	Eviction       string        `yaml:"eviction"`         // Eviction policy, only lru
+	HotKeys        int           `yaml:"hot_keys"`         // Keys monitored by the hot key sketch, 0 disables it
+	HotKeySampling int           `yaml:"hot_key_sampling"` // One in that many accesses is counted by the hot key sketch
+}
+
+// AOF is the append-only file of a standalone node
+type AOF struct {
+	Path           string `yaml:"path"`             // Append-only file, writes are kept in memory only if empty
+	Fsync          string `yaml:"fsync"`            // always, everysec or no
+	RewriteMinSize int64  `yaml:"rewrite_min_size"` // Size the file must reach before it is rewritten
+	RewriteGrowth  int    `yaml:"rewrite_growth"`   // Growth in percent that rewrites the file, negative never rewrites it
+}
+
+// SlowLog configures the slow log of a node
+type SlowLog struct {
+	Threshold time.Duration `yaml:"threshold"` // Commands taking at least this long are kept, 0 disables it
+	Size      int           `yaml:"size"`      // Entries kept before the oldest is dropped
+}
+
+// TLS serves clients and forwards writes to the leader over TLS when CertFile
+// is set
+type TLS struct {
+	CertFile string `yaml:"cert_file"` // PEM certificate of the node
+	KeyFile  string `yaml:"key_file"`  // PEM private key of the certificate
+	// CAFile is a PEM bundle that verifies the leader writes are forwarded to
+	// and, when set, the certificates clients must present
+	CAFile string `yaml:"ca_file"`
+}
+
+// Auth requires clients to authenticate
+type Auth struct {
+	Password string `yaml:"password"` // Password clients must AUTH with, empty requires none
+}
+
+// Log configures where a node logs and traces
+type Log struct {
+	File      string `yaml:"file"`       // File the node logs to
+	TraceFile string `yaml:"trace_file"` // File spans are appended to as JSON lines, tracing is off if empty
+}
+
+// Default returns the configuration of a node without a file
+func Default() Config {
+	return Config{
+		Node: Node{
+			Bootstrap:          true,
+			TransferLeadership: true,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		Cache: Cache{
+			Capacity:       10,
+			TTL:            5 * time.Second,
+			MaxBytes:       64 << 20,
+			Eviction:       EvictionLRU,
+			HotKeys:        64,
+			HotKeySampling: 10,
+		},
+		Raft: rafter.DefaultRaftConfig(),
+		AOF: AOF{
+			Fsync:          "everysec",
+			RewriteMinSize: aof.DefaultRewriteMinSize,
+			RewriteGrowth:  aof.DefaultRewriteGrowth,
+		},
+		Limits: server.Limits{
+			IdleTimeout:  5 * time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			RateBurst:    100,
+		},
+		SlowLog: SlowLog{Threshold: 10 * time.Millisecond, Size: 128},
+		Log:     Log{File: "discache.log"},
+	}
+}
+
+// Load returns the default configuration overridden by the file at path, if
+// any, and then by the environment
+func Load(path string) (Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.ReadFile(path); err != nil {
+			return c, err
+		}
+	}
+	if err := c.ReadEnv(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// ReadFile overrides c with the settings of the file at path, read as TOML if
+// its extension is .toml and as YAML otherwise. Settings missing from the
+// file are kept and unknown ones are an error.
+func (c *Config) ReadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if err := c.readTOML(data); err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file sets nothing
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// readTOML overrides c with the settings of a TOML document, whose tables are
+// the sections and whose keys are the YAML keys of the settings
+func (c *Config) readTOML(data []byte) error {
+	var doc map[string]any
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return err
+	}
+
+	var errs []error
+	sections := reflect.ValueOf(c).Elem()
+	for _, name := range slices.Sorted(maps.Keys(doc)) {
+		section, ok := fieldByKey(sections, name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown section %s", name))
+			continue
+		}
+		settings, ok := doc[name].(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s must be a table", name))
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(settings)) {
+			setting, ok := fieldByKey(section, key)
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown setting %s.%s", name, key))
+				continue
+			}
+			if err := setTOML(setting, settings[key]); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", name, key, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fieldByKey returns the field of the struct v whose YAML key is key
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if yamlKey(v.Type().Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setTOML sets the setting v to a TOML value. Arrays must hold strings, and
+// other values are parsed like environment variables, so durations are
+// strings in the Go syntax.
+func setTOML(v reflect.Value, value any) error {
+	switch value := value.(type) {
+	case []any:
+		if v.Kind() != reflect.Slice {
+			return errors.New("must not be an array")
+		}
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return errors.New("must be an array of strings")
+			}
+			items = append(items, s)
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	case string, int64, float64, bool:
+		if v.Kind() == reflect.Slice {
+			return errors.New("must be an array")
+		}
+		return set(v, fmt.Sprint(value))
+	default:
+		return fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// ReadEnv overrides c with the DISCACHE_<SECTION>_<KEY> environment variables
+func (c *Config) ReadEnv() error {
+	return c.readEnv(os.LookupEnv)
+}
+
+// readEnv overrides every setting lookup finds a value for
+func (c *Config) readEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		prefix := envPrefix + "_" + envName(sections.Type().Field(i))
+		for j := 0; j < section.NumField(); j++ {
+			name := prefix + "_" + envName(section.Type().Field(j))
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := set(section.Field(j), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// envName returns the environment variable name of a field, its upper case
+// YAML key
+func envName(field reflect.StructField) string {
+	return strings.ToUpper(yamlKey(field))
+}
+
+// yamlKey returns the YAML key of a field, which names it in TOML as well
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return key
+}
+
+// durationType is the type of durations, parsed with time.ParseDuration
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses value into the setting v
+func set(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// Validate reports every setting out of range, naming the setting and the
+// value it got
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	for name, addr := range map[string]string{
+		"node.raft_addr":   c.Node.RaftAddr,
+		"node.listen_addr": c.Node.ListenAddr,
+		"node.admin_addr":  c.Node.AdminAddr,
+	} {
+		if addr != "" {
+			_, _, err := net.SplitHostPort(addr)
+			check(err == nil, "%s must be host:port, got %q", name, addr)
+		}
+	}
+	if _, clientAddrs, err := c.Node.RaftPeers(); err != nil {
+		errs = append(errs, err)
+	} else if len(clientAddrs) > 0 && c.Node.ID != "" {
+		_, ok := clientAddrs[raft.ServerID(c.Node.ID)]
+		check(ok, "node.id %q must be one of node.peers", c.Node.ID)
+	}
+	check(c.Node.ShutdownTimeout > 0, "node.shutdown_timeout must be positive, got %s", c.Node.ShutdownTimeout)
+
+	check(c.Cache.Capacity >= 1, "cache.capacity must be at least 1, got %d", c.Cache.Capacity)
+	check(c.Cache.TTL >= 0, "cache.ttl must not be negative, got %s", c.Cache.TTL)
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes must not be negative, got %d", c.Cache.MaxBytes)
+	check(c.Cache.Eviction == EvictionLRU, "cache.eviction must be %s, the only policy supported, got %q", EvictionLRU, c.Cache.Eviction)
+	check(c.Cache.HotKeys >= 0, "cache.hot_keys must not be negative, got %d", c.Cache.HotKeys)
+	check(c.Cache.HotKeySampling >= 0, "cache.hot_key_sampling must not be negative, got %d", c.Cache.HotKeySampling)
+
+	if err := c.Raft.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("raft: %w", err))
+	}
+
+	if _, err := aof.ParseFsyncPolicy(c.AOF.Fsync); err != nil {
+		errs = append(errs, fmt.Errorf("aof.fsync: %w", err))
+	}
+	check(c.AOF.RewriteMinSize >= 0, "aof.rewrite_min_size must not be negative, got %d", c.AOF.RewriteMinSize)
+
+	check(c.Limits.MaxConns >= 0, "limits.max_conns must not be negative, got %d", c.Limits.MaxConns)
+	check(c.Limits.MaxInFlight >= 0, "limits.max_inflight must not be negative, got %d", c.Limits.MaxInFlight)
+	check(c.Limits.IdleTimeout >= 0, "limits.idle_timeout must not be negative, got %s", c.Limits.IdleTimeout)
+	check(c.Limits.ReadTimeout >= 0, "limits.read_timeout must not be negative, got %s", c.Limits.ReadTimeout)
+	check(c.Limits.WriteTimeout >= 0, "limits.write_timeout must not be negative, got %s", c.Limits.WriteTimeout)
+	check(c.Limits.RateLimit >= 0, "limits.rate_limit must not be negative, got %g", c.Limits.RateLimit)
+	check(c.Limits.RateLimit == 0 || c.Limits.RateBurst >= 1, "limits.rate_burst must be at least 1, got %d", c.Limits.RateBurst)
+
+	check(c.SlowLog.Threshold >= 0, "slowlog.threshold must not be negative, got %s", c.SlowLog.Threshold)
+	check(c.SlowLog.Size >= 1, "slowlog.size must be at least 1, got %d", c.SlowLog.Size)
+
+	if _, err := c.TLS.Config(); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(c.Log.File != "", "log.file must be set")
+	return errors.Join(errs...)
+}
+
+// RaftPeers parses the peers of the node and their client addresses by ID,
+// nil if none is set. IDs, raft addresses and client addresses must be unique.
+func (n Node) RaftPeers() ([]raft.Server, map[raft.ServerID]string, error) {
+	var peers []raft.Server
+	var clientAddrs map[raft.ServerID]string
+	var errs []error
+	seen := make(map[string]bool)
+	for _, peer := range n.Peers {
+		id, addrs, _ := strings.Cut(peer, "=")
+		raftAddr, clientAddr, _ := strings.Cut(addrs, "/")
+		_, _, raftErr := net.SplitHostPort(raftAddr)
+		_, _, clientErr := net.SplitHostPort(clientAddr)
+		if id == "" || raftErr != nil || clientErr != nil {
+			errs = append(errs, fmt.Errorf("node.peers must be id=raft_host:port/client_host:port, got %q", peer))
+			continue
+		}
+
+		for _, unique := range []struct{ name, value string }{{"ID", id}, {"raft address", raftAddr}, {"client address", clientAddr}} {
+			key := unique.name + " " + unique.value
+			if seen[key] {
+				errs = append(errs, fmt.Errorf("node.peers has the %s %s more than once", unique.name, unique.value))
+			}
+			seen[key] = true
+		}
+		peers = append(peers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(id), Address: raft.ServerAddress(raftAddr)})
+		if clientAddrs == nil {
+			clientAddrs = make(map[raft.ServerID]string)
+		}
+		clientAddrs[raft.ServerID(id)] = clientAddr
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return peers, clientAddrs, nil
+}
+
+// Config returns the TLS configuration of the node, nil if TLS is off
+func (t TLS) Config() (*tls.Config, error) {
+	if t.CertFile == "" && t.KeyFile == "" {
+		if t.CAFile != "" {
+			return nil, errors.New("tls.ca_file requires tls.cert_file and tls.key_file")
+		}
+		return nil, nil
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("tls.cert_file and tls.key_file must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: failed to load key pair: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: failed to read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificate found in %s", t.CAFile)
+		}
+		config.RootCAs = pool
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
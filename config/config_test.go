@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfig writes a config file with data and returns its path
+func writeConfig(t *testing.T, data string) string {
+	return writeConfigFile(t, "node.yaml", data)
+}
+
+// writeConfigFile writes the config file name with data and returns its path
+func writeConfigFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+	return path
+}
+
+// TestExampleIsValid tests that the example config loads and validates
+func TestExampleIsValid(t *testing.T) {
+	c, err := Load("../example/node.yaml")
+	assert.Nil(t, err)
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, "node1", c.Node.ID)
+	assert.Equal(t, 100000, c.Cache.Capacity)
+
+	peers, clientAddrs, err := c.Node.RaftPeers()
+	assert.Nil(t, err)
+	assert.Equal(t, raft.Server{Suffrage: raft.Voter, ID: "node2", Address: "127.0.0.1:8081"}, peers[1])
+	assert.Equal(t, "127.0.0.1:9081", clientAddrs["node2"])
+}
+
+// TestTOMLExampleMatchesYAML tests that the TOML example sets every setting
+// to the value the YAML example does
+func TestTOMLExampleMatchesYAML(t *testing.T) {
+	want, err := Load("../example/node.yaml")
+	assert.Nil(t, err)
+	c, err := Load("../example/node.toml")
+	assert.Nil(t, err)
+	assert.Equal(t, want, c)
+}
+
+// TestReadTOML tests that a TOML file overrides only the settings it sets and
+// that unknown keys and values of the wrong type name their setting
+func TestReadTOML(t *testing.T) {
+	c := Default()
+	data := "[cache]\ncapacity = 500\n[raft]\nelection_timeout = \"2s\"\n[limits]\nrate_limit = 2.5\n[node]\npeers = [\"node1=10.0.0.1:8080/10.0.0.1:9080\"]\n"
+	assert.Nil(t, c.ReadFile(writeConfigFile(t, "node.toml", data)))
+	assert.Equal(t, 500, c.Cache.Capacity)
+	assert.Equal(t, 2*time.Second, c.Raft.ElectionTimeout)
+	assert.Equal(t, 2.5, c.Limits.RateLimit)
+	assert.Equal(t, []string{"node1=10.0.0.1:8080/10.0.0.1:9080"}, c.Node.Peers)
+	assert.Equal(t, Default().Cache.TTL, c.Cache.TTL)
+
+	assert.Nil(t, c.ReadFile(writeConfigFile(t, "node.toml", "")))
+
+	err := c.ReadFile(writeConfigFile(t, "node.toml", "log = \"discache.log\"\n[cache]\ncapacty = 500\nttl = 5\n[node]\npeers = \"node1\"\n[nodes]\nid = \"node1\"\n"))
+	assert.ErrorContains(t, err, "log must be a table")
+	assert.ErrorContains(t, err, "unknown setting cache.capacty")
+	assert.ErrorContains(t, err, "cache.ttl: time: missing unit")
+	assert.ErrorContains(t, err, "node.peers: must be an array")
+	assert.ErrorContains(t, err, "unknown section nodes")
+	assert.ErrorContains(t, c.ReadFile(writeConfigFile(t, "node.toml", "[cache\n")), "failed to parse config")
+}
+
+// TestReadFileKeepsMissingSettings tests that a file overrides only the
+// settings it sets and that unknown keys are an error
+func TestReadFileKeepsMissingSettings(t *testing.T) {
+	c := Default()
+	assert.Nil(t, c.ReadFile(writeConfig(t, "cache:\n  capacity: 500\nraft:\n  election_timeout: 2s\n")))
+	assert.Equal(t, 500, c.Cache.Capacity)
+	assert.Equal(t, 2*time.Second, c.Raft.ElectionTimeout)
+	assert.Equal(t, Default().Cache.TTL, c.Cache.TTL)
+	assert.Equal(t, Default().Raft.HeartbeatTimeout, c.Raft.HeartbeatTimeout)
+
+	assert.Nil(t, c.ReadFile(writeConfig(t, "")))
+	assert.ErrorContains(t, c.ReadFile(writeConfig(t, "cache:\n  capacty: 500\n")), "field capacty not found")
+}
+
+// TestEnvOverridesFile tests that DISCACHE_* variables override the file and
+// that invalid values name their variable
+func TestEnvOverridesFile(t *testing.T) {
+	c := Default()
+	assert.Nil(t, c.ReadFile(writeConfig(t, "cache:\n  capacity: 500\nauth:\n  password: file\n")))
+
+	env := map[string]string{
+		"DISCACHE_CACHE_CAPACITY":          "1000",
+		"DISCACHE_AUTH_PASSWORD":           "env",
+		"DISCACHE_RAFT_ELECTION_TIMEOUT":   "3s",
+		"DISCACHE_RAFT_SNAPSHOT_THRESHOLD": "100",
+		"DISCACHE_LIMITS_RATE_LIMIT":       "2.5",
+		"DISCACHE_NODE_BOOTSTRAP":          "false",
+		"DISCACHE_NODE_PEERS":              "node1=10.0.0.1:8080/10.0.0.1:9080, node2=10.0.0.2:8080/10.0.0.2:9080",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	assert.Nil(t, c.readEnv(lookup))
+	assert.Equal(t, 1000, c.Cache.Capacity)
+	assert.Equal(t, "env", c.Auth.Password)
+	assert.Equal(t, 3*time.Second, c.Raft.ElectionTimeout)
+	assert.Equal(t, uint64(100), c.Raft.SnapshotThreshold)
+	assert.Equal(t, 2.5, c.Limits.RateLimit)
+	assert.False(t, c.Node.Bootstrap)
+	assert.Equal(t, []string{"node1=10.0.0.1:8080/10.0.0.1:9080", "node2=10.0.0.2:8080/10.0.0.2:9080"}, c.Node.Peers)
+
+	env = map[string]string{"DISCACHE_CACHE_CAPACITY": "many"}
+	assert.ErrorContains(t, c.readEnv(lookup), "DISCACHE_CACHE_CAPACITY")
+}
+
+// TestValidate tests that Validate accepts the defaults and reports every
+// invalid setting
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Default().Validate())
+
+	c := Default()
+	c.Node.RaftAddr = "8080"
+	c.Node.Peers = []string{"node1"}
+	c.Cache.Capacity = 0
+	c.Cache.Eviction = "lfu"
+	c.Raft.MaxAppendEntries = 2048
+	c.AOF.Fsync = "sometimes"
+	c.TLS.CertFile = "node.crt"
+	err := c.Validate()
+	assert.ErrorContains(t, err, `node.raft_addr must be host:port, got "8080"`)
+	assert.ErrorContains(t, err, `node.peers must be id=raft_host:port/client_host:port, got "node1"`)
+	assert.ErrorContains(t, err, "cache.capacity must be at least 1, got 0")
+	assert.ErrorContains(t, err, `cache.eviction must be lru, the only policy supported, got "lfu"`)
+	assert.ErrorContains(t, err, "raft: max append entries must be between 1 and 1024, got 2048")
+	assert.ErrorContains(t, err, "aof.fsync")
+	assert.ErrorContains(t, err, "tls.cert_file and tls.key_file must be set together")
+}
+
+// TestValidatePeers tests that peers need unique IDs, raft and client
+// addresses, and that the node is one of them
+func TestValidatePeers(t *testing.T) {
+	c := Default()
+	c.Node.ID = "node1"
+	c.Node.Peers = []string{"node1=127.0.0.1:8080/127.0.0.1:9080", "node2=127.0.0.1:8081/127.0.0.1:9081"}
+	assert.Nil(t, c.Validate())
+
+	c.Node.ID = "node3"
+	assert.ErrorContains(t, c.Validate(), `node.id "node3" must be one of node.peers`)
+
+	c.Node.ID = "node1"
+	c.Node.Peers = []string{"node1=127.0.0.1:8080/127.0.0.1:9080", "node2=127.0.0.1:8081/127.0.0.1:9080", "node2=127.0.0.1:8081/127.0.0.1:9082"}
+	err := c.Validate()
+	assert.ErrorContains(t, err, "node.peers has the ID node2 more than once")
+	assert.ErrorContains(t, err, "node.peers has the raft address 127.0.0.1:8081 more than once")
+	assert.ErrorContains(t, err, "node.peers has the client address 127.0.0.1:9080 more than once")
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegistryExposition tests the text format written for every kind of metric
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	commands := r.NewCounter("commands_total", "Commands served.", "command")
+	commands.Inc("GET")
+	commands.Add(2, "SET")
+	commands.Inc("GET")
+	conns := r.NewGauge("connections", "Open connections.")
+	conns.Add(3)
+	conns.Add(-1)
+	r.NewGaugeFunc("items", "Items.", func() float64 { return 42 })
+	r.NewCounterVecFunc("evictions_total", "Evictions by reason.", "reason", func() map[string]float64 {
+		return map[string]float64{"memory": 1, "capacity": 4}
+	})
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "command")
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.1, "GET")
+	latency.Observe(3, "GET")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP commands_total Commands served.
+# TYPE commands_total counter
+commands_total{command="GET"} 2
+commands_total{command="SET"} 2
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 2
+# HELP evictions_total Evictions by reason.
+# TYPE evictions_total counter
+evictions_total{reason="capacity"} 4
+evictions_total{reason="memory"} 1
+# HELP items Items.
+# TYPE items gauge
+items 42
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{command="GET",le="0.1"} 2
+latency_seconds_bucket{command="GET",le="1"} 2
+latency_seconds_bucket{command="GET",le="+Inf"} 3
+latency_seconds_sum{command="GET"} 3.15
+latency_seconds_count{command="GET"} 3
+`, string(body))
+	assert.Equal(t, uint64(3), latency.Count("GET"))
+}
+
+// TestRegistryMisuse tests that programming errors are caught early
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.", "path")
+	assert.Panics(t, func() { r.NewGauge("requests_total", "Requests.") })
+	assert.Panics(t, func() { c.Inc() })
+
+	g := r.NewGauge("label_escaping", "Escaping.", "value")
+	g.Set(1, "a \"quoted\"\nvalue")
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `label_escaping{value="a \"quoted\"\nvalue"} 1`)
+}
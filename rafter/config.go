@@ -0,0 +1,109 @@
+package rafter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// minRaftTimeout is the shortest timeout raft accepts
+const minRaftTimeout = 5 * time.Millisecond
+
+// RaftConfig tunes the raft node and its transport
+type RaftConfig struct {
+	HeartbeatTimeout   time.Duration `yaml:"heartbeat_timeout"`    // Time without contact from the leader before a follower starts an election
+	ElectionTimeout    time.Duration `yaml:"election_timeout"`     // Time without contact from the leader before a candidate starts an election
+	LeaderLeaseTimeout time.Duration `yaml:"leader_lease_timeout"` // Time the leader keeps leading without contact from a quorum
+	CommitTimeout      time.Duration `yaml:"commit_timeout"`       // Time without an Apply before the leader heartbeats the commit index
+
+	SnapshotThreshold uint64        `yaml:"snapshot_threshold"` // Log entries since the last snapshot that trigger a new one
+	SnapshotInterval  time.Duration `yaml:"snapshot_interval"`  // How often the threshold is checked, jittered by up to twice as long
+	SnapshotRetain    int           `yaml:"snapshot_retain"`    // Snapshots kept in the data directory
+	TrailingLogs      uint64        `yaml:"trailing_logs"`      // Log entries kept after a snapshot so slow followers can catch up without it
+	MaxAppendEntries  int           `yaml:"max_append_entries"` // Log entries sent to a follower in one request, at most 1024
+
+	TransportPool    int           `yaml:"transport_pool"`    // Connections kept to every other node
+	TransportTimeout time.Duration `yaml:"transport_timeout"` // I/O deadline of the raft transport
+}
+
+// DefaultRaftConfig returns the raft defaults, with the longer election
+// timeout discache uses to ride out slow nodes
+func DefaultRaftConfig() RaftConfig {
+	defaults := raft.DefaultConfig()
+	return RaftConfig{
+		HeartbeatTimeout:   defaults.HeartbeatTimeout,
+		ElectionTimeout:    raftClusterElectionTimeout,
+		LeaderLeaseTimeout: defaults.LeaderLeaseTimeout,
+		CommitTimeout:      defaults.CommitTimeout,
+		SnapshotThreshold:  defaults.SnapshotThreshold,
+		SnapshotInterval:   defaults.SnapshotInterval,
+		SnapshotRetain:     2,
+		TrailingLogs:       defaults.TrailingLogs,
+		MaxAppendEntries:   defaults.MaxAppendEntries,
+		TransportPool:      3,
+		TransportTimeout:   10 * time.Second,
+	}
+}
+
+// Validate reports every setting out of range, naming the setting and the
+// value it got
+func (c RaftConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HeartbeatTimeout >= minRaftTimeout, "heartbeat timeout must be at least %s, got %s", minRaftTimeout, c.HeartbeatTimeout)
+	check(c.ElectionTimeout >= c.HeartbeatTimeout, "election timeout must be at least the heartbeat timeout %s, got %s", c.HeartbeatTimeout, c.ElectionTimeout)
+	check(c.LeaderLeaseTimeout >= minRaftTimeout, "leader lease timeout must be at least %s, got %s", minRaftTimeout, c.LeaderLeaseTimeout)
+	check(c.LeaderLeaseTimeout <= c.HeartbeatTimeout, "leader lease timeout must be at most the heartbeat timeout %s, got %s", c.HeartbeatTimeout, c.LeaderLeaseTimeout)
+	check(c.CommitTimeout >= time.Millisecond, "commit timeout must be at least 1ms, got %s", c.CommitTimeout)
+
+	check(c.SnapshotThreshold > 0, "snapshot threshold must be positive")
+	check(c.SnapshotInterval >= minRaftTimeout, "snapshot interval must be at least %s, got %s", minRaftTimeout, c.SnapshotInterval)
+	check(c.SnapshotRetain >= 1, "snapshot retain must be at least 1, got %d", c.SnapshotRetain)
+	check(c.MaxAppendEntries >= 1 && c.MaxAppendEntries <= 1024, "max append entries must be between 1 and 1024, got %d", c.MaxAppendEntries)
+
+	check(c.TransportPool >= 1, "transport pool must be at least 1, got %d", c.TransportPool)
+	check(c.TransportTimeout > 0, "transport timeout must be positive, got %s", c.TransportTimeout)
+	return errors.Join(errs...)
+}
+
+// config returns the configuration of the raft node id
+func (c RaftConfig) config(id string) (*raft.Config, error) {
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(id)
+	config.HeartbeatTimeout = c.HeartbeatTimeout
+	config.ElectionTimeout = c.ElectionTimeout
+	config.LeaderLeaseTimeout = c.LeaderLeaseTimeout
+	config.CommitTimeout = c.CommitTimeout
+	config.SnapshotThreshold = c.SnapshotThreshold
+	config.SnapshotInterval = c.SnapshotInterval
+	config.TrailingLogs = c.TrailingLogs
+	config.MaxAppendEntries = c.MaxAppendEntries
+
+	// Anything raft checks beyond Validate
+	if err := raft.ValidateConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// dataDir returns the directory holding the raft stores of the node, created
+// if missing. It defaults to data/<id> in the working directory.
+func dataDir(opts RaftServerOpts) (string, error) {
+	dir := opts.DataDir
+	if dir == "" {
+		dir = filepath.Join("data", opts.ID)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return dir, nil
+}
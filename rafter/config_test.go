@@ -0,0 +1,28 @@
+package rafter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRaftConfigValidate tests that Validate accepts the defaults and reports
+// every setting out of range
+func TestRaftConfigValidate(t *testing.T) {
+	assert.Nil(t, DefaultRaftConfig().Validate())
+	_, err := DefaultRaftConfig().config("node1")
+	assert.Nil(t, err)
+
+	c := DefaultRaftConfig()
+	c.HeartbeatTimeout = time.Second
+	c.ElectionTimeout = 500 * time.Millisecond
+	c.MaxAppendEntries = 2048
+	c.TransportPool = 0
+	err = c.Validate()
+	assert.ErrorContains(t, err, "election timeout must be at least the heartbeat timeout 1s, got 500ms")
+	assert.ErrorContains(t, err, "max append entries must be between 1 and 1024, got 2048")
+	assert.ErrorContains(t, err, "transport pool must be at least 1, got 0")
+
+	assert.NotNil(t, RaftConfig{}.Validate())
+}
@@ -0,0 +1,64 @@
+package rafter
+
+import (
+	"fmt"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+)
+
+// applyData runs a data write on the cache. It returns the count or length
+// reported by the operation as an int64, the value popped from a list, or an
+// error.
+func (f *raftFSM) applyData(cmd *transport.CommandData, opts cache.WriteOpts) any {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	var n int
+	var err error
+	switch cmd.Op {
+	case transport.OpHSet:
+		var created bool
+		created, err = f.cache.HSet(cmd.Key, cmd.Args[0], cmd.Args[1], opts)
+		if created {
+			n = 1
+		}
+	case transport.OpHDel:
+		n, err = f.cache.HDel(cmd.Key, cmd.Args, opts)
+	case transport.OpLPush:
+		n, err = f.cache.LPush(cmd.Key, cmd.Args, opts)
+	case transport.OpRPush:
+		n, err = f.cache.RPush(cmd.Key, cmd.Args, opts)
+	case transport.OpLPop, transport.OpRPop:
+		pop := f.cache.RPop
+		if cmd.Op == transport.OpLPop {
+			pop = f.cache.LPop
+		}
+		value, err := pop(cmd.Key, opts)
+		if err != nil {
+			return err
+		}
+		return value
+	case transport.OpSAdd:
+		n, err = f.cache.SAdd(cmd.Key, cmd.Args, opts)
+	case transport.OpSRem:
+		n, err = f.cache.SRem(cmd.Key, cmd.Args, opts)
+	case transport.OpZAdd:
+		members := make([]cache.ZMember, 0, len(cmd.Args)/2)
+		for i := 0; i < len(cmd.Args); i += 2 {
+			score, _ := transport.ParseScore(cmd.Args[i]) // Checked by Validate
+			members = append(members, cache.ZMember{Member: cmd.Args[i+1], Score: score})
+		}
+		n, err = f.cache.ZAdd(cmd.Key, members, opts)
+	case transport.OpZRem:
+		n, err = f.cache.ZRem(cmd.Key, cmd.Args, opts)
+	default:
+		return fmt.Errorf("%s is not a write", cmd.Op)
+	}
+
+	if err != nil {
+		return err
+	}
+	return int64(n)
+}
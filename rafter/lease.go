@@ -0,0 +1,165 @@
+package rafter
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/discache/transport"
+	"github.com/dhyanio/discache/util"
+	"github.com/dhyanio/gogger"
+	"github.com/hashicorp/raft"
+)
+
+// leaseCheckInterval is how often the leader looks for expired leases
+const leaseCheckInterval = 100 * time.Millisecond
+
+// lease is a granted lease. Whether it expired is only decided by the leader,
+// which proposes the expiry through raft so every replica drops it at the
+// same log index.
+type lease struct {
+	Token   uint64 // Raft index of the acquire, used as fencing token
+	TTL     time.Duration
+	Renewed uint64    // Raft index of the last acquire or renew
+	granted time.Time // Local time the last grant was applied, only used to decide expiry
+}
+
+// leaseTable holds the granted leases of the FSM
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]*lease
+}
+
+// newLeaseTable creates an empty lease table
+func newLeaseTable() *leaseTable {
+	return &leaseTable{leases: make(map[string]*lease)}
+}
+
+// apply runs a lease command committed at index and returns the fencing
+// token of the lease. Acquiring a held lease returns a ConflictError carrying
+// the token of the holder, and any other operation on a lease that is not
+// held with the given token returns a KeyNotFoundError.
+func (t *leaseTable) apply(cmd *transport.CommandLease, index uint64) (uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name := string(cmd.Name)
+	current, held := t.leases[name]
+	if cmd.Op != transport.LeaseAcquire && (!held || current.Token != cmd.Token) {
+		return 0, &util.KeyNotFoundError{Key: name}
+	}
+
+	switch cmd.Op {
+	case transport.LeaseAcquire:
+		if held {
+			return 0, &util.ConflictError{Key: name, Version: current.Token}
+		}
+		t.leases[name] = &lease{
+			Token:   index,
+			TTL:     time.Duration(cmd.TTL) * time.Millisecond,
+			Renewed: index,
+			granted: time.Now(),
+		}
+		return index, nil
+	case transport.LeaseRenew:
+		current.TTL = time.Duration(cmd.TTL) * time.Millisecond
+		current.Renewed = index
+		current.granted = time.Now()
+		return current.Token, nil
+	case transport.LeaseRelease:
+		delete(t.leases, name)
+		return current.Token, nil
+	case transport.LeaseExpire:
+		// A renew committed after the expiry was decided keeps the lease
+		if current.Renewed != cmd.Renewed {
+			return 0, &util.KeyNotFoundError{Key: name}
+		}
+		delete(t.leases, name)
+		return current.Token, nil
+	default:
+		return 0, fmt.Errorf("unknown lease operation %d", cmd.Op)
+	}
+}
+
+// expired returns the expiry commands of the leases whose TTL elapsed at now
+func (t *leaseTable) expired(now time.Time) []*transport.CommandLease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var cmds []*transport.CommandLease
+	for name, l := range t.leases {
+		if now.Sub(l.granted) >= l.TTL {
+			cmds = append(cmds, &transport.CommandLease{
+				Op:      transport.LeaseExpire,
+				Name:    []byte(name),
+				Token:   l.Token,
+				Renewed: l.Renewed,
+			})
+		}
+	}
+	return cmds
+}
+
+// snapshot copies the granted leases
+func (t *leaseTable) snapshot() map[string]lease {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	leases := make(map[string]lease, len(t.leases))
+	for name, l := range t.leases {
+		leases[name] = *l
+	}
+	return leases
+}
+
+// writeLeases writes leases copied by snapshot to w
+func writeLeases(w io.Writer, leases map[string]lease) error {
+	return gob.NewEncoder(w).Encode(leases)
+}
+
+// restore replaces the granted leases with the ones written by writeLeases.
+// Restored leases get their full TTL again from now.
+func (t *leaseTable) restore(r io.Reader) error {
+	leases := make(map[string]lease)
+	if err := gob.NewDecoder(r).Decode(&leases); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.leases = make(map[string]*lease, len(leases))
+	now := time.Now()
+	for name, l := range leases {
+		l.granted = now
+		t.leases[name] = &l
+	}
+	return nil
+}
+
+// expireLeases proposes the expiry of the leases that outlived their TTL
+// whenever this node is the leader, until stop is closed
+func (f *raftFSM) expireLeases(store server.Store, log *gogger.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if store.State() != raft.Leader {
+			continue
+		}
+		for _, cmd := range f.leases.expired(time.Now()) {
+			if _, _, err := store.Apply(context.Background(), cmd.Bytes()); err != nil {
+				log.Warn().Msgf("failed to expire lease %s: %v", cmd.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rafter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/dhyanio/discache/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLeaseTable tests granting, fencing and expiring leases
+func TestLeaseTable(t *testing.T) {
+	leases := newLeaseTable()
+	acquire := &transport.CommandLease{Op: transport.LeaseAcquire, Name: []byte("job"), TTL: 50}
+
+	token, err := leases.apply(acquire, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), token)
+
+	_, err = leases.apply(acquire, 11)
+	assert.Equal(t, &util.ConflictError{Key: "job", Version: 10}, err)
+
+	renew := &transport.CommandLease{Op: transport.LeaseRenew, Name: []byte("job"), Token: 9, TTL: 50}
+	_, err = leases.apply(renew, 12)
+	assert.IsType(t, &util.KeyNotFoundError{}, err, "a stale token can't renew")
+
+	assert.Empty(t, leases.expired(time.Now()))
+	expiries := leases.expired(time.Now().Add(time.Second))
+	assert.Len(t, expiries, 1)
+	assert.Equal(t, uint64(10), expiries[0].Renewed)
+
+	// A renew committed before the expiry it raced with keeps the lease
+	renew.Token = 10
+	_, err = leases.apply(renew, 13)
+	assert.Nil(t, err)
+	_, err = leases.apply(expiries[0], 14)
+	assert.IsType(t, &util.KeyNotFoundError{}, err)
+
+	expiries = leases.expired(time.Now().Add(time.Second))
+	_, err = leases.apply(expiries[0], 15)
+	assert.Nil(t, err)
+
+	token, err = leases.apply(acquire, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(16), token, "fencing tokens increase across holders")
+}
+
+// TestLeaseTableSnapshot tests that leases survive a snapshot with a fresh TTL
+func TestLeaseTableSnapshot(t *testing.T) {
+	leases := newLeaseTable()
+	leases.apply(&transport.CommandLease{Op: transport.LeaseAcquire, Name: []byte("job"), TTL: 50}, 7)
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeLeases(&buf, leases.snapshot()))
+
+	restored := newLeaseTable()
+	assert.Nil(t, restored.restore(&buf))
+	assert.Empty(t, restored.expired(time.Now()))
+
+	_, err := restored.apply(&transport.CommandLease{Op: transport.LeaseRelease, Name: []byte("job"), Token: 7}, 8)
+	assert.Nil(t, err)
+}
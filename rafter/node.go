@@ -0,0 +1,104 @@
+package rafter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/gogger"
+	"github.com/hashicorp/raft"
+)
+
+// Node is a running discache node: its store, the cache server clients talk
+// to and the admin HTTP server
+type Node struct {
+	Raft   *raft.Raft // nil for a standalone node
+	Store  server.Store
+	Server *server.Server
+	log    *gogger.Logger
+	admin  *http.Server
+	stores []io.Closer   // Bolt stores or append-only file closed once the node stopped
+	stop   chan struct{} // Closed to stop the background loops
+
+	transferLeadership bool // Hand leadership to another voter on shutdown
+
+	shutdownOnce sync.Once
+	shutdownErr  error // Error of the first Shutdown, returned by every call
+}
+
+// serve starts the admin server on adminAddr and the cache server
+func (n *Node) serve(adminAddr string) {
+	// Serve profiles, health checks, status and metrics of the node
+	n.admin = &http.Server{
+		Addr:              adminAddr,
+		Handler:           n.Server.AdminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		n.log.Info().Msgf("admin server starting on [%s]", adminAddr)
+		if err := n.admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			n.log.Error().Msgf("admin server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		if err := n.Server.Start(); err != nil && !errors.Is(err, server.ErrServerClosed) {
+			n.log.Fatal().Msgf("failed to start server : %s", err.Error())
+		}
+	}()
+}
+
+// Shutdown stops the node gracefully. The cache server stops accepting
+// connections and drains the commands in flight. A raft node then hands
+// leadership to another voter if TransferLeadership was set and it leads,
+// takes a final snapshot and shuts raft down. The stores are closed, and the
+// admin server stops last, so health checks report the node until it is
+// gone. If ctx ends first, the remaining connections are dropped but the node
+// is still shut down. Later calls return the error of the first one.
+func (n *Node) Shutdown(ctx context.Context) error {
+	n.shutdownOnce.Do(func() {
+		n.shutdownErr = n.shutdown(ctx)
+	})
+	return n.shutdownErr
+}
+
+// shutdown stops the node once for Shutdown
+func (n *Node) shutdown(ctx context.Context) error {
+	var errs []error
+
+	if err := n.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	close(n.stop)
+
+	if n.Raft != nil {
+		if n.transferLeadership && n.Raft.State() == raft.Leader {
+			if err := n.Raft.LeadershipTransfer().Error(); err != nil {
+				n.log.Warn().Msgf("failed to transfer leadership: %v", err)
+			} else {
+				n.log.Info().Msgf("transferred leadership")
+			}
+		}
+
+		if err := n.Raft.Snapshot().Error(); err != nil && !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			n.log.Warn().Msgf("failed to take a final snapshot: %v", err)
+		}
+		if err := n.Raft.Shutdown().Error(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, store := range n.stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := n.admin.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
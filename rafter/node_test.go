@@ -0,0 +1,24 @@
+package rafter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/gogger"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNodeShutdownTwice tests that shutting a node down again returns
+// instead of panicking
+func TestNodeShutdownTwice(t *testing.T) {
+	log, err := gogger.NewLogger(filepath.Join(t.TempDir(), "node.log"), gogger.INFO)
+	assert.Nil(t, err)
+	fsm := NewRaftFSM(cache.NewCache(cache.CacheOpts{Capacity: 10}))
+	node, err := Standalone(fsm, StandaloneOpts{ID: "node1", ListenAddr: "127.0.0.1:0", AdminAddr: "127.0.0.1:0", Log: log})
+	assert.Nil(t, err)
+
+	assert.Nil(t, node.Shutdown(context.Background()))
+	assert.Nil(t, node.Shutdown(context.Background()))
+}
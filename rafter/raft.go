@@ -1,14 +1,24 @@
 package rafter
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/metrics"
 	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/discache/trace"
 	"github.com/dhyanio/discache/transport"
 	"github.com/dhyanio/gogger"
 	"github.com/hashicorp/raft"
@@ -18,31 +28,81 @@ import (
 // RaftServerOpts represents the options for a Raft server
 type RaftServerOpts struct {
 	ID         string
-	ListenAddr string
+	ListenAddr string // Address of the raft transport
+	ClientAddr string // Address the cache server listens on, the one of ID in ClientAddrs or the listen host on nodeHTTPServer if empty
 	IsLeader   bool
 	LeaderAddr string
+	Peers      []raft.Server // Voters the leader bootstraps the cluster with, node1 to node3 on 127.0.0.1:8080-8082 serving clients on 9080-9082 if empty
 	Log        *gogger.Logger
+	AdminAddr  string        // Address of the admin HTTP server, the node host on nodeAdminServer if empty
+	Tracer     *trace.Tracer // Tracer of the commands served and applied, nil traces nothing
+
+	// ClientAddrs are the addresses the Peers serve clients on by ID, which
+	// writes are forwarded to
+	ClientAddrs map[raft.ServerID]string
+
+	TransferLeadership bool // Hand leadership to another voter on shutdown
+
+	SlowLogThreshold time.Duration // Commands taking at least this long are kept in the slow log, 0 disables it
+	SlowLogSize      int           // Entries kept in the slow log
+
+	Limits server.Limits // Connection, in-flight, timeout and rate limits of clients
+
+	DataDir string     // Directory of the raft log, stable and snapshot stores, data/<ID> if empty
+	Raft    RaftConfig // Raft timeouts, snapshots and transport, DefaultRaftConfig if zero
+
+	TLSConfig *tls.Config // Serves clients and forwards writes over TLS, nil uses plain TCP
+	Password  string      // Password clients must AUTH with, empty requires none
 }
 
 const (
 	raftClusterElectionTimeout = 5 * time.Second
-	nodeHTTPServer             = ":9080" // HTTP server default port for the node
+	nodeHTTPServer             = ":9080" // Cache server default port for the node
+	nodeAdminServer            = ":9100" // Admin HTTP server default port for the node
 )
 
 // raftFSM is a finite state machine that applies log entries to the key-value store.
 type raftFSM struct {
-	cache *cache.Cache
+	cache  *cache.Cache
+	broker *server.Broker
+	leases *leaseTable
+	tracer *trace.Tracer
+	index  atomic.Uint64 // Index of the last log entry applied
 }
 
 // NewRaftFSM creates a new Raft finite state machine.
 func NewRaftFSM(cache *cache.Cache) *raftFSM {
-	return &raftFSM{
-		cache: cache,
+	f := &raftFSM{
+		cache:  cache,
+		leases: newLeaseTable(),
 	}
+	if cache != nil {
+		cache.AddRemoveListener(f.onRemove)
+	}
+	return f
 }
 
-// Apply applies a Raft log entry to the Cache.
+// Apply applies a Raft log entry to the Cache. Entries proposed within a
+// trace are traced on every replica applying them.
 func (f *raftFSM) Apply(log *raft.Log) any {
+	f.index.Store(log.Index)
+
+	sc, err := trace.Decode(log.Extensions)
+	if err != nil {
+		return f.apply(log)
+	}
+	_, span := f.tracer.Start(trace.ContextWithRemote(context.Background(), sc), "fsm.apply")
+	span.SetAttribute("index", strconv.FormatUint(log.Index, 10))
+	result := f.apply(log)
+	if err, ok := result.(error); ok {
+		span.SetError(err)
+	}
+	span.End()
+	return result
+}
+
+// apply applies the command of a Raft log entry to the Cache
+func (f *raftFSM) apply(log *raft.Log) any {
 	r := bytes.NewReader(log.Data)
 
 	cmd, err := transport.ParseCommand(r)
@@ -52,80 +112,293 @@ func (f *raftFSM) Apply(log *raft.Log) any {
 
 	switch v := cmd.(type) {
 	case *transport.CommandSet:
-		if err := f.cache.Put(v.Key, v.Value); err != nil {
-			return fmt.Errorf("failed to set value: %s", err.Error())
+		opts := cache.WriteOpts{TTL: seconds(v.TTL), At: unixMilli(v.At), Version: log.Index}
+		for _, tag := range v.Tags {
+			opts.Tags = append(opts.Tags, string(tag))
+		}
+		if _, err := f.cache.Set(v.Key, v.Value, opts); err != nil {
+			return fmt.Errorf("failed to set value: %w", err)
 		}
+		f.publish(transport.EventSet, v.Key)
 		return nil
-	case *transport.CommandGet:
-		value, err := f.cache.Get(v.Key)
+	case *transport.CommandSetIf:
+		opts := cache.WriteOpts{
+			TTL:      seconds(v.TTL),
+			At:       unixMilli(v.At),
+			Version:  log.Index,
+			Cond:     condition(v.Cond),
+			Expected: v.Version,
+		}
+		version, err := f.cache.Set(v.Key, v.Value, opts)
+		if err != nil {
+			return fmt.Errorf("failed to set value: %w", err)
+		}
+		f.publish(transport.EventSet, v.Key)
+		return version
+	case *transport.CommandDeleteIf:
+		if err := f.cache.DeleteIf(v.Key, cache.CondVersion, v.Version); err != nil {
+			return fmt.Errorf("failed to delete value: %w", err)
+		}
+		f.publish(transport.EventDelete, v.Key)
+		return log.Index
+	case *transport.CommandTxn:
+		checks := make([]cache.TxnCheck, len(v.Checks))
+		for i, check := range v.Checks {
+			checks[i] = cache.TxnCheck{Key: check.Key, Cond: condition(check.Cond), Expected: check.Version}
+		}
+		ops := make([]cache.TxnOp, len(v.Ops))
+		for i, op := range v.Ops {
+			ops[i] = cache.TxnOp{Delete: op.Type == transport.TxnDelete, Key: op.Key, Value: op.Value, TTL: seconds(op.TTL), At: unixMilli(op.At)}
+		}
+		version, err := f.cache.Txn(checks, ops, log.Index)
+		if err != nil {
+			return fmt.Errorf("failed to apply transaction: %w", err)
+		}
+		for _, op := range ops {
+			if op.Delete {
+				f.publish(transport.EventDelete, op.Key)
+			} else {
+				f.publish(transport.EventSet, op.Key)
+			}
+		}
+		return version
+	case *transport.CommandData:
+		opts := cache.WriteOpts{TTL: seconds(v.TTL), At: unixMilli(v.At), Version: log.Index}
+		result := f.applyData(v, opts)
+		if err, ok := result.(error); ok {
+			return fmt.Errorf("failed to apply %s: %w", v.Op, err)
+		}
+		// Emptied collections are removed
+		if f.cache.Has(v.Key) {
+			f.publish(transport.EventSet, v.Key)
+		} else {
+			f.publish(transport.EventDelete, v.Key)
+		}
+		return result
+	case *transport.CommandInvalidate:
+		var keys []string
+		if v.By == transport.InvalidateByTag {
+			keys = f.cache.InvalidateTag(string(v.Match))
+		} else {
+			keys = f.cache.InvalidatePrefix(v.Match)
+		}
+		for _, key := range keys {
+			f.publish(transport.EventDelete, []byte(key))
+		}
+		return int64(len(keys))
+	case *transport.CommandLease:
+		token, err := f.leases.apply(v, log.Index)
+		if err != nil {
+			return fmt.Errorf("failed to %s lease: %w", v.Op, err)
+		}
+		return token
+	case *transport.CommandIncr:
+		opts := cache.WriteOpts{TTL: seconds(v.TTL), At: unixMilli(v.At), Version: log.Index}
+		value, err := f.cache.Incr(v.Key, v.Delta, v.Initial, opts)
 		if err != nil {
-			return fmt.Errorf("failed to get value: %s", err.Error())
+			return fmt.Errorf("failed to increment value: %w", err)
 		}
+		f.publish(transport.EventSet, v.Key)
 		return value
+	case *transport.CommandExpire:
+		var sliding time.Duration
+		if v.Sliding {
+			sliding = time.Duration(v.TTL) * time.Millisecond
+		}
+		if err := f.cache.Expire(v.Key, unixMilli(v.At), sliding); err != nil {
+			return fmt.Errorf("failed to expire key: %w", err)
+		}
+		// An expiry in the past already published the expiration
+		if f.cache.Has(v.Key) {
+			f.publish(transport.EventTTL, v.Key)
+		}
+		return nil
+	case *transport.CommandTouch:
+		return int64(f.cache.Touch(time.UnixMilli(v.At), v.Keys...))
+	case *transport.CommandGet:
+		item, err := f.cache.GetItem(v.Key)
+		if err != nil {
+			return fmt.Errorf("failed to get value: %w", err)
+		}
+		return item
 	default:
 		return fmt.Errorf("unknown operation: %T", cmd)
 	}
 }
 
+// seconds converts a TTL in seconds from the wire to a duration
+func seconds(ttl int) time.Duration {
+	return time.Duration(ttl) * time.Second
+}
+
+// unixMilli converts an expiry in Unix milliseconds from the wire to a time,
+// zero for none
+func unixMilli(at int64) time.Time {
+	if at == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(at)
+}
+
+// condition maps a wire condition to the cache condition
+func condition(cond transport.Condition) cache.Condition {
+	switch cond {
+	case transport.CondVersion:
+		return cache.CondVersion
+	case transport.CondAbsent:
+		return cache.CondAbsent
+	case transport.CondPresent:
+		return cache.CondPresent
+	default:
+		return cache.CondNone
+	}
+}
+
+// onRemove publishes evictions and expirations of cache items. Expirations
+// noticed by local reads outside of Apply carry the last applied index.
+func (f *raftFSM) onRemove(key string, _ []byte, reason cache.RemoveReason) {
+	switch reason {
+	case cache.RemoveEvicted:
+		f.publish(transport.EventEvict, []byte(key))
+	case cache.RemoveExpired:
+		f.publish(transport.EventExpire, []byte(key))
+	}
+}
+
+// publish sends a keyspace event for the entry being applied to the broker
+func (f *raftFSM) publish(typ transport.EventType, key []byte) {
+	if f.broker == nil {
+		return
+	}
+	f.broker.Publish(transport.Event{Type: typ, Index: f.index.Load(), Key: key})
+}
+
 // Snapshot returns a snapshot of the key-value store.
 func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
-	return &snapshot{}, nil
+	return &snapshot{data: f.cache.Snapshot(), leases: f.leases.snapshot(), index: f.index.Load()}, nil
 }
 
 // Restore restores the key-value store to a previous state.
-func (f *raftFSM) Restore(io.ReadCloser) error {
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	// Buffered so each section is decoded without reading past its end
+	r := bufio.NewReader(rc)
+	if err := f.cache.Restore(r); err != nil {
+		return fmt.Errorf("failed to restore cache: %w", err)
+	}
+	if err := f.leases.restore(r); err != nil {
+		return fmt.Errorf("failed to restore leases: %w", err)
+	}
+
+	// Snapshots written before the index was kept end with the leases
+	var index uint64
+	if err := binary.Read(r, binary.LittleEndian, &index); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to restore index: %w", err)
+	}
+	if index > 0 {
+		f.index.Store(index)
+	}
+	// Watches can't resume from the events the snapshot replaced
+	if f.broker != nil {
+		f.broker.Reset(index)
+	}
 	return nil
 }
 
 // snapshot is a structure that represents a snapshot of the key-value store.
-type snapshot struct{}
+type snapshot struct {
+	data   *cache.Snapshot
+	leases map[string]lease
+	index  uint64 // Index of the last entry applied to the snapshot
+}
 
 // Persist persists the snapshot to a sink.
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	defer sink.Close()
-	return nil
+	if _, err := s.data.WriteTo(sink); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("failed to persist snapshot: %w", err)
+	}
+	if err := writeLeases(sink, s.leases); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("failed to persist snapshot: %w", err)
+	}
+	if err := binary.Write(sink, binary.LittleEndian, s.index); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("failed to persist snapshot: %w", err)
+	}
+	return sink.Close()
 }
 
 // Release releases the snapshot.
 func (s *snapshot) Release() {}
 
-// createRaftNodeWithCluster will create raft node and cluster
-func createRaftNodeWithCluster(opts RaftServerOpts, peers []raft.Server) (*raft.Raft, error) {
-	config := raft.DefaultConfig()
-	config.LocalID = raft.ServerID(opts.ID)
-	config.ElectionTimeout = raftClusterElectionTimeout
+// createRaftNodeWithCluster will create raft node and cluster, along with
+// the stores to close once it shut down
+func createRaftNodeWithCluster(fsm *raftFSM, opts RaftServerOpts, peers []raft.Server) (raftNode *raft.Raft, stores []io.Closer, err error) {
+	tuning := opts.Raft
+	if tuning == (RaftConfig{}) {
+		tuning = DefaultRaftConfig()
+	}
+	if err := tuning.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid raft config: %w", err)
+	}
+	config, err := tuning.config(opts.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid raft config: %w", err)
+	}
+	dir, err := dataDir(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Close the stores created so far if the node cannot start
+	defer func() {
+		if err != nil {
+			for _, store := range stores {
+				store.Close()
+			}
+			stores = nil
+		}
+	}()
 
 	// Create logStore
-	logStore, err := raftboltdb.NewBoltStore(fmt.Sprintf("raft-log-%s.bolt", opts.ID))
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-log.bolt"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create log store: %v", err)
+		return nil, nil, fmt.Errorf("failed to create log store: %v", err)
 	}
+	stores = append(stores, logStore)
 
 	// Create stableStore
-	stableStore, err := raftboltdb.NewBoltStore(fmt.Sprintf("raft-stable-%s.bolt", opts.ID))
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-stable.bolt"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create stable store: %v", err)
+		return nil, stores, fmt.Errorf("failed to create stable store: %v", err)
 	}
+	stores = append(stores, stableStore)
 
-	// Create discardSnapshotStore
-	snapshotStore := raft.NewDiscardSnapshotStore()
+	// Create snapshotStore, so a restarted node does not replay the whole log
+	snapshotStore, err := raft.NewFileSnapshotStore(dir, tuning.SnapshotRetain, nil)
+	if err != nil {
+		return nil, stores, fmt.Errorf("failed to create snapshot store: %v", err)
+	}
 
 	// Convert the address to Raft's format
 	addr, err := net.ResolveTCPAddr("tcp", opts.ListenAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve address: %v", err)
+		return nil, stores, fmt.Errorf("failed to resolve address: %v", err)
 	}
 
 	// Create transporter
-	transport, err := raft.NewTCPTransport(opts.ListenAddr, addr, 3, 10*time.Second, nil)
+	transport, err := raft.NewTCPTransport(opts.ListenAddr, addr, tuning.TransportPool, tuning.TransportTimeout, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create transport: %v", err)
+		return nil, stores, fmt.Errorf("failed to create transport: %v", err)
 	}
 
 	// Construct a new Raft node
-	raftNode, err := raft.NewRaft(config, NewRaftFSM(nil), logStore, stableStore, snapshotStore, transport)
+	raftNode, err = raft.NewRaft(config, fsm, logStore, stableStore, snapshotStore, transport)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Raft: %v", err)
+		transport.Close()
+		return nil, stores, fmt.Errorf("failed to create Raft: %v", err)
 	}
 
 	// Bootstrap raft cluster on leader only
@@ -133,54 +406,112 @@ func createRaftNodeWithCluster(opts RaftServerOpts, peers []raft.Server) (*raft.
 		cfg := raft.Configuration{
 			Servers: peers,
 		}
+		// A node restarted from its data directory is already bootstrapped
 		f := raftNode.BootstrapCluster(cfg)
-		if err := f.Error(); err != nil {
-			return nil, fmt.Errorf("raft.Raft.BootstrapCluster: %v", err)
+		if err := f.Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			raftNode.Shutdown().Error()
+			return nil, stores, fmt.Errorf("raft.Raft.BootstrapCluster: %v", err)
 		}
 	}
 
-	return raftNode, nil
+	return raftNode, stores, nil
 }
 
-// Rafting will start the raft node
-func Rafting(raftFSM *raftFSM, opts RaftServerOpts) {
+// Rafting will start the raft node along with its cache and admin servers,
+// and returns it once they are serving
+func Rafting(raftFSM *raftFSM, opts RaftServerOpts) (*Node, error) {
 	// Define the cluster configuration with all nodes
-	peers := []raft.Server{
-		{ID: raft.ServerID("node1"), Address: raft.ServerAddress("127.0.0.1:8080")},
-		{ID: raft.ServerID("node2"), Address: raft.ServerAddress("127.0.0.1:8081")},
-		{ID: raft.ServerID("node3"), Address: raft.ServerAddress("127.0.0.1:8082")},
+	peers, clientAddrs := opts.Peers, opts.ClientAddrs
+	if len(peers) == 0 {
+		peers = []raft.Server{
+			{ID: raft.ServerID("node1"), Address: raft.ServerAddress("127.0.0.1:8080")},
+			{ID: raft.ServerID("node2"), Address: raft.ServerAddress("127.0.0.1:8081")},
+			{ID: raft.ServerID("node3"), Address: raft.ServerAddress("127.0.0.1:8082")},
+		}
+		clientAddrs = map[raft.ServerID]string{
+			"node1": "127.0.0.1:9080",
+			"node2": "127.0.0.1:9081",
+			"node3": "127.0.0.1:9082",
+		}
+	}
+
+	// Publish applied changes to watchers and client near caches
+	raftFSM.broker = server.NewBroker()
+	raftFSM.tracer = opts.Tracer
+
+	nodeListenHost, _, err := net.SplitHostPort(opts.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node address: %w", err)
 	}
 
 	// Create the Raft node
-	raftNode, err := createRaftNodeWithCluster(opts, peers)
+	raftNode, stores, err := createRaftNodeWithCluster(raftFSM, opts, peers)
 	if err != nil {
-		opts.Log.Fatal().Msgf("Error starting node %s: %v", opts.ID, err)
+		return nil, fmt.Errorf("error starting node %s: %w", opts.ID, err)
 	}
+	store := server.NewRaftStore(raftNode)
+	node := &Node{
+		Raft:   raftNode,
+		Store:  store,
+		log:    opts.Log,
+		stores: stores,
+		stop:   make(chan struct{}),
+
+		transferLeadership: opts.TransferLeadership,
+	}
+
+	// Expire leases that outlived their TTL while this node leads
+	go raftFSM.expireLeases(store, opts.Log, node.stop)
 
 	// Display the current leader periodically
 	go func() {
+		ticker := time.NewTicker(20 * time.Second)
+		defer ticker.Stop()
 		for {
-			time.Sleep(20 * time.Second)
+			select {
+			case <-node.stop:
+				return
+			case <-ticker.C:
+			}
 			leader := raftNode.Leader()
 			opts.Log.Info().Msgf("Current leader: %s\n", leader)
 		}
 	}()
 
 	// Start the Raft node server
-	nodeListenHost, _, err := net.SplitHostPort(opts.ListenAddr)
-	if err != nil {
-		opts.Log.Error().Msgf("Failed to parse node address: %v", err)
-		return
+	nodeServerAddr := opts.ClientAddr
+	if nodeServerAddr == "" {
+		nodeServerAddr = clientAddrs[raft.ServerID(opts.ID)]
+	}
+	if nodeServerAddr == "" {
+		nodeServerAddr = fmt.Sprintf("%s%s", nodeListenHost, nodeHTTPServer)
 	}
-	nodeServerAddr := fmt.Sprintf("%s%s", nodeListenHost, nodeHTTPServer)
 
 	serverOpts := server.ServerOpts{
+		ID:         opts.ID,
 		ListenAddr: nodeServerAddr,
 		Log:        opts.Log,
-		RaftNode:   raftNode,
+		Store:      store,
+		Cache:      raftFSM.cache,
+		Broker:     raftFSM.broker,
+		Metrics:    metrics.NewRegistry(),
+		Tracer:     opts.Tracer,
+
+		ClientAddrs: clientAddrs,
+
+		SlowLogThreshold: opts.SlowLogThreshold,
+		SlowLogSize:      opts.SlowLogSize,
+		Limits:           opts.Limits,
+
+		TLSConfig: opts.TLSConfig,
+		Password:  opts.Password,
 	}
-	server := server.NewServer(serverOpts)
-	if err := server.Start(); err != nil {
-		opts.Log.Fatal().Msgf("failed to start server : %s", err.Error())
+	node.Server = server.NewServer(serverOpts)
+
+	adminAddr := opts.AdminAddr
+	if adminAddr == "" {
+		adminAddr = fmt.Sprintf("%s%s", nodeListenHost, nodeAdminServer)
 	}
+	node.serve(adminAddr)
+	return node, nil
 }
@@ -0,0 +1,134 @@
+package rafter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/discache/trace"
+	"github.com/dhyanio/discache/transport"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplicasApplySameExpiry tests that replicas applying the same write,
+// expire and touch entries at different times end up with the same expiry
+func TestReplicasApplySameExpiry(t *testing.T) {
+	now := time.Now()
+	entries := [][]byte{
+		(&transport.CommandSet{Key: []byte("session"), Value: []byte("v")}).Bytes(),
+		(&transport.CommandIncr{Key: []byte("hits"), Delta: 1, TTL: 60, At: now.Add(time.Minute).UnixMilli()}).Bytes(),
+		(&transport.CommandExpire{Key: []byte("session"), TTL: 60000, At: now.Add(time.Minute).UnixMilli(), Sliding: true}).Bytes(),
+		(&transport.CommandTouch{Keys: [][]byte{[]byte("session")}, At: now.Add(30 * time.Second).UnixMilli()}).Bytes(),
+	}
+
+	var deadlines, counters []time.Time
+	for i := 0; i < 2; i++ {
+		c := cache.NewCache(cache.CacheOpts{Capacity: 10})
+		fsm := NewRaftFSM(c)
+		for index, data := range entries {
+			result := fsm.Apply(&raft.Log{Index: uint64(index + 1), Data: data})
+			if err, ok := result.(error); ok {
+				t.Fatal(err)
+			}
+		}
+		exp, err := c.Expiry([]byte("session"))
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, exp.Sliding)
+		deadlines = append(deadlines, time.Now().Add(exp.TTL))
+		exp, err = c.Expiry([]byte("hits"))
+		assert.Nil(t, err)
+		counters = append(counters, time.Now().Add(exp.TTL))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Both replicas expire the key 90 seconds after now, and the counter a minute after now
+	for i := range deadlines {
+		assert.WithinDuration(t, now.Add(90*time.Second), deadlines[i], 5*time.Millisecond)
+		assert.WithinDuration(t, now.Add(time.Minute), counters[i], 5*time.Millisecond)
+	}
+}
+
+// TestApplyExpirePublishesEvent tests that changing the expiry of a key
+// publishes a TTL event, and expiring it right away an expiration
+func TestApplyExpirePublishesEvent(t *testing.T) {
+	fsm := NewRaftFSM(cache.NewCache(cache.CacheOpts{Capacity: 10}))
+	fsm.broker = server.NewBroker()
+	sub, err := fsm.broker.Subscribe(0, func(transport.Event) bool { return true })
+	assert.Nil(t, err)
+
+	entries := [][]byte{
+		(&transport.CommandSet{Key: []byte("k"), Value: []byte("v")}).Bytes(),
+		(&transport.CommandExpire{Key: []byte("k"), TTL: 60000, At: time.Now().Add(time.Minute).UnixMilli()}).Bytes(),
+		(&transport.CommandExpire{Key: []byte("k")}).Bytes(),
+		(&transport.CommandExpire{Key: []byte("k"), At: time.Now().Add(-time.Second).UnixMilli()}).Bytes(),
+	}
+	for index, data := range entries {
+		fsm.Apply(&raft.Log{Index: uint64(index + 1), Data: data})
+	}
+
+	var types []transport.EventType
+	for len(sub.C) > 0 {
+		types = append(types, (<-sub.C).Type)
+	}
+	assert.Equal(t, []transport.EventType{transport.EventSet, transport.EventTTL, transport.EventTTL, transport.EventExpire}, types)
+}
+
+// TestApplyTracesEntriesWithContext tests that only entries proposed within a
+// trace are traced when applied
+func TestApplyTracesEntriesWithContext(t *testing.T) {
+	exporter := trace.NewInMemoryExporter()
+	fsm := NewRaftFSM(cache.NewCache(cache.CacheOpts{Capacity: 10}))
+	fsm.tracer = trace.NewTracer(trace.TracerOpts{Exporter: exporter})
+
+	parent := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{2}, Sampled: true}
+	set := (&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}).Bytes()
+	fsm.Apply(&raft.Log{Index: 1, Data: set})
+	fsm.Apply(&raft.Log{Index: 2, Data: set, Extensions: parent.Encode()})
+	fsm.Apply(&raft.Log{Index: 3, Data: []byte{0xff}, Extensions: parent.Encode()})
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "fsm.apply", span.Name)
+		assert.Equal(t, parent.TraceID, span.TraceID)
+		assert.Equal(t, parent.SpanID, span.Parent)
+	}
+	assert.Equal(t, "2", spans[0].Attributes["index"])
+	assert.Empty(t, spans[0].Err)
+	assert.NotEmpty(t, spans[1].Err)
+}
+
+// TestWatchResumeAfterRestore tests that watches can't resume from the events
+// a restored snapshot replaced, but can from the events applied after it
+func TestWatchResumeAfterRestore(t *testing.T) {
+	fsm := NewRaftFSM(cache.NewCache(cache.CacheOpts{Capacity: 10}))
+	for index := uint64(1); index <= 3; index++ {
+		fsm.Apply(&raft.Log{Index: index, Data: (&transport.CommandSet{Key: []byte("k"), Value: []byte("v")}).Bytes()})
+	}
+	snap, err := fsm.Snapshot()
+	assert.Nil(t, err)
+	store := raft.NewInmemSnapshotStore()
+	sink, err := store.Create(raft.SnapshotVersionMax, 3, 1, raft.Configuration{}, 0, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, snap.Persist(sink))
+
+	restored := NewRaftFSM(cache.NewCache(cache.CacheOpts{Capacity: 10}))
+	restored.broker = server.NewBroker()
+	_, rc, err := store.Open(sink.ID())
+	assert.Nil(t, err)
+	assert.Nil(t, restored.Restore(rc))
+	assert.Equal(t, uint64(3), restored.index.Load())
+
+	all := func(transport.Event) bool { return true }
+	_, err = restored.broker.Subscribe(1, all)
+	assert.ErrorIs(t, err, server.ErrCompacted)
+	_, err = restored.broker.Subscribe(3, all)
+	assert.ErrorIs(t, err, server.ErrCompacted)
+
+	sub, err := restored.broker.Subscribe(4, all)
+	assert.Nil(t, err)
+	restored.Apply(&raft.Log{Index: 4, Data: (&transport.CommandSet{Key: []byte("k"), Value: []byte("w")}).Bytes()})
+	assert.Equal(t, uint64(4), (<-sub.C).Index)
+}
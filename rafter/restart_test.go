@@ -0,0 +1,58 @@
+// boltdb trips the pointer checks the race detector enables
+//go:build !race
+
+package rafter
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNodeRestartsFromDataDir tests that a leader restarted from its data
+// directory recovers its state instead of failing to bootstrap again
+func TestNodeRestartsFromDataDir(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tuning := DefaultRaftConfig()
+	tuning.HeartbeatTimeout = 50 * time.Millisecond
+	tuning.ElectionTimeout = 50 * time.Millisecond
+	tuning.LeaderLeaseTimeout = 50 * time.Millisecond
+	opts := RaftServerOpts{ID: "node1", ListenAddr: addr, IsLeader: true, DataDir: filepath.Join(t.TempDir(), "node1"), Raft: tuning}
+	peers := []raft.Server{{ID: "node1", Address: raft.ServerAddress(addr)}}
+
+	start := func() (*raft.Raft, *cache.Cache, func()) {
+		c := cache.NewCache(cache.CacheOpts{Capacity: 10})
+		node, stores, err := createRaftNodeWithCluster(NewRaftFSM(c), opts, peers)
+		assert.Nil(t, err)
+		assert.Eventually(t, func() bool { return node.State() == raft.Leader }, 5*time.Second, 10*time.Millisecond)
+		return node, c, func() {
+			assert.Nil(t, node.Shutdown().Error())
+			for _, store := range stores {
+				store.Close()
+			}
+		}
+	}
+
+	node, _, stop := start()
+	set := &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}
+	assert.Nil(t, node.Apply(set.Bytes(), time.Second).Error())
+	assert.Nil(t, node.Snapshot().Error())
+	set.Key = []byte("baz")
+	assert.Nil(t, node.Apply(set.Bytes(), time.Second).Error())
+	stop()
+
+	// The snapshot is restored and the entries after it replayed
+	_, c, stop := start()
+	defer stop()
+	assert.Eventually(t, func() bool { return c.Has([]byte("foo")) && c.Has([]byte("baz")) }, 5*time.Second, 10*time.Millisecond)
+}
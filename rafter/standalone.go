@@ -0,0 +1,105 @@
+package rafter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/aof"
+	"github.com/dhyanio/discache/metrics"
+	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/discache/trace"
+	"github.com/dhyanio/gogger"
+)
+
+// StandaloneOpts represents the options for a single node without raft
+type StandaloneOpts struct {
+	ID         string
+	ListenAddr string // Address the cache server listens on
+	Log        *gogger.Logger
+	AdminAddr  string        // Address of the admin HTTP server, the listen host on nodeAdminServer if empty
+	Tracer     *trace.Tracer // Tracer of the commands served and applied, nil traces nothing
+
+	AOFPath string      // Append-only file writes are persisted to, kept in memory only if empty
+	AOF     aof.Options // Fsync policy and rewrite thresholds of the append-only file
+
+	SlowLogThreshold time.Duration // Commands taking at least this long are kept in the slow log, 0 disables it
+	SlowLogSize      int           // Entries kept in the slow log
+
+	Limits server.Limits // Connection, in-flight, timeout and rate limits of clients
+
+	TLSConfig *tls.Config // Serves clients over TLS, nil uses plain TCP
+	Password  string      // Password clients must AUTH with, empty requires none
+}
+
+// Standalone starts a single node applying writes directly to its cache,
+// along with its cache and admin servers, and returns it once they are
+// serving. Its state is replayed from the append-only file first, if any.
+func Standalone(fsm *raftFSM, opts StandaloneOpts) (*Node, error) {
+	fsm.broker = server.NewBroker()
+	fsm.tracer = opts.Tracer
+
+	listenHost, _, err := net.SplitHostPort(opts.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node address: %w", err)
+	}
+
+	storeOpts := server.LocalStoreOpts{ID: opts.ID, Addr: opts.ListenAddr, FSM: fsm, Log: opts.Log}
+	var stores []io.Closer
+	if opts.AOFPath != "" {
+		file, err := aof.Open(opts.AOFPath, opts.AOF)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open append-only file: %w", err)
+		}
+		storeOpts.AOF = file
+		stores = append(stores, file)
+	}
+	start := time.Now()
+	store, err := server.NewLocalStore(storeOpts)
+	if err != nil {
+		for _, s := range stores {
+			s.Close()
+		}
+		return nil, fmt.Errorf("failed to replay append-only file: %w", err)
+	}
+	if opts.AOFPath != "" {
+		opts.Log.Info().Msgf("replayed %s up to index %d in %s", opts.AOFPath, store.Stats().AppliedIndex, time.Since(start))
+	}
+
+	node := &Node{
+		Store:  store,
+		log:    opts.Log,
+		stores: stores,
+		stop:   make(chan struct{}),
+	}
+
+	// Expire leases that outlived their TTL
+	go fsm.expireLeases(store, opts.Log, node.stop)
+
+	node.Server = server.NewServer(server.ServerOpts{
+		ID:         opts.ID,
+		ListenAddr: opts.ListenAddr,
+		Log:        opts.Log,
+		Store:      store,
+		Cache:      fsm.cache,
+		Broker:     fsm.broker,
+		Metrics:    metrics.NewRegistry(),
+		Tracer:     opts.Tracer,
+
+		SlowLogThreshold: opts.SlowLogThreshold,
+		SlowLogSize:      opts.SlowLogSize,
+		Limits:           opts.Limits,
+
+		TLSConfig: opts.TLSConfig,
+		Password:  opts.Password,
+	})
+
+	adminAddr := opts.AdminAddr
+	if adminAddr == "" {
+		adminAddr = fmt.Sprintf("%s%s", listenHost, nodeAdminServer)
+	}
+	node.serve(adminAddr)
+	return node, nil
+}
@@ -0,0 +1,122 @@
+package rafter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/aof"
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/server"
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// openLocalStore replays the append-only file at path into a new cache
+func openLocalStore(t *testing.T, path string) (*server.LocalStore, *aof.AOF, *cache.Cache) {
+	file, err := aof.Open(path, aof.Options{Fsync: aof.FsyncAlways, RewriteGrowth: -1})
+	assert.Nil(t, err)
+	t.Cleanup(func() { file.Close() })
+
+	c := cache.NewCache(cache.CacheOpts{Capacity: 100})
+	store, err := server.NewLocalStore(server.LocalStoreOpts{ID: "node1", Addr: "127.0.0.1:9080", FSM: NewRaftFSM(c), AOF: file})
+	assert.Nil(t, err)
+	return store, file, c
+}
+
+func apply(t *testing.T, store server.Store, cmd interface{ Bytes() []byte }) any {
+	_, result, err := store.Apply(context.Background(), cmd.Bytes())
+	assert.Nil(t, err)
+	return result
+}
+
+// TestLocalStoreRecoversFromTornWrite tests that a standalone node restarted
+// after a crash in the middle of appending a write keeps every write before it
+func TestLocalStoreRecoversFromTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	store, file, _ := openLocalStore(t, path)
+	apply(t, store, &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")})
+	apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 2})
+	apply(t, store, &transport.CommandGet{Key: []byte("foo")})
+	size := file.Size()
+	apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 3})
+	assert.Nil(t, file.Close())
+
+	// The last record is cut in the middle
+	assert.Nil(t, os.Truncate(path, size+5))
+
+	store, _, c := openLocalStore(t, path)
+	value, err := c.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	value, err = c.Get([]byte("hits"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	// Writes continue after the last complete one
+	assert.Equal(t, uint64(2), store.Stats().AppliedIndex)
+	assert.Equal(t, int64(5), apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 3}))
+}
+
+// TestLocalStoreRewrite tests that a rewritten append-only file replays the
+// same data, tags included, and keeps the writes appended after it
+func TestLocalStoreRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	store, file, _ := openLocalStore(t, path)
+	for i := 0; i < 50; i++ {
+		apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 1})
+	}
+	apply(t, store, &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar"), Tags: [][]byte{[]byte("t")}})
+	large := file.Size()
+
+	assert.Nil(t, store.Rewrite())
+	assert.Nil(t, file.WaitRewrite())
+	assert.Less(t, file.Size(), large)
+	apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 1})
+	assert.Nil(t, file.Close())
+
+	store, _, c := openLocalStore(t, path)
+	value, err := c.Get([]byte("hits"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("51"), value)
+	assert.Equal(t, uint64(52), store.Stats().AppliedIndex)
+
+	// Tags survive the snapshot
+	assert.Equal(t, int64(1), apply(t, store, &transport.CommandInvalidate{By: transport.InvalidateByTag, Match: []byte("t")}))
+}
+
+// TestLocalStoreReplayKeepsExpiries tests that replayed writes expire when
+// they were written to, instead of their TTL starting over on restart
+func TestLocalStoreReplayKeepsExpiries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	store, file, _ := openLocalStore(t, path)
+	now := time.Now()
+	apply(t, store, &transport.CommandSet{Key: []byte("gone"), Value: []byte("v"), TTL: 1, At: now.Add(50 * time.Millisecond).UnixMilli()})
+	apply(t, store, &transport.CommandIncr{Key: []byte("hits"), Delta: 1, TTL: 60, At: now.Add(time.Minute).UnixMilli()})
+	assert.Nil(t, file.Close())
+
+	time.Sleep(100 * time.Millisecond)
+	_, _, c := openLocalStore(t, path)
+	assert.False(t, c.Has([]byte("gone")), "a key whose TTL passed stays expired")
+	exp, err := c.Expiry([]byte("hits"))
+	assert.Nil(t, err)
+	assert.WithinDuration(t, now.Add(time.Minute), time.Now().Add(exp.TTL), 5*time.Millisecond)
+}
+
+// TestLocalStoreFailedAppend tests that a write that cannot be appended is
+// neither applied nor given an index, and that reads are given none either
+func TestLocalStoreFailedAppend(t *testing.T) {
+	store, file, c := openLocalStore(t, filepath.Join(t.TempDir(), "appendonly.aof"))
+	apply(t, store, &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")})
+	index, _, err := store.Apply(context.Background(), (&transport.CommandGet{Key: []byte("foo")}).Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), index)
+
+	assert.Nil(t, file.Close())
+	_, _, err = store.Apply(context.Background(), (&transport.CommandSet{Key: []byte("baz"), Value: []byte("qux")}).Bytes())
+	assert.ErrorIs(t, err, aof.ErrClosed)
+	assert.False(t, c.Has([]byte("baz")))
+	assert.Equal(t, uint64(1), store.Stats().AppliedIndex)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+)
+
+// AdminHandler returns the admin HTTP API of the node on its own mux:
+//
+//	/debug/pprof/  runtime profiles
+//	/debug/vars    expvar variables
+//	/metrics       Prometheus metrics
+//	/hotkeys       most accessed keys
+//	/slowlog       slowest recent commands, emptied by DELETE
+//	/healthz       200 while the process serves requests
+//	/readyz        200 once a leader is known and the cache applied every committed entry
+//	/status        role, leader, peers, term and indexes of the node as JSON
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", s.Metrics.Handler())
+	mux.Handle("/hotkeys", s.HotKeysHandler())
+	mux.HandleFunc("/slowlog", s.handleSlowLog)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/status", s.handleStatus)
+	return mux
+}
+
+// handleReadyz reports whether the node can serve consistent reads
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if leader, _ := s.Store.Leader(); leader == "" {
+		http.Error(w, "no known leader", http.StatusServiceUnavailable)
+		return
+	}
+	if stats := s.Store.Stats(); stats.AppliedIndex < stats.CommitIndex {
+		http.Error(w, "applied index "+strconv.FormatUint(stats.AppliedIndex, 10)+" behind commit index "+strconv.FormatUint(stats.CommitIndex, 10), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// peerStatus is a member of the cluster reported by /status
+type peerStatus struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Voter   bool   `json:"voter"`
+}
+
+// nodeStatus is the body of /status
+type nodeStatus struct {
+	ID           string       `json:"id"`
+	State        string       `json:"state"`
+	Leader       string       `json:"leader"`
+	LeaderID     string       `json:"leader_id"`
+	Peers        []peerStatus `json:"peers"`
+	Term         uint64       `json:"term"`
+	LastIndex    uint64       `json:"last_index"`
+	CommitIndex  uint64       `json:"commit_index"`
+	AppliedIndex uint64       `json:"applied_index"`
+}
+
+// handleStatus reports the raft status of the node
+func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	servers, err := s.Store.Servers()
+	if err != nil {
+		http.Error(w, "failed to get raft configuration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	leader, leaderID := s.Store.Leader()
+	stats := s.Store.Stats()
+	status := nodeStatus{
+		ID:           s.ID,
+		State:        s.Store.State().String(),
+		Leader:       string(leader),
+		LeaderID:     string(leaderID),
+		Peers:        []peerStatus{},
+		Term:         stats.Term,
+		LastIndex:    stats.LastIndex,
+		CommitIndex:  stats.CommitIndex,
+		AppliedIndex: stats.AppliedIndex,
+	}
+	for _, srv := range servers {
+		status.Peers = append(status.Peers, peerStatus{
+			ID:      string(srv.ID),
+			Address: string(srv.Address),
+			Voter:   srv.Suffrage == raft.Voter,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		s.Log.Error().Msgf("failed to write status: %s", err.Error())
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// nopFSM is a state machine ignoring every entry
+type nopFSM struct{}
+
+func (nopFSM) Apply(*raft.Log) any                 { return nil }
+func (nopFSM) Snapshot() (raft.FSMSnapshot, error) { return nil, nil }
+func (nopFSM) Restore(io.ReadCloser) error         { return nil }
+
+// newTestRaft starts a single node cluster in memory applying entries to fsm,
+// nopFSM if nil, and waits for it to lead
+func newTestRaft(t *testing.T, fsm raft.FSM) *raft.Raft {
+	if fsm == nil {
+		fsm = nopFSM{}
+	}
+
+	config := raft.DefaultConfig()
+	config.LocalID = "node1"
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	config.LogOutput = io.Discard
+
+	store := raft.NewInmemStore()
+	addr, transport := raft.NewInmemTransport("")
+	node, err := raft.NewRaft(config, fsm, store, store, raft.NewInmemSnapshotStore(), transport)
+	assert.NoError(t, err)
+	t.Cleanup(func() { node.Shutdown().Error() })
+
+	bootstrap := raft.Configuration{Servers: []raft.Server{{ID: config.LocalID, Address: addr}}}
+	assert.NoError(t, node.BootstrapCluster(bootstrap).Error())
+	assert.Eventually(t, func() bool { return node.State() == raft.Leader }, 5*time.Second, 10*time.Millisecond)
+	return node
+}
+
+// TestAdminHandler tests the health, readiness, status, pprof, expvar and
+// metrics endpoints of the admin server
+func TestAdminHandler(t *testing.T) {
+	node := newTestRaft(t, nil)
+	s := NewServer(ServerOpts{ID: "node1", Store: NewRaftStore(node), Cache: cache.NewCache(cache.CacheOpts{})})
+	handler := s.AdminHandler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+	assert.Eventually(t, func() bool { return get("/readyz").Code == http.StatusOK }, 5*time.Second, 10*time.Millisecond)
+
+	rec := get("/status")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var status nodeStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "node1", status.ID)
+	assert.Equal(t, "Leader", status.State)
+	assert.Equal(t, "node1", status.LeaderID)
+	assert.Equal(t, []peerStatus{{ID: "node1", Address: status.Leader, Voter: true}}, status.Peers)
+	assert.NotZero(t, status.Term)
+	assert.NotZero(t, status.CommitIndex)
+
+	assert.Equal(t, http.StatusOK, get("/debug/pprof/").Code)
+	assert.Equal(t, http.StatusOK, get("/debug/vars").Code)
+	assert.Contains(t, get("/metrics").Body.String(), "discache_raft_state")
+}
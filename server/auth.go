@@ -0,0 +1,28 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// authenticate reports whether password is the password of the server
+func (s *Server) authenticate(password []byte) bool {
+	return subtle.ConstantTimeCompare(password, []byte(s.Password)) == 1
+}
+
+// handleAuthCommand handles the AUTH command and returns whether the
+// connection is authenticated. It is handled before the next command is read,
+// so commands pipelined after it see its outcome.
+func (s *Server) handleAuthCommand(conn net.Conn, cmd *transport.CommandAuth) bool {
+	status := transport.StatusOK
+	ok := s.Password == "" || s.authenticate(cmd.Password)
+	if !ok {
+		s.metrics.rejected.Inc("auth")
+		s.Log.Warn().Msgf("authentication failed: %s", conn.RemoteAddr())
+		status = transport.StatusNoAuth
+	}
+	s.writeResponse(conn, (&transport.ResponseStatus{Status: status}).Bytes())
+	return ok
+}
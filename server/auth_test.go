@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAuthRequiredBeforeCommands tests that commands are answered NOAUTH
+// until the connection sends AUTH with the right password
+func TestAuthRequiredBeforeCommands(t *testing.T) {
+	_, addr, _ := startTestServerOpts(t, ServerOpts{Store: NewRaftStore(newTestRaft(t, nil)), Password: "secret"})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	clusterInfo := func() transport.Status {
+		_, err := conn.Write((&transport.CommandClusterInfo{}).Bytes())
+		assert.Nil(t, err)
+		info, err := transport.ParseClusterInfoResponse(conn)
+		assert.Nil(t, err)
+		return info.Status
+	}
+	auth := func(password string) transport.Status {
+		_, err := conn.Write((&transport.CommandAuth{Password: []byte(password)}).Bytes())
+		assert.Nil(t, err)
+		resp, err := transport.ParseStatusResponse(conn)
+		assert.Nil(t, err)
+		return resp.Status
+	}
+
+	assert.Equal(t, transport.StatusNoAuth, clusterInfo())
+	assert.Equal(t, transport.StatusNoAuth, auth("wrong"))
+	assert.Equal(t, transport.StatusNoAuth, clusterInfo())
+	assert.Equal(t, transport.StatusOK, auth("secret"))
+	assert.Equal(t, transport.StatusOK, clusterInfo())
+}
+
+// TestServeTLS tests that a server with a TLS config serves commands over TLS
+func TestServeTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	_, addr, _ := startTestServerOpts(t, ServerOpts{
+		Store:     NewRaftStore(newTestRaft(t, nil)),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+	assert.Nil(t, err)
+	info, err := transport.ParseClusterInfoResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, info.Status)
+}
+
+// selfSignedCert creates a certificate for 127.0.0.1, which servers and
+// clients may present, and a pool trusting it
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "discache"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	parsed, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool
+}
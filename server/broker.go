@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+const (
+	// subscriberBuffer is how many events may queue for a slow subscriber
+	// before it is dropped
+	subscriberBuffer = 1024
+	// historySize is how many recent events are retained for resuming watches
+	historySize = 4096
+)
+
+// ErrCompacted is returned when a watch resumes from an index older than the retained history
+var ErrCompacted = errors.New("watch position is no longer retained")
+
+// Subscription receives the events matching its filter. Its channel is
+// closed when the subscriber is dropped for falling behind or unsubscribed.
+type Subscription struct {
+	C     chan transport.Event
+	match func(ev transport.Event) bool
+}
+
+// Broker fans out keyspace events applied by the FSM to subscribed
+// connections and keeps a bounded history of them for resuming
+type Broker struct {
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	history []transport.Event // Ring buffer of the most recent events
+	next    int               // Position in history of the next event
+	full    bool              // Whether history has wrapped around
+	// oldest is the lowest index whose events are all retained, 0 until the
+	// first event after a restore from an unknown index
+	oldest uint64
+}
+
+// NewBroker creates a new event broker
+func NewBroker() *Broker {
+	return &Broker{
+		subs:    make(map[*Subscription]struct{}),
+		history: make([]transport.Event, historySize),
+		oldest:  1,
+	}
+}
+
+// Reset discards the retained events once the state was restored from a
+// snapshot up to index, whose events are then no longer replayable. An index
+// of 0 is unknown, so only events published from now on are.
+func (b *Broker) Reset(index uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.history)
+	b.next = 0
+	b.full = false
+	b.oldest = 0
+	if index > 0 {
+		b.oldest = index + 1
+	}
+}
+
+// Publish records an event and delivers it to every matching subscriber. A
+// subscriber that can not keep up is dropped by closing its channel, so it
+// never misses an event silently.
+func (b *Broker) Publish(ev transport.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Only events of the raft log are the same on every replica to resume from
+	if !ev.Type.Local() {
+		if b.oldest == 0 {
+			b.oldest = ev.Index
+		}
+		// Events sharing the index of the one overwritten may be retained,
+		// but not all of them
+		if b.full {
+			b.oldest = max(b.oldest, b.history[b.next].Index+1)
+		}
+		b.history[b.next] = ev
+		b.next = (b.next + 1) % len(b.history)
+		if b.next == 0 {
+			b.full = true
+		}
+	}
+
+	for sub := range b.subs {
+		if !sub.match(ev) {
+			continue
+		}
+		select {
+		case sub.C <- ev:
+		default:
+			delete(b.subs, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the events accepted by match. If
+// fromIndex is non-zero the retained events starting at that raft index are
+// replayed first; ErrCompacted is returned if some of them were discarded.
+// Expirations and evictions are local to the node and never replayed.
+func (b *Broker) Subscribe(fromIndex uint64, match func(ev transport.Event) bool) (*Subscription, error) {
+	sub := &Subscription{
+		C:     make(chan transport.Event, subscriberBuffer),
+		match: match,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if fromIndex > 0 {
+		replay := b.since(fromIndex)
+		if replay == nil {
+			return nil, ErrCompacted
+		}
+		for _, ev := range replay {
+			if !match(ev) {
+				continue
+			}
+			select {
+			case sub.C <- ev:
+			default:
+				return nil, ErrCompacted
+			}
+		}
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// since returns the retained events with an index of at least fromIndex in
+// publish order, or nil if some of them were discarded or never published
+// here, having been restored from a snapshot
+func (b *Broker) since(fromIndex uint64) []transport.Event {
+	if b.oldest == 0 || fromIndex < b.oldest {
+		return nil
+	}
+
+	var ordered []transport.Event
+	if b.full {
+		ordered = append(ordered, b.history[b.next:]...)
+	}
+	ordered = append(ordered, b.history[:b.next]...)
+
+	events := []transport.Event{}
+	for _, ev := range ordered {
+		if ev.Index >= fromIndex {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
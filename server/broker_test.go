@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInvalidationsIncludeExpirationsAndEvictions tests that every kind of
+// keyspace event is streamed as an invalidation
+func TestInvalidationsIncludeExpirationsAndEvictions(t *testing.T) {
+	s, addr, _ := startTestServerOpts(t, ServerOpts{Store: NewRaftStore(newTestRaft(t, nil)), Broker: NewBroker()})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write((&transport.CommandInvalidations{}).Bytes())
+	assert.Nil(t, err)
+	resp, err := transport.ParseStatusResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+
+	keys := []string{"set", "deleted", "expired", "evicted"}
+	types := []transport.EventType{transport.EventSet, transport.EventDelete, transport.EventExpire, transport.EventEvict}
+	for i, typ := range types {
+		s.Broker.Publish(transport.Event{Type: typ, Index: 1, Key: []byte(keys[i])})
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, key := range keys {
+		inv, err := transport.ParseInvalidation(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, key, string(inv.Key))
+	}
+}
+
+// TestBrokerReplaysOnlyRaftEvents tests that expirations and evictions,
+// produced locally by each replica, are streamed but never replayed
+func TestBrokerReplaysOnlyRaftEvents(t *testing.T) {
+	b := NewBroker()
+	live, err := b.Subscribe(0, func(transport.Event) bool { return true })
+	assert.Nil(t, err)
+
+	events := []transport.Event{
+		{Type: transport.EventSet, Index: 1, Key: []byte("a")},
+		{Type: transport.EventExpire, Index: 1, Key: []byte("b")},
+		{Type: transport.EventEvict, Index: 1, Key: []byte("c")},
+		{Type: transport.EventDelete, Index: 2, Key: []byte("a")},
+	}
+	for _, ev := range events {
+		b.Publish(ev)
+	}
+	assert.Len(t, live.C, len(events))
+
+	resumed, err := b.Subscribe(1, func(transport.Event) bool { return true })
+	assert.Nil(t, err)
+	var replayed []transport.Event
+	for len(resumed.C) > 0 {
+		replayed = append(replayed, <-resumed.C)
+	}
+	assert.Equal(t, []transport.Event{events[0], events[3]}, replayed)
+}
+
+// TestBrokerCompactsOverwrittenEvents tests that once the history wrapped,
+// resuming before the oldest index whose events are all retained fails
+func TestBrokerCompactsOverwrittenEvents(t *testing.T) {
+	b := NewBroker()
+	all := func(transport.Event) bool { return true }
+	for i := 0; i < historySize+2; i++ {
+		// Two events per index, so the oldest retained one shares its index
+		b.Publish(transport.Event{Type: transport.EventSet, Index: uint64(i/2 + 1), Key: []byte("k")})
+	}
+
+	for _, fromIndex := range []uint64{1, 2} {
+		_, err := b.Subscribe(fromIndex, all)
+		assert.ErrorIs(t, err, ErrCompacted)
+	}
+	// More events are retained than a subscriber buffers, so only some replay
+	sub, err := b.Subscribe(3, func(ev transport.Event) bool { return ev.Index == 3 })
+	assert.Nil(t, err)
+	assert.Len(t, sub.C, 2)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+)
+
+// handleDataCommand handles the hash, list, set and sorted set commands.
+// Writes are replicated through raft. Reads are served from the local replica,
+// after the leader confirmed it still leads.
+func (s *Server) handleDataCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandData) {
+	if err := cmd.Validate(); err != nil {
+		s.Log.Error().Msgf("invalid %s command: %s", cmd.Op, err.Error())
+		resp := transport.ResponseData{Status: transport.StatusError}
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	if cmd.Op.Write() {
+		cmd.At = expiresAt(cmd.TTL, cmd.At)
+		parse := func(r io.Reader) (response, error) { return transport.ParseDataResponse(r) }
+		s.handleWrite(ctx, conn, cmd.Bytes(), parse, dataResponse)
+		return
+	}
+
+	if s.isLeader() {
+		if err := s.Store.VerifyLeader(); err != nil {
+			s.Log.Error().Msgf("not the leader: %v", err)
+			resp := transport.ResponseData{Status: transport.StatusError}
+			s.writeResponse(conn, resp.Bytes())
+			return
+		}
+	}
+	s.writeResponse(conn, readData(s.Cache, cmd).Bytes())
+	s.slide(cmd.Key)
+}
+
+// dataResponse builds the response of a data write from the FSM result
+func dataResponse(result any) response {
+	resp := &transport.ResponseData{Status: transport.StatusOK}
+	switch v := result.(type) {
+	case int64:
+		resp.Int = v
+	case []byte:
+		resp.Values = [][]byte{v}
+	case error:
+		resp.Status = getStatus(v)
+	}
+	return resp
+}
+
+// readData runs a data read on the cache
+func readData(c *cache.Cache, cmd *transport.CommandData) *transport.ResponseData {
+	resp := &transport.ResponseData{}
+	var err error
+	switch cmd.Op {
+	case transport.OpHGet:
+		var value []byte
+		if value, err = c.HGet(cmd.Key, cmd.Args[0]); err == nil {
+			resp.Values = [][]byte{value}
+		}
+	case transport.OpHGetAll:
+		var fields map[string][]byte
+		if fields, err = c.HGetAll(cmd.Key); err == nil {
+			names := make([]string, 0, len(fields))
+			for field := range fields {
+				names = append(names, field)
+			}
+			sort.Strings(names)
+			for _, field := range names {
+				resp.Values = append(resp.Values, []byte(field), fields[field])
+			}
+		}
+	case transport.OpLRange:
+		start, _ := strconv.Atoi(string(cmd.Args[0])) // Checked by Validate
+		stop, _ := strconv.Atoi(string(cmd.Args[1]))
+		resp.Values, err = c.LRange(cmd.Key, start, stop)
+	case transport.OpSMembers:
+		resp.Values, err = c.SMembers(cmd.Key)
+	case transport.OpSIsMember:
+		var found bool
+		if found, err = c.SIsMember(cmd.Key, cmd.Args[0]); found {
+			resp.Int = 1
+		}
+	case transport.OpZScore:
+		var score float64
+		if score, err = c.ZScore(cmd.Key, cmd.Args[0]); err == nil {
+			resp.Values = [][]byte{transport.FormatScore(score)}
+		}
+	case transport.OpZRangeByScore:
+		minScore, _ := transport.ParseScore(cmd.Args[0]) // Checked by Validate
+		maxScore, _ := transport.ParseScore(cmd.Args[1])
+		var members []cache.ZMember
+		if members, err = c.ZRangeByScore(cmd.Key, minScore, maxScore); err == nil {
+			for _, m := range members {
+				resp.Values = append(resp.Values, m.Member, transport.FormatScore(m.Score))
+			}
+		}
+	case transport.OpHLen, transport.OpLLen, transport.OpSCard, transport.OpZCard:
+		var n int
+		n, err = c.Card(cmd.Key, cardKinds[cmd.Op])
+		resp.Int = int64(n)
+	}
+	resp.Status = getStatus(err)
+	return resp
+}
+
+// cardKinds maps the length operations to the kind of collection they count
+var cardKinds = map[transport.DataOp]cache.Kind{
+	transport.OpHLen:  cache.KindHash,
+	transport.OpLLen:  cache.KindList,
+	transport.OpSCard: cache.KindSet,
+	transport.OpZCard: cache.KindZSet,
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+)
+
+// slideFraction is the fraction of its window after which a read of a sliding
+// key proposes to extend it, bounding the touches a busy key causes
+const slideFraction = 10
+
+// handleTTLCommand handles the TTL command. It is served from the local
+// replica, after the leader confirmed it still leads.
+func (s *Server) handleTTLCommand(conn net.Conn, cmd *transport.CommandTTL) {
+	resp := transport.ResponseTTL{}
+
+	if s.isLeader() {
+		if err := s.Store.VerifyLeader(); err != nil {
+			s.Log.Error().Msgf("not the leader: %v", err)
+			resp.Status = transport.StatusError
+			s.writeResponse(conn, resp.Bytes())
+			return
+		}
+	}
+
+	expiry, err := s.Cache.Expiry(cmd.Key)
+	if err != nil {
+		resp.Status = getStatus(err)
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	resp.Status = transport.StatusOK
+	resp.TTL = -1
+	if expiry.TTL != cache.NoExpiry {
+		resp.TTL = expiry.TTL.Milliseconds()
+	}
+	resp.Sliding = expiry.Sliding.Milliseconds()
+	s.writeResponse(conn, resp.Bytes())
+}
+
+// handleExpireCommand handles the EXPIRE, EXPIREAT and PERSIST commands. A
+// relative TTL is resolved to an absolute time before it is replicated.
+func (s *Server) handleExpireCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandExpire) {
+	if cmd.TTL < 0 || cmd.At < 0 || (cmd.Sliding && cmd.TTL == 0) {
+		s.Log.Error().Msgf("invalid EXPIRE of %s with TTL %d at %d", cmd.Key, cmd.TTL, cmd.At)
+		resp := transport.ResponseStatus{Status: transport.StatusError}
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+	if cmd.TTL > 0 && cmd.At == 0 {
+		cmd.At = time.Now().Add(time.Duration(cmd.TTL) * time.Millisecond).UnixMilli()
+	}
+
+	parse := func(r io.Reader) (response, error) { return transport.ParseStatusResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseStatus{Status: transport.StatusOK}
+		if err, ok := result.(error); ok {
+			resp.Status = getStatus(err)
+		}
+		return resp
+	})
+}
+
+// expiresAt resolves a TTL in seconds to the Unix time in milliseconds it
+// ends at, before the write is replicated. An at already set is kept, and no
+// TTL keeps the cache TTL of each replica.
+func expiresAt(ttl int, at int64) int64 {
+	if ttl <= 0 || at != 0 {
+		return at
+	}
+	return time.Now().Add(time.Duration(ttl) * time.Second).UnixMilli()
+}
+
+// handleTouchCommand handles the TOUCH command
+func (s *Server) handleTouchCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandTouch) {
+	if cmd.At == 0 {
+		cmd.At = time.Now().UnixMilli()
+	}
+
+	parse := func(r io.Reader) (response, error) { return transport.ParseTouchResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, touchResponse)
+}
+
+// touchResponse builds the response of a touch from the FSM result
+func touchResponse(result any) response {
+	resp := &transport.ResponseTouch{}
+	switch v := result.(type) {
+	case int64:
+		resp.Status = transport.StatusOK
+		resp.Count = v
+	case error:
+		resp.Status = getStatus(v)
+	}
+	return resp
+}
+
+// slide extends a sliding key after a read. Reads only extend the expiry
+// through a replicated touch, so every replica expires the key at the same
+// time, and only once a tenth of the window elapsed since the last extension.
+func (s *Server) slide(key []byte) {
+	if s.Cache == nil {
+		return
+	}
+	expiry, err := s.Cache.Expiry(key)
+	if err != nil || expiry.Sliding == 0 || expiry.TTL > expiry.Sliding-expiry.Sliding/slideFraction {
+		return
+	}
+	if _, inFlight := s.sliding.LoadOrStore(string(key), struct{}{}); inFlight {
+		return
+	}
+
+	go func() {
+		defer s.sliding.Delete(string(key))
+
+		ctx := context.Background()
+		cmd := &transport.CommandTouch{Keys: [][]byte{key}, At: time.Now().UnixMilli()}
+		var err error
+		if s.isLeader() {
+			_, err = s.apply(ctx, cmd.Bytes())
+		} else {
+			_, err = s.forward(ctx, cmd.Bytes(), func(r io.Reader) (response, error) { return transport.ParseTouchResponse(r) })
+		}
+		if err != nil {
+			s.Log.Warn().Msgf("failed to extend sliding key %s: %v", key, err)
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// handleHotKeysCommand handles the HOTKEYS command with the keys most
+// accessed on this node
+func (s *Server) handleHotKeysCommand(conn net.Conn, cmd *transport.CommandHotKeys) {
+	resp := transport.ResponseHotKeys{Status: transport.StatusOK, Keys: []transport.HotKey{}}
+	for _, key := range s.Cache.HotKeys(int(cmd.Count)) {
+		resp.Keys = append(resp.Keys, transport.HotKey{Key: []byte(key.Key), Count: key.Count, Error: key.Error})
+	}
+	s.writeResponse(conn, resp.Bytes())
+}
+
+// HotKeysHandler serves the keys most accessed on this node as JSON. The n
+// query parameter limits how many are returned.
+func (s *Server) HotKeysHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		if param := r.URL.Query().Get("n"); param != "" {
+			var err error
+			if n, err = strconv.Atoi(param); err != nil || n < 0 {
+				http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		type hotKey struct {
+			Key   string `json:"key"`
+			Count uint64 `json:"count"`
+			Error uint64 `json:"error"`
+		}
+		keys := []hotKey{}
+		for _, key := range s.Cache.HotKeys(n) {
+			keys = append(keys, hotKey{Key: key.Key, Count: key.Count, Error: key.Error})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(keys); err != nil {
+			s.Log.Error().Msgf("failed to write hot keys: %s", err.Error())
+		}
+	})
+}
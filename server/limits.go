@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+const (
+	// rejectTimeout bounds how long a connection over MaxConns may take to
+	// send the command it is rejected with
+	rejectTimeout = time.Second
+	// peerRefresh is how long the hosts of the cluster members are cached
+	peerRefresh = 5 * time.Second
+)
+
+// errTooManyConns is returned when a connection would exceed MaxConns
+var errTooManyConns = errors.New("too many connections")
+
+// errTooManyInFlight is returned by begin when a connection has MaxInFlight
+// commands in flight
+var errTooManyInFlight = errors.New("too many commands in flight")
+
+// Limits bounds the resources a client can use. Zero values disable a limit.
+type Limits struct {
+	// MaxConns caps the open client connections; the first command of a
+	// connection over the cap is answered with StatusBusy and it is closed
+	MaxConns int `yaml:"max_conns"`
+	// MaxInFlight caps the commands handled at once per connection; commands
+	// over it are answered with StatusBusy rather than queued
+	MaxInFlight int `yaml:"max_inflight"`
+
+	// IdleTimeout closes connections waiting longer for their next command
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// ReadTimeout bounds reading a command once its first byte arrived
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// WriteTimeout bounds writing a response or an event
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// RateLimit is the sustained commands per second allowed per client, the
+	// user of its certificate or else its IP; commands over it are answered
+	// with StatusBusy
+	RateLimit float64 `yaml:"rate_limit"`
+	// RateBurst is the number of commands a client may send at once, at least 1
+	RateBurst int `yaml:"rate_burst"`
+}
+
+// bucket is the token bucket of a client
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled
+}
+
+// rateLimiter is a token bucket per client. Buckets refill at rate tokens per
+// second up to burst and every command takes one token.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time // When full buckets were last dropped
+}
+
+// newRateLimiter creates a rate limiter, or nil if rate is 0
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of client at now and reports whether
+// there was one
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, found := l.buckets[client]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that refilled completely, which behave like new
+// ones, at most once per refill period. The limiter must be locked.
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.swept) < refill {
+		return
+	}
+	l.swept = now
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientKey is the context key of the client a command is rate limited as,
+// which forwarded commands name to the leader
+type clientKey struct{}
+
+// connClient returns the client of conn that rate limits apply to: the user
+// its verified certificate names if it authenticated with one, else its IP.
+// TLS connections must have completed their handshake.
+func connClient(conn net.Conn) string {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if chains := tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 {
+			if user := chains[0][0].Subject.CommonName; user != "" {
+				return "user:" + user
+			}
+		}
+	}
+	return clientIP(conn)
+}
+
+// clientIP returns the IP of the client of conn
+func clientIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// peerHosts caches the hosts of the members of the cluster
+type peerHosts struct {
+	mu      sync.Mutex
+	hosts   map[string]struct{}
+	fetched time.Time
+}
+
+// isPeer reports whether host is the host of a node of the cluster. The
+// members are fetched again once the cached ones are peerRefresh old.
+func (s *Server) isPeer(host string) bool {
+	p := &s.peers
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now := time.Now(); now.Sub(p.fetched) >= peerRefresh {
+		servers, err := s.Store.Servers()
+		if err != nil {
+			return false
+		}
+		p.hosts = make(map[string]struct{}, len(servers))
+		for _, server := range servers {
+			if peer, _, err := net.SplitHostPort(string(server.Address)); err == nil {
+				p.hosts[peer] = struct{}{}
+			}
+		}
+		p.fetched = now
+	}
+	_, ok := p.hosts[host]
+	return ok
+}
+
+// setDeadline sets a deadline d from now with set, or clears it if d is 0
+func setDeadline(set func(time.Time) error, d time.Duration) {
+	if d > 0 {
+		set(time.Now().Add(d))
+	} else {
+		set(time.Time{})
+	}
+}
+
+// rejectConn answers the first command of a connection over MaxConns with
+// StatusBusy
+func (s *Server) rejectConn(conn net.Conn) {
+	s.metrics.rejected.Inc("connections")
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	cmd, err := transport.ParseCommand(conn)
+	if err != nil {
+		return
+	}
+	s.writeResponse(conn, busyResponse(cmd))
+}
+
+// busyResponse returns the response of cmd with StatusBusy, so clients parse
+// it like any other response to the command
+func busyResponse(cmd any) []byte {
+	return statusResponse(cmd, transport.StatusBusy)
+}
+
+// statusResponse builds the response to cmd carrying only status, in the
+// format the client expects for that command
+func statusResponse(cmd any, status transport.Status) []byte {
+	if traced, ok := cmd.(*transport.CommandTraced); ok {
+		cmd = traced.Command
+	}
+
+	var resp response
+	switch cmd.(type) {
+	case *transport.CommandSet:
+		resp = &transport.ResponseSet{Status: status}
+	case *transport.CommandGet:
+		resp = &transport.ResponseGet{Status: status}
+	case *transport.CommandClusterInfo:
+		resp = &transport.ResponseClusterInfo{Status: status}
+	case *transport.CommandIncr:
+		resp = &transport.ResponseIncr{Status: status}
+	case *transport.CommandSetIf, *transport.CommandDeleteIf:
+		resp = &transport.ResponseVersion{Status: status}
+	case *transport.CommandTxn:
+		resp = &transport.ResponseTxn{Status: status}
+	case *transport.CommandData:
+		resp = &transport.ResponseData{Status: status}
+	case *transport.CommandLease:
+		resp = &transport.ResponseLease{Status: status}
+	case *transport.CommandInvalidate:
+		resp = &transport.ResponseInvalidate{Status: status}
+	case *transport.CommandScan:
+		resp = &transport.ResponseScan{Status: status}
+	case *transport.CommandTTL:
+		resp = &transport.ResponseTTL{Status: status}
+	case *transport.CommandTouch:
+		resp = &transport.ResponseTouch{Status: status}
+	case *transport.CommandHotKeys:
+		resp = &transport.ResponseHotKeys{Status: status}
+	case *transport.CommandSlowLog:
+		resp = &transport.ResponseSlowLog{Status: status}
+	default:
+		// Watch, invalidations, expire and auth answer with a bare status
+		resp = &transport.ResponseStatus{Status: status}
+	}
+	return resp.Bytes()
+}
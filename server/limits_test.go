@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRateLimiter tests that every client gets its own token bucket, refilled
+// over time and dropped once full
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 10))
+	assert.True(t, (*rateLimiter)(nil).allow("10.0.0.1", time.Now()))
+
+	l := newRateLimiter(10, 2)
+	now := time.Now()
+
+	// A burst is allowed, then commands are refused until tokens refill
+	assert.True(t, l.allow("10.0.0.1", now))
+	assert.True(t, l.allow("10.0.0.1", now))
+	assert.False(t, l.allow("10.0.0.1", now))
+	assert.True(t, l.allow("10.0.0.2", now))
+	assert.True(t, l.allow("10.0.0.1", now.Add(100*time.Millisecond)))
+	assert.False(t, l.allow("10.0.0.1", now.Add(100*time.Millisecond)))
+
+	// Buckets that refilled completely are dropped
+	later := now.Add(time.Second)
+	assert.True(t, l.allow("10.0.0.3", later))
+	assert.Len(t, l.buckets, 1)
+}
+
+// TestBusyResponse tests that busy responses parse as the response of the
+// command they answer, traced or not
+func TestBusyResponse(t *testing.T) {
+	resp, err := transport.ParseGetResponse(bytes.NewReader(busyResponse(&transport.CommandGet{Key: []byte("foo")})))
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusBusy, resp.Status)
+
+	traced := &transport.CommandTraced{Command: &transport.CommandIncr{Key: []byte("foo")}}
+	incr, err := transport.ParseIncrResponse(bytes.NewReader(busyResponse(traced)))
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusBusy, incr.Status)
+
+	status, err := transport.ParseStatusResponse(bytes.NewReader(busyResponse(&transport.CommandWatch{})))
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusBusy, status.Status)
+}
+
+// TestMaxInFlightAnswersBusy tests that a command over the in-flight limit
+// of its connection is answered busy while the others complete
+func TestMaxInFlightAnswersBusy(t *testing.T) {
+	fsm := blockingFSM{release: make(chan struct{})}
+	s, addr, _ := startTestServer(t, fsm, Limits{MaxInFlight: 1})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	set := &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}
+	_, err = conn.Write(set.Bytes())
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.inFlight() == 1 }, time.Second, time.Millisecond)
+
+	// The command over the limit is answered busy instead of queued
+	_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+	assert.Nil(t, err)
+	info, err := transport.ParseClusterInfoResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusBusy, info.Status)
+
+	close(fsm.release)
+	resp, err := transport.ParseSetResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+}
+
+// TestMaxConnsAnswersBusy tests that a connection over the connection limit
+// gets a busy answer and is closed
+func TestMaxConnsAnswersBusy(t *testing.T) {
+	s, addr, _ := startTestServer(t, nil, Limits{MaxConns: 1})
+
+	first, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer first.Close()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 1
+	}, time.Second, time.Millisecond)
+
+	second, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer second.Close()
+	_, err = second.Write((&transport.CommandClusterInfo{}).Bytes())
+	assert.Nil(t, err)
+	info, err := transport.ParseClusterInfoResponse(second)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusBusy, info.Status)
+	_, err = second.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// TestRateLimitAnswersBusy tests that commands over the rate limit of a
+// client are answered busy
+func TestRateLimitAnswersBusy(t *testing.T) {
+	_, addr, _ := startTestServer(t, nil, Limits{RateLimit: 0.001, RateBurst: 1})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var statuses []transport.Status
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+		assert.Nil(t, err)
+		info, err := transport.ParseClusterInfoResponse(conn)
+		assert.Nil(t, err)
+		statuses = append(statuses, info.Status)
+	}
+	assert.Equal(t, []transport.Status{transport.StatusOK, transport.StatusBusy}, statuses)
+}
+
+// TestRateLimitForwardedClients tests that commands forwarded by nodes of the
+// cluster are rate limited as the client they came from, with a password or
+// without one, and that other connections cannot claim to forward
+func TestRateLimitForwardedClients(t *testing.T) {
+	limits := Limits{RateLimit: 0.001, RateBurst: 1}
+	statuses := func(addr string, auth *transport.CommandAuth) []transport.Status {
+		conn, err := net.Dial("tcp", addr)
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write(auth.Bytes())
+		assert.Nil(t, err)
+		_, err = transport.ParseStatusResponse(conn)
+		assert.Nil(t, err)
+
+		var statuses []transport.Status
+		for i := 0; i < 2; i++ {
+			_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+			assert.Nil(t, err)
+			info, err := transport.ParseClusterInfoResponse(conn)
+			assert.Nil(t, err)
+			statuses = append(statuses, info.Status)
+		}
+		return statuses
+	}
+	newStore := func(addr string) Store {
+		store, err := NewLocalStore(LocalStoreOpts{ID: "node1", Addr: addr, FSM: nopFSM{}})
+		assert.Nil(t, err)
+		return store
+	}
+	limitedThenBusy := []transport.Status{transport.StatusOK, transport.StatusBusy}
+	busy := []transport.Status{transport.StatusBusy, transport.StatusBusy}
+
+	for _, password := range []string{"secret", ""} {
+		s, addr, _ := startTestServerOpts(t, ServerOpts{Store: newStore("127.0.0.1:9080"), Limits: limits, Password: password})
+
+		// Every forwarded client has a bucket of its own, which it keeps
+		// across the connections of the forwarding node
+		assert.Equal(t, limitedThenBusy, statuses(addr, &transport.CommandAuth{Password: []byte(password), Peer: true, Client: "10.0.0.1"}), password)
+		assert.Equal(t, limitedThenBusy, statuses(addr, &transport.CommandAuth{Password: []byte(password), Peer: true, Client: "user:alice"}), password)
+		assert.Equal(t, busy, statuses(addr, &transport.CommandAuth{Password: []byte(password), Peer: true, Client: "10.0.0.1"}), password)
+		assert.Equal(t, limitedThenBusy, statuses(addr, &transport.CommandAuth{Password: []byte(password)}), password)
+
+		s.limiter.mu.Lock()
+		assert.Contains(t, s.limiter.buckets, "10.0.0.1", password)
+		assert.Contains(t, s.limiter.buckets, "user:alice", password)
+		assert.Contains(t, s.limiter.buckets, "127.0.0.1", password)
+		s.limiter.mu.Unlock()
+	}
+
+	// A connection from outside the cluster is limited as itself whoever it
+	// claims to forward for
+	_, addr, _ := startTestServerOpts(t, ServerOpts{Store: newStore("10.0.0.2:9080"), Limits: limits})
+	assert.Equal(t, limitedThenBusy, statuses(addr, &transport.CommandAuth{Peer: true, Client: "10.0.0.3"}))
+	assert.Equal(t, busy, statuses(addr, &transport.CommandAuth{Peer: true, Client: "10.0.0.4"}))
+}
+
+// TestRateLimitKeysByCertificate tests that clients authenticated with a
+// certificate are rate limited as the user it names rather than their IP
+func TestRateLimitKeysByCertificate(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	s, addr, _ := startTestServerOpts(t, ServerOpts{
+		Store:     NewRaftStore(newTestRaft(t, nil)),
+		Limits:    Limits{RateLimit: 0.001, RateBurst: 1},
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert},
+	})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, ServerName: "127.0.0.1"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var statuses []transport.Status
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+		assert.Nil(t, err)
+		info, err := transport.ParseClusterInfoResponse(conn)
+		assert.Nil(t, err)
+		statuses = append(statuses, info.Status)
+	}
+	assert.Equal(t, []transport.Status{transport.StatusOK, transport.StatusBusy}, statuses)
+
+	s.limiter.mu.Lock()
+	defer s.limiter.mu.Unlock()
+	assert.Contains(t, s.limiter.buckets, "user:discache")
+	assert.NotContains(t, s.limiter.buckets, "127.0.0.1")
+}
+
+// TestIdleTimeoutClosesConnection tests that a connection sending no command
+// within the idle timeout is closed
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	_, addr, _ := startTestServer(t, nil, Limits{IdleTimeout: 50 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
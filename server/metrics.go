@@ -0,0 +1,140 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dhyanio/discache/metrics"
+	"github.com/dhyanio/discache/transport"
+	"github.com/hashicorp/raft"
+)
+
+// serverMetrics are the instruments updated while serving clients
+type serverMetrics struct {
+	commands    *metrics.Histogram // Latency of every command by name
+	connections *metrics.Gauge     // Open client connections
+	accepted    *metrics.Counter   // Client connections accepted
+	forwarded   *metrics.Counter   // Commands forwarded to the leader by name
+	rejected    *metrics.Counter   // Commands rejected by reason
+}
+
+// newServerMetrics registers the metrics of the server, its cache and its
+// raft node with r
+func newServerMetrics(r *metrics.Registry, s *Server) *serverMetrics {
+	m := &serverMetrics{
+		commands:    r.NewHistogram("discache_command_duration_seconds", "Time taken to serve a command.", metrics.DefaultBuckets, "command"),
+		connections: r.NewGauge("discache_connections", "Open client connections."),
+		accepted:    r.NewCounter("discache_connections_accepted_total", "Client connections accepted."),
+		forwarded:   r.NewCounter("discache_forwarded_commands_total", "Commands forwarded to the leader.", "command"),
+		rejected:    r.NewCounter("discache_rejected_commands_total", "Commands rejected because a limit was reached or the client had not authenticated, by reason.", "reason"),
+	}
+
+	if s.Cache != nil {
+		c := s.Cache
+		r.NewCounterFunc("discache_cache_hits_total", "Cache reads that found their key.", func() float64 {
+			return float64(c.Metrics().Hits)
+		})
+		r.NewCounterFunc("discache_cache_misses_total", "Cache reads that did not find their key.", func() float64 {
+			return float64(c.Metrics().Misses)
+		})
+		r.NewCounterVecFunc("discache_cache_evictions_total", "Items removed before they were deleted, by reason.", "reason", func() map[string]float64 {
+			cm := c.Metrics()
+			return map[string]float64{
+				"capacity": float64(cm.EvictedCapacity),
+				"memory":   float64(cm.EvictedMemory),
+				"expired":  float64(cm.Expired),
+			}
+		})
+		r.NewGaugeFunc("discache_cache_items", "Items in the cache, including expired items not yet removed.", func() float64 {
+			return float64(c.Metrics().Items)
+		})
+		r.NewGaugeFunc("discache_cache_bytes", "Approximate memory used by the keys and values in the cache.", func() float64 {
+			return float64(c.Metrics().Bytes)
+		})
+	}
+
+	// A single node without raft has no raft state to report
+	if store, ok := s.Store.(*RaftStore); ok {
+		node := store.raft
+		r.NewGaugeFunc("discache_raft_state", "Raft state of the node: 0 follower, 1 candidate, 2 leader, 3 shutdown.", func() float64 {
+			return float64(node.State())
+		})
+		r.NewGaugeFunc("discache_raft_leader", "Whether the node is the raft leader.", func() float64 {
+			if node.State() == raft.Leader {
+				return 1
+			}
+			return 0
+		})
+		r.NewGaugeFunc("discache_raft_term", "Current raft term.", func() float64 {
+			return raftStat(node, "term")
+		})
+		r.NewGaugeFunc("discache_raft_commit_index", "Index of the last committed raft log entry.", func() float64 {
+			return raftStat(node, "commit_index")
+		})
+		r.NewGaugeFunc("discache_raft_applied_index", "Index of the last raft log entry applied to the cache.", func() float64 {
+			return raftStat(node, "applied_index")
+		})
+		r.NewGaugeFunc("discache_raft_apply_lag", "Committed raft log entries not yet applied to the cache.", func() float64 {
+			stats := node.Stats()
+			commit, _ := strconv.ParseFloat(stats["commit_index"], 64)
+			applied, _ := strconv.ParseFloat(stats["applied_index"], 64)
+			return max(commit-applied, 0)
+		})
+	}
+	return m
+}
+
+// raftStat returns a numeric raft statistic, 0 if it is unknown
+func raftStat(node *raft.Raft, name string) float64 {
+	v, _ := strconv.ParseFloat(node.Stats()[name], 64)
+	return v
+}
+
+// observe records the latency of a command started at start
+func (m *serverMetrics) observe(command string, start time.Time) {
+	m.commands.Observe(time.Since(start).Seconds(), command)
+}
+
+// commandName returns the name of a parsed command used to label metrics
+func commandName(cmd any) string {
+	var c transport.Command
+	switch v := cmd.(type) {
+	case *transport.CommandSet:
+		c = transport.CMDSet
+	case *transport.CommandGet:
+		c = transport.CMDGet
+	case *transport.CommandClusterInfo:
+		c = transport.CMDClusterInfo
+	case *transport.CommandInvalidations:
+		c = transport.CMDInvalidations
+	case *transport.CommandWatch:
+		c = transport.CMDWatch
+	case *transport.CommandIncr:
+		c = transport.CMDIncr
+	case *transport.CommandSetIf:
+		c = transport.CMDSetIf
+	case *transport.CommandDeleteIf:
+		c = transport.CMDDeleteIf
+	case *transport.CommandTxn:
+		c = transport.CMDTxn
+	case *transport.CommandData:
+		return v.Op.String()
+	case *transport.CommandLease:
+		c = transport.CMDLease
+	case *transport.CommandInvalidate:
+		c = transport.CMDInvalidate
+	case *transport.CommandScan:
+		c = transport.CMDScan
+	case *transport.CommandTTL:
+		c = transport.CMDTTL
+	case *transport.CommandExpire:
+		c = transport.CMDExpire
+	case *transport.CommandTouch:
+		c = transport.CMDTouch
+	case *transport.CommandHotKeys:
+		c = transport.CMDHotKeys
+	case *transport.CommandSlowLog:
+		c = transport.CMDSlowLog
+	}
+	return c.String()
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/transport"
+)
+
+// handleScanCommand handles the SCAN command. Pages are served from the local
+// replica, after the leader confirmed it still leads. Since the cursor is a
+// key, a scan may continue on another node.
+func (s *Server) handleScanCommand(conn net.Conn, cmd *transport.CommandScan) {
+	resp := transport.ResponseScan{}
+
+	if s.isLeader() {
+		if err := s.Store.VerifyLeader(); err != nil {
+			s.Log.Error().Msgf("not the leader: %v", err)
+			resp.Status = transport.StatusError
+			s.writeResponse(conn, resp.Bytes())
+			return
+		}
+	}
+
+	keys, next, err := s.Cache.Scan(string(cmd.Cursor), cache.ScanOpts{
+		Prefix: string(cmd.Prefix),
+		Match:  string(cmd.Match),
+		Count:  min(int(cmd.Count), transport.MaxScanCount),
+	})
+	if err != nil {
+		s.Log.Error().Msgf("invalid SCAN match %q: %v", cmd.Match, err)
+		resp.Status = transport.StatusError
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	resp.Status = transport.StatusOK
+	resp.Cursor = []byte(next)
+	resp.Keys = make([][]byte, len(keys))
+	for i, key := range keys {
+		resp.Keys[i] = []byte(key)
+	}
+	s.writeResponse(conn, resp.Bytes())
+}
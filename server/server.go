@@ -1,48 +1,114 @@
 package server
 
 import (
-	"encoding/binary"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/dhyanio/discache/cache"
+	"github.com/dhyanio/discache/metrics"
+	"github.com/dhyanio/discache/trace"
 	"github.com/dhyanio/discache/transport"
+	"github.com/dhyanio/discache/util"
 	"github.com/dhyanio/gogger"
 	"github.com/hashicorp/raft"
 )
 
 // ServerOpts represents the options for a server
 type ServerOpts struct {
+	ID         string // Raft ID of the node
 	ListenAddr string
-	RaftNode   *raft.Raft
+	Store      Store             // Applies writes, through raft or directly on a single node
+	Cache      *cache.Cache      // Local replica used to serve reads while this node is a follower
+	Broker     *Broker           // Source of keyspace events streamed to watchers and near caches
+	Metrics    *metrics.Registry // Registry the server metrics are exposed through, nil keeps them private
+	Tracer     *trace.Tracer     // Tracer of the commands, nil traces nothing
 	Log        *gogger.Logger
+
+	// ClientAddrs are the addresses the other cluster members serve clients
+	// on by raft ID, which writes are forwarded to
+	ClientAddrs map[raft.ServerID]string
+
+	// SlowLogThreshold keeps the commands taking at least this long in the slow log, 0 disables it
+	SlowLogThreshold time.Duration
+	// SlowLogSize is the number of entries kept in the slow log before the oldest is dropped
+	SlowLogSize int
+
+	// Limits bounds the connections, commands and time clients may use
+	Limits Limits
+
+	// TLSConfig serves clients over TLS and dials the leader over TLS when
+	// forwarding, nil serves plain TCP
+	TLSConfig *tls.Config
+	// Password must be sent with AUTH before any other command, empty requires none
+	Password string
 }
 
 // Server represents a server
 type Server struct {
 	ServerOpts
+	sliding sync.Map // Sliding keys with a touch in flight
+	metrics *serverMetrics
+	slow    *slowLog     // nil when the slow log is disabled
+	limiter *rateLimiter // nil when clients are not rate limited
+	peers   peerHosts    // Hosts of the cluster members, cached for isPeer
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]*connState // Open client connections
+	closing  bool                    // Set once Shutdown was called
 }
 
 // NewServer creates a new cache server
 func NewServer(opts ServerOpts) *Server {
-	return &Server{
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.NewRegistry()
+	}
+	s := &Server{
 		ServerOpts: opts,
+		slow:       newSlowLog(opts.SlowLogSize, opts.SlowLogThreshold),
+		limiter:    newRateLimiter(opts.Limits.RateLimit, opts.Limits.RateBurst),
 	}
+	s.metrics = newServerMetrics(opts.Metrics, s)
+	return s
 }
 
-// Start starts the server
+// Start starts the server and serves clients until Shutdown is called, when
+// it returns ErrServerClosed
 func (s *Server) Start() error {
 	ln, err := net.Listen("tcp", s.ListenAddr)
 	if err != nil {
 		return fmt.Errorf("listen error: %w", err)
 	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
 
 	s.Log.Info().Msgf("server starting on port [%s]\n", s.ListenAddr)
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			s.Log.Error().Msgf("accept error: %s\n", err)
 			continue
 		}
@@ -53,124 +119,686 @@ func (s *Server) Start() error {
 // handleConn handles the incoming connection
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
+	if err := s.trackConn(conn); err != nil {
+		if err == errTooManyConns {
+			s.rejectConn(conn)
+		}
+		return
+	}
+	defer s.untrackConn(conn)
+
+	// Commands in flight are answered before the connection is closed
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+
+	s.metrics.accepted.Inc()
+	s.metrics.connections.Add(1)
+	defer s.metrics.connections.Add(-1)
 
 	s.Log.Info().Msgf("connection made: %s", conn.RemoteAddr())
 
+	// client is who the commands of the connection are rate limited as, set
+	// once TLS completed its handshake, and forwardedFor the client a peer
+	// forwards commands of
+	var client, forwardedFor string
+
+	r := &timedReader{r: conn, conn: conn, timeout: s.Limits.ReadTimeout, arrived: func() error { return s.commandArrived(conn) }}
+	authed := s.Password == ""
+	var streaming bool
 	for {
-		cmd, err := transport.ParseCommand(conn)
+		// Streams only write once subscribed, so they are never idle
+		if !streaming {
+			setDeadline(conn.SetReadDeadline, s.Limits.IdleTimeout)
+		}
+		r.first = time.Time{}
+		s.awaitCommand(conn)
+		cmd, err := transport.ParseCommand(r)
 		if err != nil {
-			if err == io.EOF {
+			// Shutdown closes idle connections while they wait for a command
+			if err == io.EOF || s.shuttingDown() {
+				break
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				s.Log.Info().Msgf("connection timed out: %s", conn.RemoteAddr())
 				break
 			}
 			s.Log.Error().Msgf("parse command error: %s", err.Error())
 			break
 		}
-		go s.handleCommand(conn, cmd)
+
+		if auth, ok := cmd.(*transport.CommandAuth); ok {
+			authed = s.handleAuthCommand(conn, auth)
+			forwardedFor = ""
+			if authed && auth.Peer && auth.Client != "" && s.isPeer(clientIP(conn)) {
+				forwardedFor = auth.Client
+			}
+			continue
+		}
+		if !authed {
+			s.metrics.rejected.Inc("auth")
+			s.writeResponse(conn, statusResponse(cmd, transport.StatusNoAuth))
+			continue
+		}
+		if client == "" {
+			client = connClient(conn)
+		}
+		limited := client
+		if forwardedFor != "" {
+			limited = forwardedFor
+		}
+		if !s.limiter.allow(limited, time.Now()) {
+			s.metrics.rejected.Inc("rate")
+			s.writeResponse(conn, busyResponse(cmd))
+			continue
+		}
+		stream, err := s.begin(conn, cmd)
+		if errors.Is(err, errTooManyInFlight) {
+			s.metrics.rejected.Inc("inflight")
+			s.writeResponse(conn, busyResponse(cmd))
+			continue
+		}
+		if err != nil {
+			break
+		}
+		streaming = streaming || stream
+		if !stream {
+			inflight.Add(1)
+		}
+		go func(cmd any, start, parsed time.Time) {
+			if !stream {
+				defer inflight.Done()
+				defer s.end(conn)
+			}
+			s.handleCommand(conn, limited, cmd, start, parsed)
+		}(cmd, r.first, time.Now())
+
+		// Answer the command read last, then close the connection
+		if s.shuttingDown() {
+			break
+		}
 	}
 
 	s.Log.Info().Msgf("connection closed: %s", conn.RemoteAddr())
 }
 
-// handleCommand handles the incoming command
-func (s *Server) handleCommand(conn net.Conn, cmd any) {
+// handleCommand handles the incoming command of client, whose first byte
+// arrived at start and which was parsed at parsed
+func (s *Server) handleCommand(conn net.Conn, client string, cmd any, start, parsed time.Time) {
+	ctx := context.WithValue(context.Background(), clientKey{}, client)
+	if traced, ok := cmd.(*transport.CommandTraced); ok {
+		ctx = trace.ContextWithRemote(ctx, traced.Context)
+		cmd = traced.Command
+	}
+
+	// Streams last as long as the connection, so their latency means nothing
+	switch cmd.(type) {
+	case *transport.CommandWatch, *transport.CommandInvalidations:
+	default:
+		name := commandName(cmd)
+		defer s.metrics.observe(name, time.Now())
+
+		stats := &commandStats{}
+		ctx = context.WithValue(ctx, statsKey{}, stats)
+		defer func() {
+			s.slow.record(SlowEntry{
+				Time:     start,
+				Client:   conn.RemoteAddr().String(),
+				Command:  name,
+				Key:      commandKey(cmd),
+				Duration: time.Since(start),
+				Apply:    stats.apply,
+			})
+		}()
+
+		var span *trace.Span
+		ctx, span = s.Tracer.StartAt(ctx, "server "+name, start)
+		span.SetAttribute("node", s.ID)
+		span.SetAttribute("role", s.Store.State().String())
+		defer span.End()
+		_, parse := s.Tracer.StartAt(ctx, "parse", start)
+		parse.EndAt(parsed)
+	}
+
 	switch v := cmd.(type) {
 	case *transport.CommandSet:
-		s.handleSetCommand(conn, v)
+		s.handleSetCommand(ctx, conn, v)
 	case *transport.CommandGet:
-		s.handleGetCommand(conn, v)
+		s.handleGetCommand(ctx, conn, v)
+	case *transport.CommandClusterInfo:
+		s.handleClusterInfoCommand(conn)
+	case *transport.CommandInvalidations:
+		s.handleInvalidationsCommand(conn)
+	case *transport.CommandWatch:
+		s.handleWatchCommand(conn, v)
+	case *transport.CommandIncr:
+		s.handleIncrCommand(ctx, conn, v)
+	case *transport.CommandSetIf:
+		s.handleSetIfCommand(ctx, conn, v)
+	case *transport.CommandDeleteIf:
+		s.handleDeleteIfCommand(ctx, conn, v)
+	case *transport.CommandTxn:
+		s.handleTxnCommand(ctx, conn, v)
+	case *transport.CommandData:
+		s.handleDataCommand(ctx, conn, v)
+	case *transport.CommandLease:
+		s.handleLeaseCommand(ctx, conn, v)
+	case *transport.CommandInvalidate:
+		s.handleInvalidateCommand(ctx, conn, v)
+	case *transport.CommandScan:
+		s.handleScanCommand(conn, v)
+	case *transport.CommandTTL:
+		s.handleTTLCommand(conn, v)
+	case *transport.CommandExpire:
+		s.handleExpireCommand(ctx, conn, v)
+	case *transport.CommandTouch:
+		s.handleTouchCommand(ctx, conn, v)
+	case *transport.CommandHotKeys:
+		s.handleHotKeysCommand(conn, v)
+	case *transport.CommandSlowLog:
+		s.handleSlowLogCommand(conn, v)
 	default:
 		s.Log.Error().Msgf("unknown command type: %T", v)
 	}
 }
 
-// handleGetCommand handles the GET command
-func (s *Server) handleGetCommand(conn net.Conn, cmd *transport.CommandGet) {
+// handleGetCommand handles the GET command. The leader reads through raft.
+// Followers forward strong reads to it and serve eventual ones from their
+// local replica without changing it, as expiry and eviction are left to the
+// entries raft applies.
+func (s *Server) handleGetCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandGet) {
 	resp := transport.ResponseGet{}
 
-	future := s.RaftNode.Apply(cmd.Bytes(), 5*time.Second)
-	if future.Error() != nil {
-		resp.Status = transport.StatusError
+	if !s.isLeader() {
+		if cmd.Strong || s.Cache == nil {
+			parse := func(r io.Reader) (response, error) { return transport.ParseGetResponse(r) }
+			if err := s.forwardToLeader(ctx, conn, cmd.Bytes(), parse); err != nil {
+				s.Log.Error().Msgf("failed to forward to leader: %s", err.Error())
+				resp.Status = transport.StatusError
+				s.writeResponse(conn, resp.Bytes())
+			}
+			return
+		}
+		item, err := s.Cache.Peek(cmd.Key)
+		resp.Status = getStatus(err)
+		resp.Value = item.Value
+		resp.Version = item.Version
 		s.writeResponse(conn, resp.Bytes())
+		s.slide(cmd.Key)
 		return
 	}
 
-	value := future.Response()
-	resp.Status = transport.StatusOK
+	result, err := s.apply(ctx, cmd.Bytes())
+	if err != nil {
+		resp.Status = transport.StatusError
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
 
-	readFuture := s.RaftNode.VerifyLeader()
-	if err := readFuture.Error(); err != nil {
+	if err := s.Store.VerifyLeader(); err != nil {
 		s.Log.Error().Msgf("not the leader: %v", err)
 		resp.Status = transport.StatusError
 		s.writeResponse(conn, resp.Bytes())
 		return
 	}
 
-	resp.Value = value.([]byte)
+	switch v := result.(type) {
+	case cache.Item:
+		resp.Status = transport.StatusOK
+		resp.Value = v.Value
+		resp.Version = v.Version
+	case error:
+		resp.Status = getStatus(v)
+	}
 	s.writeResponse(conn, resp.Bytes())
+	s.slide(cmd.Key)
 }
 
 // handleSetCommand handles the SET command
-func (s *Server) handleSetCommand(conn net.Conn, cmd *transport.CommandSet) {
-	resp := transport.ResponseSet{}
+func (s *Server) handleSetCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandSet) {
+	if s.isLeader() {
+		s.Log.Info().Msgf("SET %s to %s", cmd.Key, cmd.Value)
+	}
+	cmd.At = expiresAt(cmd.TTL, cmd.At)
+
+	parse := func(r io.Reader) (response, error) { return transport.ParseSetResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseSet{Status: transport.StatusOK}
+		if err, ok := result.(error); ok {
+			resp.Status = getStatus(err)
+		}
+		return resp
+	})
+}
+
+// handleIncrCommand handles the INCR command
+func (s *Server) handleIncrCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandIncr) {
+	cmd.At = expiresAt(cmd.TTL, cmd.At)
+	parse := func(r io.Reader) (response, error) { return transport.ParseIncrResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseIncr{}
+		switch v := result.(type) {
+		case int64:
+			resp.Status = transport.StatusOK
+			resp.Value = v
+		case error:
+			resp.Status = getStatus(v)
+		}
+		return resp
+	})
+}
+
+// handleInvalidateCommand handles the INVALIDATE-TAG and INVALIDATE-PREFIX commands
+func (s *Server) handleInvalidateCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandInvalidate) {
+	// An empty match would drop every key
+	if len(cmd.Match) == 0 {
+		s.Log.Error().Msgf("invalid invalidation with an empty match")
+		resp := transport.ResponseInvalidate{Status: transport.StatusError}
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	parse := func(r io.Reader) (response, error) { return transport.ParseInvalidateResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseInvalidate{}
+		switch v := result.(type) {
+		case int64:
+			resp.Status = transport.StatusOK
+			resp.Count = v
+		case error:
+			resp.Status = getStatus(v)
+		}
+		return resp
+	})
+}
+
+// handleSetIfCommand handles the conditional SET commands
+func (s *Server) handleSetIfCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandSetIf) {
+	cmd.At = expiresAt(cmd.TTL, cmd.At)
+	parse := func(r io.Reader) (response, error) { return transport.ParseVersionResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, versionResponse)
+}
+
+// handleDeleteIfCommand handles the DELETE-IF-VERSION command
+func (s *Server) handleDeleteIfCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandDeleteIf) {
+	parse := func(r io.Reader) (response, error) { return transport.ParseVersionResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, versionResponse)
+}
+
+// handleTxnCommand handles the TXN command
+func (s *Server) handleTxnCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandTxn) {
+	for i := range cmd.Ops {
+		cmd.Ops[i].At = expiresAt(cmd.Ops[i].TTL, cmd.Ops[i].At)
+	}
+	parse := func(r io.Reader) (response, error) { return transport.ParseTxnResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseTxn{}
+		switch v := result.(type) {
+		case uint64:
+			resp.Status = transport.StatusOK
+			resp.Version = v
+		case error:
+			resp.Status = getStatus(v)
+			var conflict *util.ConflictError
+			if errors.As(v, &conflict) {
+				resp.Version = conflict.Version
+				resp.Key = []byte(conflict.Key)
+			}
+		}
+		return resp
+	})
+}
+
+// handleLeaseCommand handles the ACQUIRE, RENEW and RELEASE lease commands
+func (s *Server) handleLeaseCommand(ctx context.Context, conn net.Conn, cmd *transport.CommandLease) {
+	// Expiries are only proposed by the leader itself
+	invalid := cmd.Op < transport.LeaseAcquire || cmd.Op >= transport.LeaseExpire ||
+		(cmd.Op != transport.LeaseRelease && cmd.TTL <= 0)
+	if invalid {
+		s.Log.Error().Msgf("invalid %s lease command with TTL %d", cmd.Op, cmd.TTL)
+		resp := transport.ResponseLease{Status: transport.StatusError}
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	parse := func(r io.Reader) (response, error) { return transport.ParseLeaseResponse(r) }
+	s.handleWrite(ctx, conn, cmd.Bytes(), parse, func(result any) response {
+		resp := &transport.ResponseLease{}
+		switch v := result.(type) {
+		case uint64:
+			resp.Status = transport.StatusOK
+			resp.Token = v
+			resp.TTL = cmd.TTL
+		case error:
+			resp.Status = getStatus(v)
+			var conflict *util.ConflictError
+			if errors.As(v, &conflict) {
+				resp.Token = conflict.Version
+			}
+		}
+		return resp
+	})
+}
+
+// versionResponse builds the response of a conditional write from the FSM result
+func versionResponse(result any) response {
+	resp := &transport.ResponseVersion{}
+	switch v := result.(type) {
+	case uint64:
+		resp.Status = transport.StatusOK
+		resp.Version = v
+	case error:
+		resp.Status = getStatus(v)
+		var conflict *util.ConflictError
+		if errors.As(v, &conflict) {
+			resp.Version = conflict.Version
+		}
+	}
+	return resp
+}
 
+// handleWrite replicates a write command. Followers forward it to the leader
+// and relay the response read with parse; the leader applies it through raft
+// and answers with the response built by respond from the FSM result.
+func (s *Server) handleWrite(ctx context.Context, conn net.Conn, cmd []byte, parse func(r io.Reader) (response, error), respond func(result any) response) {
 	// Redirect to the leader if this node is not the leader
-	if s.RaftNode.Leader() != raft.ServerAddress(s.ListenAddr) {
-		if err := s.dialLeader(cmd); err != nil {
-			s.Log.Error().Msgf("failed to dial leader: %s", err.Error())
-			resp.Status = transport.StatusError
-			s.writeResponse(conn, resp.Bytes())
-			return
+	if !s.isLeader() {
+		if err := s.forwardToLeader(ctx, conn, cmd, parse); err != nil {
+			s.Log.Error().Msgf("failed to forward to leader: %s", err.Error())
+			s.writeResponse(conn, respond(err).Bytes())
 		}
 		return
 	}
 
-	s.Log.Info().Msgf("SET %s to %s", cmd.Key, cmd.Value)
+	result, err := s.apply(ctx, cmd)
+	if err != nil {
+		s.Log.Error().Msgf("failed to apply command: %s", err.Error())
+		s.writeResponse(conn, respond(err).Bytes())
+		return
+	}
+	s.writeResponse(conn, respond(result).Bytes())
+}
+
+// handleClusterInfoCommand handles the CLUSTERINFO command
+func (s *Server) handleClusterInfoCommand(conn net.Conn) {
+	resp := transport.ResponseClusterInfo{}
 
-	future := s.RaftNode.Apply(cmd.Bytes(), 5*time.Second)
-	if future.Error() != nil {
+	servers, err := s.Store.Servers()
+	if err != nil {
+		s.Log.Error().Msgf("failed to get raft configuration: %s", err.Error())
 		resp.Status = transport.StatusError
 		s.writeResponse(conn, resp.Bytes())
 		return
 	}
 
+	for _, srv := range servers {
+		addr, err := s.clientAddr(srv.ID)
+		if err != nil {
+			s.Log.Error().Msgf("skipping cluster member %s: %s", srv.ID, err.Error())
+			continue
+		}
+		resp.Members = append(resp.Members, transport.Member{
+			ID:    string(srv.ID),
+			Addr:  addr,
+			Voter: srv.Suffrage == raft.Voter,
+		})
+	}
+
+	if leaderAddr, err := s.getLeaderAddr(); err == nil {
+		resp.Leader = leaderAddr
+	}
+
 	resp.Status = transport.StatusOK
 	s.writeResponse(conn, resp.Bytes())
 }
 
+// handleInvalidationsCommand handles the INVALIDATIONS command. The connection
+// is dedicated to the stream from then on and is closed when the stream ends.
+// Every keyspace event invalidates its key, expirations and evictions
+// included, so near caches never keep a value the node dropped.
+func (s *Server) handleInvalidationsCommand(conn net.Conn) {
+	match := func(transport.Event) bool { return true }
+	s.streamEvents(conn, 0, match, func(ev transport.Event) []byte {
+		inv := transport.Invalidation{Key: ev.Key}
+		return inv.Bytes()
+	})
+}
+
+// handleWatchCommand handles the WATCH command. The connection is dedicated to
+// the stream from then on and is closed when the stream ends.
+func (s *Server) handleWatchCommand(conn net.Conn, cmd *transport.CommandWatch) {
+	match := func(ev transport.Event) bool {
+		if cmd.Prefix {
+			return bytes.HasPrefix(ev.Key, cmd.Key)
+		}
+		return bytes.Equal(ev.Key, cmd.Key)
+	}
+	s.streamEvents(conn, cmd.FromIndex, match, func(ev transport.Event) []byte {
+		return ev.Bytes()
+	})
+}
+
+// streamEvents acknowledges a subscription and writes the matching events to
+// conn, encoded by frame, until the connection fails or the subscriber falls
+// behind. The connection is closed when the stream ends.
+func (s *Server) streamEvents(conn net.Conn, fromIndex uint64, match func(transport.Event) bool, frame func(transport.Event) []byte) {
+	resp := transport.ResponseStatus{}
+	if s.Broker == nil {
+		resp.Status = transport.StatusError
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+
+	sub, err := s.Broker.Subscribe(fromIndex, match)
+	if err != nil {
+		resp.Status = transport.StatusCompacted
+		s.writeResponse(conn, resp.Bytes())
+		return
+	}
+	defer s.Broker.Unsubscribe(sub)
+	defer conn.Close()
+
+	resp.Status = transport.StatusOK
+	if err := s.write(conn, resp.Bytes()); err != nil {
+		return
+	}
+
+	for ev := range sub.C {
+		if err := s.write(conn, frame(ev)); err != nil {
+			return
+		}
+	}
+	s.Log.Warn().Msgf("event subscriber %s fell behind and was dropped", conn.RemoteAddr())
+}
+
 // writeResponse writes the response to the connection
 func (s *Server) writeResponse(conn net.Conn, data []byte) {
-	if _, err := conn.Write(data); err != nil {
+	if err := s.write(conn, data); err != nil {
 		s.Log.Error().Msgf("failed to write response: %s", err.Error())
 	}
 }
 
-// dialLeader dials the leader
-func (s *Server) dialLeader(cmd *transport.CommandSet) error {
-	leaderAddr, err := s.getLeaderAddr()
+// write writes data to the connection within the write timeout
+func (s *Server) write(conn net.Conn, data []byte) error {
+	if s.Limits.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.Limits.WriteTimeout))
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+// timedReader records when the first byte of a command arrived, so time spent
+// waiting for the client is not counted as parsing, and reports it to arrived,
+// whose error fails the read. The rest of the command must then arrive within
+// timeout, if set.
+type timedReader struct {
+	r       io.Reader
+	first   time.Time
+	conn    net.Conn
+	timeout time.Duration
+	arrived func() error
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && t.first.IsZero() {
+		if t.arrived != nil {
+			if err := t.arrived(); err != nil {
+				return 0, err
+			}
+		}
+		t.first = time.Now()
+		if t.timeout > 0 {
+			t.conn.SetReadDeadline(t.first.Add(t.timeout))
+		}
+	}
+	return n, err
+}
+
+// response is a reply that can be relayed back to a client
+type response interface {
+	Bytes() []byte
+}
+
+// forwardToLeader sends a command to the leader and relays its response,
+// read with parse, back to the client
+func (s *Server) forwardToLeader(ctx context.Context, conn net.Conn, cmd []byte, parse func(r io.Reader) (response, error)) error {
+	resp, err := s.forward(ctx, cmd, parse)
 	if err != nil {
 		return err
 	}
+	s.writeResponse(conn, resp.Bytes())
+	return nil
+}
 
-	conn, err := net.Dial("tcp", leaderAddr)
+// forward sends a command to the leader and returns its response read with parse
+func (s *Server) forward(ctx context.Context, cmd []byte, parse func(r io.Reader) (response, error)) (resp response, err error) {
+	if len(cmd) > 0 {
+		s.metrics.forwarded.Inc(transport.Command(cmd[0]).String())
+	}
+
+	ctx, span := s.Tracer.Start(ctx, "forward")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	// A node that just lost leadership may still see itself as the leader
+	if _, id := s.Store.Leader(); id == raft.ServerID(s.ID) {
+		return nil, errors.New("no known leader")
+	}
+	leaderAddr, err := s.getLeaderAddr()
 	if err != nil {
-		return fmt.Errorf("failed to dial leader [%s]: %w", leaderAddr, err)
+		return nil, err
 	}
+	span.SetAttribute("leader", leaderAddr)
 
-	s.Log.Info().Msgf("connected to leader: %s", leaderAddr)
+	leaderConn, err := s.dialLeader(leaderAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial leader [%s]: %w", leaderAddr, err)
+	}
+	defer leaderConn.Close()
 
-	if err := binary.Write(conn, binary.LittleEndian, cmd.Bytes()); err != nil {
-		return fmt.Errorf("failed to write command to leader: %w", err)
+	// Every node of a cluster shares the password, and the leader rate limits
+	// the client the command came from rather than this node
+	client, _ := ctx.Value(clientKey{}).(string)
+	auth := (&transport.CommandAuth{Password: []byte(s.Password), Peer: true, Client: client}).Bytes()
+	if _, err := leaderConn.Write(append(auth, transport.WithTrace(trace.SpanContextFromContext(ctx), cmd)...)); err != nil {
+		return nil, fmt.Errorf("failed to write command to leader: %w", err)
+	}
+	status, err := transport.ParseStatusResponse(leaderConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with leader: %w", err)
+	}
+	if status.Status != transport.StatusOK {
+		return nil, fmt.Errorf("leader rejected authentication: %s", status.Status)
 	}
 
-	s.handleConn(conn)
-	return nil
+	resp, err = parse(leaderConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from leader: %w", err)
+	}
+	return resp, nil
 }
 
-// getLeaderAddr returns the leader address
-func (s *Server) getLeaderAddr() (string, error) {
-	leaderHost, leaderPort, err := net.SplitHostPort(string(s.RaftNode.Leader()))
+// dialLeader connects to the client address of the leader, over TLS if the
+// server serves TLS
+func (s *Server) dialLeader(addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if s.TLSConfig == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	config := s.TLSConfig.Clone()
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		config.ServerName = host
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, config)
+}
+
+// apply applies a command through the store and returns the FSM result
+func (s *Server) apply(ctx context.Context, cmd []byte) (any, error) {
+	ctx, span := s.Tracer.Start(ctx, "apply")
+	defer span.End()
+	defer addApply(ctx, time.Now())
+
+	index, result, err := s.Store.Apply(ctx, cmd)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse leader address: %w", err)
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("index", strconv.FormatUint(index, 10))
+	return result, nil
+}
+
+// isLeader reports whether this node is the raft leader
+func (s *Server) isLeader() bool {
+	return s.Store.State() == raft.Leader
+}
+
+// getLeaderAddr returns the client address of the leader
+func (s *Server) getLeaderAddr() (string, error) {
+	_, id := s.Store.Leader()
+	if id == "" {
+		return "", errors.New("no known leader")
+	}
+	return s.clientAddr(id)
+}
+
+// clientAddr returns the address the cluster member id serves clients on
+func (s *Server) clientAddr(id raft.ServerID) (string, error) {
+	if addr, ok := s.ClientAddrs[id]; ok {
+		return addr, nil
+	}
+	if id == raft.ServerID(s.ID) {
+		return s.ListenAddr, nil
+	}
+	return "", fmt.Errorf("no client address known for %s", id)
+}
+
+// getStatus maps a cache error to a response status
+func getStatus(err error) transport.Status {
+	var expired *util.ExpiredKeyError
+	var notFound *util.KeyNotFoundError
+	var notInteger *util.NotIntegerError
+	var overflow *util.OverflowError
+	var conflict *util.ConflictError
+	var wrongType *util.WrongTypeError
+	switch {
+	case err == nil:
+		return transport.StatusOK
+	case errors.As(err, &expired):
+		return transport.StatusExpired
+	case errors.As(err, &notFound):
+		return transport.StatusKeyNotFound
+	case errors.As(err, &notInteger):
+		return transport.StatusNotInteger
+	case errors.As(err, &overflow):
+		return transport.StatusOverflow
+	case errors.As(err, &conflict):
+		return transport.StatusConflict
+	case errors.As(err, &wrongType):
+		return transport.StatusWrongType
+	default:
+		return transport.StatusError
 	}
-	return fmt.Sprintf("%s:%s", leaderHost, leaderPort), nil
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCluster creates a raft cluster of node1, its leader, and node2 over
+// an in-memory transport
+func newTestCluster(t *testing.T) (leader, follower *raft.Raft) {
+	nodes := make([]*raft.Raft, 2)
+	transports := make([]*raft.InmemTransport, 2)
+	for i := range nodes {
+		config := raft.DefaultConfig()
+		config.LocalID = raft.ServerID([]string{"node1", "node2"}[i])
+		config.HeartbeatTimeout = 50 * time.Millisecond
+		config.ElectionTimeout = 50 * time.Millisecond
+		config.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.CommitTimeout = 5 * time.Millisecond
+		config.LogOutput = io.Discard
+
+		store := raft.NewInmemStore()
+		_, transports[i] = raft.NewInmemTransport("")
+		node, err := raft.NewRaft(config, nopFSM{}, store, store, raft.NewInmemSnapshotStore(), transports[i])
+		assert.NoError(t, err)
+		t.Cleanup(func() { node.Shutdown().Error() })
+		nodes[i] = node
+	}
+	transports[0].Connect(transports[1].LocalAddr(), transports[1])
+	transports[1].Connect(transports[0].LocalAddr(), transports[0])
+
+	bootstrap := raft.Configuration{Servers: []raft.Server{{ID: "node1", Address: transports[0].LocalAddr()}}}
+	assert.NoError(t, nodes[0].BootstrapCluster(bootstrap).Error())
+	assert.Eventually(t, func() bool { return nodes[0].State() == raft.Leader }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, nodes[0].AddVoter("node2", transports[1].LocalAddr(), 0, time.Second).Error())
+	assert.Eventually(t, func() bool {
+		_, id := nodes[1].LeaderWithID()
+		return id == "node1"
+	}, 5*time.Second, 10*time.Millisecond)
+	return nodes[0], nodes[1]
+}
+
+// TestForwardToLeaderClientAddr tests that a follower forwards writes to the
+// client address of the leader, which serves on another port than the
+// follower, and reports it as the leader
+func TestForwardToLeaderClientAddr(t *testing.T) {
+	leader, follower := newTestCluster(t)
+	_, leaderAddr, _ := startTestServerOpts(t, ServerOpts{ID: "node1", Store: NewRaftStore(leader)})
+	_, followerAddr, _ := startTestServerOpts(t, ServerOpts{
+		ID:          "node2",
+		Store:       NewRaftStore(follower),
+		ClientAddrs: map[raft.ServerID]string{"node1": leaderAddr},
+	})
+
+	applied := leader.AppliedIndex()
+	conn, err := net.Dial("tcp", followerAddr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write((&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}).Bytes())
+	assert.Nil(t, err)
+	resp, err := transport.ParseSetResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+	assert.Greater(t, leader.AppliedIndex(), applied)
+
+	_, err = conn.Write((&transport.CommandClusterInfo{}).Bytes())
+	assert.Nil(t, err)
+	info, err := transport.ParseClusterInfoResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, leaderAddr, info.Leader)
+	assert.Contains(t, info.Members, transport.Member{ID: "node1", Addr: leaderAddr, Voter: true})
+	assert.Contains(t, info.Members, transport.Member{ID: "node2", Addr: "127.0.0.1:0", Voter: true})
+}
+
+// TestForwardedCommandsRateLimited tests that the leader rate limits the
+// commands a follower forwards and that the follower relays its busy answers
+func TestForwardedCommandsRateLimited(t *testing.T) {
+	leader, follower := newTestCluster(t)
+	_, leaderAddr, _ := startTestServerOpts(t, ServerOpts{
+		ID:     "node1",
+		Store:  NewRaftStore(leader),
+		Limits: Limits{RateLimit: 0.001, RateBurst: 1},
+	})
+	_, followerAddr, _ := startTestServerOpts(t, ServerOpts{
+		ID:          "node2",
+		Store:       NewRaftStore(follower),
+		ClientAddrs: map[raft.ServerID]string{"node1": leaderAddr},
+	})
+
+	conn, err := net.Dial("tcp", followerAddr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	var statuses []transport.Status
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write((&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}).Bytes())
+		assert.Nil(t, err)
+		resp, err := transport.ParseSetResponse(conn)
+		assert.Nil(t, err)
+		statuses = append(statuses, resp.Status)
+	}
+	assert.Equal(t, []transport.Status{transport.StatusOK, transport.StatusBusy}, statuses)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+// ErrServerClosed is returned by Start once Shutdown was called
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is how often Shutdown checks whether connections drained
+const shutdownPollInterval = 10 * time.Millisecond
+
+// connState tracks the commands in flight on a connection
+type connState struct {
+	active  int  // Commands read and not yet answered, streams excluded as they never end
+	reading bool // The first byte of the next command arrived
+}
+
+// trackConn registers a new connection. It fails if the server is shutting
+// down or already has MaxConns connections.
+func (s *Server) trackConn(conn net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return ErrServerClosed
+	}
+	if s.Limits.MaxConns > 0 && len(s.conns) >= s.Limits.MaxConns {
+		return errTooManyConns
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]*connState)
+	}
+	s.conns[conn] = &connState{}
+	return nil
+}
+
+// untrackConn forgets a connection that closed
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// awaitCommand records that conn waits for its next command, so Shutdown
+// may close it once its commands in flight were answered
+func (s *Server) awaitCommand(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, found := s.conns[conn]; found {
+		state.reading = false
+	}
+}
+
+// commandArrived records that the first byte of a command arrived on conn, so
+// Shutdown waits for the command to be answered. It fails if Shutdown already
+// closed conn.
+func (s *Server) commandArrived(conn net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, found := s.conns[conn]
+	if !found {
+		return ErrServerClosed
+	}
+	state.reading = true
+	return nil
+}
+
+// begin records that a command read from conn is being handled and reports
+// whether it starts a stream. It fails with errTooManyInFlight if conn
+// already has MaxInFlight commands in flight, or ErrServerClosed if Shutdown
+// closed conn.
+func (s *Server) begin(conn net.Conn, cmd any) (stream bool, err error) {
+	if traced, ok := cmd.(*transport.CommandTraced); ok {
+		cmd = traced.Command
+	}
+	switch cmd.(type) {
+	case *transport.CommandWatch, *transport.CommandInvalidations:
+		stream = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, found := s.conns[conn]
+	if !found {
+		return stream, ErrServerClosed
+	}
+	if stream {
+		return stream, nil
+	}
+	if s.Limits.MaxInFlight > 0 && state.active >= s.Limits.MaxInFlight {
+		return stream, errTooManyInFlight
+	}
+	state.active++
+	return stream, nil
+}
+
+// end records that a command begun on conn was answered
+func (s *Server) end(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, found := s.conns[conn]; found {
+		state.active--
+	}
+}
+
+// shuttingDown reports whether Shutdown was called
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// Shutdown stops the server gracefully. It stops accepting connections,
+// closes the idle ones, and waits for the commands in flight, including those
+// still being read, to be answered before closing their connections and the
+// event streams on them. If ctx ends first
+// the remaining connections are closed and its error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	ln := s.listener
+	s.mu.Unlock()
+
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeIdle(false) {
+		select {
+		case <-ctx.Done():
+			s.closeIdle(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return err
+}
+
+// closeIdle closes the connections with no command in flight or being read,
+// or every one if all is set, and reports whether every connection is
+// closed. Streams do not keep their connection open as they never end.
+func (s *Server) closeIdle(all bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drained := true
+	for conn, state := range s.conns {
+		if all || (state.active == 0 && !state.reading) {
+			conn.Close()
+			delete(s.conns, conn)
+			continue
+		}
+		drained = false
+	}
+	return drained
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/dhyanio/gogger"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingFSM is a state machine blocking every entry until release is closed
+type blockingFSM struct {
+	nopFSM
+	release chan struct{}
+}
+
+func (f blockingFSM) Apply(*raft.Log) any {
+	<-f.release
+	return nil
+}
+
+// startTestServer starts a server with limits on a random local port applying
+// entries to fsm and returns its address along with the error Start returned
+func startTestServer(t *testing.T, fsm raft.FSM, limits Limits) (*Server, string, <-chan error) {
+	return startTestServerOpts(t, ServerOpts{Store: NewRaftStore(newTestRaft(t, fsm)), Limits: limits})
+}
+
+// startTestServerOpts starts a server with opts on a free port of localhost
+func startTestServerOpts(t *testing.T, opts ServerOpts) (*Server, string, <-chan error) {
+	log, err := gogger.NewLogger(filepath.Join(t.TempDir(), "server.log"), gogger.INFO)
+	assert.Nil(t, err)
+	if opts.ID == "" {
+		opts.ID = "node1"
+	}
+	opts.ListenAddr, opts.Log = "127.0.0.1:0", log
+	s := NewServer(opts)
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+
+	var addr string
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.listener == nil {
+			return false
+		}
+		addr = s.listener.Addr().String()
+		return true
+	}, time.Second, time.Millisecond)
+	return s, addr, started
+}
+
+// inFlight returns the number of commands read and not yet answered
+func (s *Server) inFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, state := range s.conns {
+		n += state.active
+	}
+	return n
+}
+
+// TestShutdownDrainsCommands tests that Shutdown refuses new connections,
+// closes idle ones and waits for the command in flight to be answered
+func TestShutdownDrainsCommands(t *testing.T) {
+	fsm := blockingFSM{release: make(chan struct{})}
+	s, addr, started := startTestServer(t, fsm, Limits{})
+
+	idle, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer idle.Close()
+	busy, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer busy.Close()
+
+	set := &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}
+	_, err = busy.Write(set.Bytes())
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.inFlight() == 1 }, time.Second, time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	// New and idle connections are refused and closed right away
+	assert.ErrorIs(t, <-started, ErrServerClosed)
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	select {
+	case <-done:
+		t.Fatal("shutdown returned with a command in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The command in flight is answered before its connection is closed
+	close(fsm.release)
+	resp, err := transport.ParseSetResponse(busy)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+	assert.Nil(t, <-done)
+	_, err = busy.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// TestShutdownDropsConnectionsWhenContextEnds tests that Shutdown closes
+// connections with commands in flight once its context is done
+func TestShutdownDropsConnectionsWhenContextEnds(t *testing.T) {
+	fsm := blockingFSM{release: make(chan struct{})}
+	defer close(fsm.release)
+	s, addr, _ := startTestServer(t, fsm, Limits{})
+
+	busy, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer busy.Close()
+	set := &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}
+	_, err = busy.Write(set.Bytes())
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.inFlight() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = busy.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+}
+
+// TestShutdownWaitsForCommandBeingRead tests that a connection whose command
+// started to arrive is not closed as idle, and that the command is answered
+func TestShutdownWaitsForCommandBeingRead(t *testing.T) {
+	s, addr, _ := startTestServer(t, nil, Limits{})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	set := (&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}).Bytes()
+	_, err = conn.Write(set[:1])
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, state := range s.conns {
+			return state.reading
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	time.Sleep(5 * shutdownPollInterval)
+
+	_, err = conn.Write(set[1:])
+	assert.Nil(t, err)
+	resp, err := transport.ParseSetResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+	assert.Nil(t, <-done)
+}
+
+// TestShutdownAnswersCommandsBesideStream tests that a connection streaming
+// events is kept open until its other commands in flight are answered
+func TestShutdownAnswersCommandsBesideStream(t *testing.T) {
+	fsm := blockingFSM{release: make(chan struct{})}
+	s, addr, _ := startTestServerOpts(t, ServerOpts{Store: NewRaftStore(newTestRaft(t, fsm)), Broker: NewBroker()})
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write((&transport.CommandWatch{Key: []byte("other")}).Bytes())
+	assert.Nil(t, err)
+	status, err := transport.ParseStatusResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, status.Status)
+
+	_, err = conn.Write((&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}).Bytes())
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return s.inFlight() == 1 }, time.Second, time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("shutdown returned with a command in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fsm.release)
+	resp, err := transport.ParseSetResponse(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+	assert.Nil(t, <-done)
+}
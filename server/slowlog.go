@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+)
+
+const (
+	defaultSlowLogSize = 128
+	maxSlowLogKey      = 128 // Bytes of a key kept in the slow log
+)
+
+// SlowEntry is a command that took at least the slow log threshold
+type SlowEntry struct {
+	ID       uint64 // Increases with every entry recorded by the node
+	Time     time.Time
+	Client   string
+	Command  string
+	Key      []byte // First bytes of the key the command addressed, if any
+	Duration time.Duration
+	Apply    time.Duration // Part of Duration spent replicating through raft
+}
+
+// slowLog keeps the most recent slow commands in a ring buffer
+type slowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []SlowEntry
+	next      int    // Position the next entry is written to
+	id        uint64 // ID of the last entry
+}
+
+// newSlowLog creates a slow log of size entries recording the commands that
+// take at least threshold, or nil if threshold is 0
+func newSlowLog(size int, threshold time.Duration) *slowLog {
+	if threshold <= 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = defaultSlowLogSize
+	}
+	return &slowLog{threshold: threshold, entries: make([]SlowEntry, 0, size)}
+}
+
+// record adds e if it took at least the threshold, dropping the oldest entry
+// once the log is full
+func (l *slowLog) record(e SlowEntry) {
+	if l == nil || e.Duration < l.threshold {
+		return
+	}
+	if len(e.Key) > maxSlowLogKey {
+		e.Key = e.Key[:maxSlowLogKey]
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.id++
+	e.ID = l.id
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, e)
+	} else {
+		l.entries[l.next] = e
+	}
+	l.next = (l.next + 1) % cap(l.entries)
+}
+
+// get returns up to n of the most recent entries, most recent first, every
+// entry when n is 0
+func (l *slowLog) get(n int) []SlowEntry {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n <= 0 || n > len(l.entries) {
+		n = len(l.entries)
+	}
+	entries := make([]SlowEntry, n)
+	for i := range entries {
+		entries[i] = l.entries[(l.next-1-i+len(l.entries))%len(l.entries)]
+	}
+	return entries
+}
+
+// reset drops every entry
+func (l *slowLog) reset() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.next = 0
+}
+
+// SlowLog returns up to n of the slowest recent commands of this node, most
+// recent first, every entry when n is 0. It returns nil unless
+// ServerOpts.SlowLogThreshold is set.
+func (s *Server) SlowLog(n int) []SlowEntry {
+	return s.slow.get(n)
+}
+
+// ResetSlowLog drops the entries of the slow log
+func (s *Server) ResetSlowLog() {
+	s.slow.reset()
+}
+
+// commandStats accumulates the time a command spends in steps of interest
+type commandStats struct {
+	apply time.Duration
+}
+
+// statsKey is the context key of the stats of the command being handled
+type statsKey struct{}
+
+// addApply adds the time since start to the raft apply time of the command of ctx
+func addApply(ctx context.Context, start time.Time) {
+	if stats, ok := ctx.Value(statsKey{}).(*commandStats); ok {
+		stats.apply += time.Since(start)
+	}
+}
+
+// commandKey returns the key a command addresses, nil if it has none
+func commandKey(cmd any) []byte {
+	switch v := cmd.(type) {
+	case *transport.CommandSet:
+		return v.Key
+	case *transport.CommandGet:
+		return v.Key
+	case *transport.CommandIncr:
+		return v.Key
+	case *transport.CommandSetIf:
+		return v.Key
+	case *transport.CommandDeleteIf:
+		return v.Key
+	case *transport.CommandData:
+		return v.Key
+	case *transport.CommandLease:
+		return v.Name
+	case *transport.CommandInvalidate:
+		return v.Match
+	case *transport.CommandScan:
+		return v.Prefix
+	case *transport.CommandTTL:
+		return v.Key
+	case *transport.CommandExpire:
+		return v.Key
+	case *transport.CommandTouch:
+		if len(v.Keys) > 0 {
+			return v.Keys[0]
+		}
+	case *transport.CommandTxn:
+		if len(v.Ops) > 0 {
+			return v.Ops[0].Key
+		}
+	}
+	return nil
+}
+
+// handleSlowLogCommand handles the SLOWLOG GET and SLOWLOG RESET commands on
+// the slow log of this node
+func (s *Server) handleSlowLogCommand(conn net.Conn, cmd *transport.CommandSlowLog) {
+	resp := transport.ResponseSlowLog{Status: transport.StatusOK, Entries: []transport.SlowLogEntry{}}
+	switch cmd.Op {
+	case transport.SlowLogGet:
+		for _, e := range s.SlowLog(int(cmd.Count)) {
+			resp.Entries = append(resp.Entries, transport.SlowLogEntry{
+				ID:       e.ID,
+				Time:     e.Time.UnixMicro(),
+				Duration: e.Duration.Microseconds(),
+				Apply:    e.Apply.Microseconds(),
+				Client:   e.Client,
+				Command:  e.Command,
+				Key:      e.Key,
+			})
+		}
+	case transport.SlowLogReset:
+		s.ResetSlowLog()
+	default:
+		s.Log.Error().Msgf("invalid %s slow log command", cmd.Op)
+		resp.Status = transport.StatusError
+	}
+	s.writeResponse(conn, resp.Bytes())
+}
+
+// handleSlowLog serves the slow log of this node as JSON, limited to the n
+// query parameter, and empties it on DELETE
+func (s *Server) handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.ResetSlowLog()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	n := 0
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n < 0 {
+			http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	type slowEntry struct {
+		ID         uint64    `json:"id"`
+		Time       time.Time `json:"time"`
+		Client     string    `json:"client"`
+		Command    string    `json:"command"`
+		Key        string    `json:"key"`
+		DurationUs int64     `json:"duration_us"`
+		ApplyUs    int64     `json:"apply_us"`
+	}
+	entries := []slowEntry{}
+	for _, e := range s.SlowLog(n) {
+		entries = append(entries, slowEntry{
+			ID:         e.ID,
+			Time:       e.Time,
+			Client:     e.Client,
+			Command:    e.Command,
+			Key:        string(e.Key),
+			DurationUs: e.Duration.Microseconds(),
+			ApplyUs:    e.Apply.Microseconds(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		s.Log.Error().Msgf("failed to write slow log: %s", err.Error())
+	}
+}
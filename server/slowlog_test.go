@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlowLogKeepsMostRecent tests that the slow log keeps the most recent
+// entries over the threshold, truncates keys and can be reset
+func TestSlowLogKeepsMostRecent(t *testing.T) {
+	l := newSlowLog(3, 10*time.Millisecond)
+	for i, d := range []time.Duration{5, 10, 20, 30, 40} {
+		l.record(SlowEntry{Command: "SET", Key: []byte{byte('a' + i)}, Duration: d * time.Millisecond})
+	}
+
+	entries := l.get(0)
+	assert.Len(t, entries, 3)
+	for i, want := range []string{"e", "d", "c"} {
+		assert.Equal(t, want, string(entries[i].Key))
+	}
+	assert.Equal(t, uint64(4), entries[0].ID)
+	assert.Len(t, l.get(2), 2)
+
+	l.record(SlowEntry{Key: []byte(strings.Repeat("k", 1000)), Duration: time.Second})
+	assert.Len(t, l.get(1)[0].Key, maxSlowLogKey)
+
+	l.reset()
+	assert.Empty(t, l.get(0))
+	l.record(SlowEntry{Key: []byte("f"), Duration: time.Second})
+	assert.Equal(t, "f", string(l.get(0)[0].Key))
+
+	var disabled *slowLog
+	disabled.record(SlowEntry{Duration: time.Hour})
+	assert.Nil(t, disabled.get(0))
+}
+
+// TestSlowLogRecordsCommands tests that slow commands are recorded with their
+// apply time and served by SLOWLOG GET
+func TestSlowLogRecordsCommands(t *testing.T) {
+	s := NewServer(ServerOpts{ID: "node1", Store: NewRaftStore(newTestRaft(t, nil)), SlowLogThreshold: time.Nanosecond})
+
+	exchange := func(cmd any, parse func(conn net.Conn)) {
+		client, conn := net.Pipe()
+		defer client.Close()
+		now := time.Now()
+		go s.handleCommand(conn, "", cmd, now, now)
+		parse(client)
+	}
+
+	exchange(&transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")}, func(conn net.Conn) {
+		resp, err := transport.ParseSetResponse(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, transport.StatusOK, resp.Status)
+	})
+	assert.Eventually(t, func() bool { return len(s.SlowLog(0)) == 1 }, time.Second, 10*time.Millisecond)
+
+	exchange(&transport.CommandSlowLog{Op: transport.SlowLogGet}, func(conn net.Conn) {
+		resp, err := transport.ParseSlowLogResponse(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, transport.StatusOK, resp.Status)
+		assert.Len(t, resp.Entries, 1)
+		entry := resp.Entries[0]
+		assert.Equal(t, "SET", entry.Command)
+		assert.Equal(t, []byte("foo"), entry.Key)
+		assert.Positive(t, entry.Apply)
+		assert.GreaterOrEqual(t, entry.Duration, entry.Apply)
+	})
+
+	// SLOWLOG itself is recorded once it responded
+	assert.Eventually(t, func() bool { return len(s.SlowLog(0)) == 2 }, time.Second, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slowlog?n=1", nil))
+	var entries []map[string]any
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "SLOWLOG", entries[0]["command"])
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/slowlog", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, s.SlowLog(0))
+}
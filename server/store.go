@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dhyanio/discache/aof"
+	"github.com/dhyanio/discache/trace"
+	"github.com/dhyanio/discache/transport"
+	"github.com/dhyanio/gogger"
+	"github.com/hashicorp/raft"
+)
+
+// applyTimeout bounds how long a command waits to be replicated
+const applyTimeout = 5 * time.Second
+
+// Store applies the writes of a server to its state machine and tells it
+// which node accepts them. RaftStore replicates them through raft and
+// LocalStore applies them directly on a single node.
+type Store interface {
+	// Apply applies a write command and returns its index and the result of
+	// the state machine. A trace context in ctx is passed to the state machine.
+	Apply(ctx context.Context, cmd []byte) (index uint64, result any, err error)
+	// VerifyLeader confirms this node still accepts writes, so a read it
+	// serves sees every acknowledged write
+	VerifyLeader() error
+	// State returns the role of this node
+	State() raft.RaftState
+	// Leader returns the raft address and ID of the node accepting writes,
+	// empty if it is unknown
+	Leader() (raft.ServerAddress, raft.ServerID)
+	// Servers returns the members of the cluster
+	Servers() ([]raft.Server, error)
+	// Stats returns the term and log indexes of this node
+	Stats() StoreStats
+}
+
+// StoreStats are the term and log indexes of a node
+type StoreStats struct {
+	Term         uint64
+	LastIndex    uint64
+	CommitIndex  uint64
+	AppliedIndex uint64
+}
+
+// RaftStore replicates writes through a raft cluster
+type RaftStore struct {
+	raft *raft.Raft
+}
+
+// NewRaftStore creates a store replicating writes through node
+func NewRaftStore(node *raft.Raft) *RaftStore {
+	return &RaftStore{raft: node}
+}
+
+// Apply proposes cmd to the cluster and waits for this node to apply it. The
+// trace context travels with the log entry so every replica traces applying it.
+func (r *RaftStore) Apply(ctx context.Context, cmd []byte) (uint64, any, error) {
+	entry := raft.Log{Data: cmd}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry.Extensions = sc.Encode()
+	}
+	future := r.raft.ApplyLog(entry, applyTimeout)
+	if err := future.Error(); err != nil {
+		return 0, nil, err
+	}
+	return future.Index(), future.Response(), nil
+}
+
+// VerifyLeader confirms with a quorum that this node still leads
+func (r *RaftStore) VerifyLeader() error {
+	return r.raft.VerifyLeader().Error()
+}
+
+// State returns the raft state of this node
+func (r *RaftStore) State() raft.RaftState {
+	return r.raft.State()
+}
+
+// Leader returns the address and ID of the raft leader
+func (r *RaftStore) Leader() (raft.ServerAddress, raft.ServerID) {
+	return r.raft.LeaderWithID()
+}
+
+// Servers returns the latest raft configuration
+func (r *RaftStore) Servers() ([]raft.Server, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	return future.Configuration().Servers, nil
+}
+
+// Stats returns the raft term and indexes of this node
+func (r *RaftStore) Stats() StoreStats {
+	term, _ := strconv.ParseUint(r.raft.Stats()["term"], 10, 64)
+	return StoreStats{
+		Term:         term,
+		LastIndex:    r.raft.LastIndex(),
+		CommitIndex:  r.raft.CommitIndex(),
+		AppliedIndex: r.raft.AppliedIndex(),
+	}
+}
+
+// LocalStoreOpts represents the options for a local store
+type LocalStoreOpts struct {
+	ID   string   // ID of the node
+	Addr string   // Address clients reach the node at
+	FSM  raft.FSM // State machine the writes are applied to
+	AOF  *aof.AOF // File the writes are appended to, nil keeps them in memory only
+	Log  *gogger.Logger
+}
+
+// LocalStore applies writes directly to the state machine of a single node,
+// optionally persisting them to an append-only file
+type LocalStore struct {
+	opts LocalStoreOpts
+
+	mu    sync.Mutex // Serializes writes, as the state machine requires
+	index uint64     // Index of the last write applied
+}
+
+// NewLocalStore creates a local store. If it has an append-only file, the
+// state it holds is replayed into the state machine first.
+func NewLocalStore(opts LocalStoreOpts) (*LocalStore, error) {
+	l := &LocalStore{opts: opts}
+	if opts.AOF == nil {
+		return l, nil
+	}
+
+	restore := func(r io.Reader) error {
+		return opts.FSM.Restore(io.NopCloser(r))
+	}
+	// Commands failing is part of the history being replayed
+	apply := func(index uint64, cmd []byte) error {
+		opts.FSM.Apply(&raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: cmd})
+		return nil
+	}
+	index, err := opts.AOF.Replay(restore, apply)
+	if err != nil {
+		return nil, err
+	}
+	l.index = index
+	return l, nil
+}
+
+// Apply appends cmd to the append-only file and applies it to the state
+// machine. A write that cannot be appended is not applied and does not use
+// up an index. Reads applied to be ordered with writes are neither appended
+// nor given an index of their own.
+func (l *LocalStore) Apply(ctx context.Context, cmd []byte) (uint64, any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	write := len(cmd) == 0 || transport.Command(cmd[0]) != transport.CMDGet
+	index := l.index
+	if write {
+		index++
+		if l.opts.AOF != nil {
+			if err := l.opts.AOF.Append(index, cmd); err != nil {
+				return 0, nil, err
+			}
+		}
+		l.index = index
+	}
+
+	entry := &raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: cmd, AppendedAt: time.Now()}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry.Extensions = sc.Encode()
+	}
+	result := l.opts.FSM.Apply(entry)
+
+	if write && l.opts.AOF != nil && l.opts.AOF.ShouldRewrite() {
+		if err := l.rewrite(); err != nil && l.opts.Log != nil {
+			l.opts.Log.Error().Msgf("failed to rewrite append-only file: %v", err)
+		}
+	}
+	return index, result, nil
+}
+
+// Rewrite compacts the append-only file in the background to a snapshot of
+// the state machine
+func (l *LocalStore) Rewrite() error {
+	if l.opts.AOF == nil {
+		return errors.New("no append-only file")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rewrite()
+}
+
+// rewrite starts rewriting the append-only file. The store must be locked, so
+// the snapshot reflects exactly the writes up to the last index.
+func (l *LocalStore) rewrite() error {
+	snapshot, err := l.opts.FSM.Snapshot()
+	if err != nil {
+		return err
+	}
+	return l.opts.AOF.Rewrite(l.index, func(w io.Writer) error {
+		defer snapshot.Release()
+		return snapshot.Persist(&writerSink{Writer: w})
+	})
+}
+
+// VerifyLeader always succeeds, as the node is the only one
+func (l *LocalStore) VerifyLeader() error {
+	return nil
+}
+
+// State returns raft.Leader, as the node accepts every write
+func (l *LocalStore) State() raft.RaftState {
+	return raft.Leader
+}
+
+// Leader returns the node itself
+func (l *LocalStore) Leader() (raft.ServerAddress, raft.ServerID) {
+	return raft.ServerAddress(l.opts.Addr), raft.ServerID(l.opts.ID)
+}
+
+// Servers returns the node itself
+func (l *LocalStore) Servers() ([]raft.Server, error) {
+	return []raft.Server{{Suffrage: raft.Voter, ID: raft.ServerID(l.opts.ID), Address: raft.ServerAddress(l.opts.Addr)}}, nil
+}
+
+// Stats returns the index of the last write for every index, which is
+// applied as soon as it is written
+func (l *LocalStore) Stats() StoreStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return StoreStats{LastIndex: l.index, CommitIndex: l.index, AppliedIndex: l.index}
+}
+
+// writerSink lets a state machine snapshot be persisted to any writer
+type writerSink struct {
+	io.Writer
+}
+
+func (w *writerSink) ID() string    { return "aof" }
+func (w *writerSink) Cancel() error { return nil }
+func (w *writerSink) Close() error  { return nil }
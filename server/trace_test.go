@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dhyanio/discache/trace"
+	"github.com/dhyanio/discache/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCommandSpans tests that a traced command creates server, parse and apply
+// spans under the span of the client
+func TestCommandSpans(t *testing.T) {
+	exporter := trace.NewInMemoryExporter()
+	s := NewServer(ServerOpts{ID: "node1", Store: NewRaftStore(newTestRaft(t, nil)), Tracer: trace.NewTracer(trace.TracerOpts{Exporter: exporter})})
+
+	parent := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{2}, Sampled: true}
+	cmd := &transport.CommandTraced{
+		Context: parent,
+		Command: &transport.CommandSet{Key: []byte("foo"), Value: []byte("bar")},
+	}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	start := time.Now()
+	go s.handleCommand(conn, "", cmd, start, start.Add(time.Millisecond))
+
+	resp, err := transport.ParseSetResponse(client)
+	assert.Nil(t, err)
+	assert.Equal(t, transport.StatusOK, resp.Status)
+
+	assert.Eventually(t, func() bool { return len(exporter.Spans()) == 3 }, time.Second, 10*time.Millisecond)
+	spans := make(map[string]trace.SpanData)
+	for _, span := range exporter.Spans() {
+		assert.Equal(t, parent.TraceID, span.TraceID)
+		spans[span.Name] = span
+	}
+
+	server := spans["server SET"]
+	assert.Equal(t, parent.SpanID, server.Parent)
+	assert.Equal(t, start, server.Start)
+	assert.Equal(t, "node1", server.Attributes["node"])
+	assert.Equal(t, "Leader", server.Attributes["role"])
+
+	assert.Equal(t, server.SpanID, spans["parse"].Parent)
+	assert.Equal(t, time.Millisecond, spans["parse"].Duration())
+	assert.Equal(t, server.SpanID, spans["apply"].Parent)
+	assert.NotEmpty(t, spans["apply"].Attributes["index"])
+}
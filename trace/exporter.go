@@ -0,0 +1,84 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// InMemoryExporter keeps the exported spans in memory, mostly for tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter creates an empty in-memory exporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Export stores the span
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the spans exported so far in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.spans)
+}
+
+// Reset forgets the spans exported so far
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// JSONExporter writes every span as a line of JSON
+type JSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONExporter creates an exporter writing spans to w
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+// jsonSpan is the JSON representation of a span
+type jsonSpan struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	Parent     string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	DurationUs int64             `json:"duration_us"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Err        string            `json:"error,omitempty"`
+}
+
+// Export writes the span, dropping it if the writer fails
+func (e *JSONExporter) Export(span SpanData) {
+	s := jsonSpan{
+		Name:       span.Name,
+		TraceID:    span.TraceID.String(),
+		SpanID:     span.SpanID.String(),
+		Start:      span.Start,
+		DurationUs: span.Duration().Microseconds(),
+		Attributes: span.Attributes,
+		Err:        span.Err,
+	}
+	if span.Parent.IsValid() {
+		s.Parent = span.Parent.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(s)
+}
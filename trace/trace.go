@@ -0,0 +1,294 @@
+// Package trace records spans of the work done for a command as it goes from
+// the client to a node, on to the leader and through raft to every replica.
+// Trace and span IDs follow the W3C Trace Context format used by
+// OpenTelemetry, so an Exporter can hand the spans to any tracing backend.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// EncodedLen is the length of an encoded span context
+const EncodedLen = 25
+
+// ErrInvalidContext is returned when decoding a malformed span context
+var ErrInvalidContext = errors.New("invalid span context")
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the hex representation of the ID
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the hex representation of the ID
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the context identifies a span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// String returns the context in the W3C traceparent format
+func (sc SpanContext) String() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// Encode returns the binary encoding of the context: the trace ID, the span
+// ID and a flags byte
+func (sc SpanContext) Encode() []byte {
+	b := make([]byte, 0, EncodedLen)
+	b = append(b, sc.TraceID[:]...)
+	b = append(b, sc.SpanID[:]...)
+	if sc.Sampled {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// Decode decodes a span context encoded by Encode
+func Decode(b []byte) (SpanContext, error) {
+	var sc SpanContext
+	if len(b) != EncodedLen {
+		return sc, ErrInvalidContext
+	}
+	copy(sc.TraceID[:], b[:16])
+	copy(sc.SpanID[:], b[16:24])
+	sc.Sampled = b[24]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidContext
+	}
+	return sc, nil
+}
+
+// SpanData is a finished span handed to the exporter
+type SpanData struct {
+	Name       string
+	TraceID    TraceID
+	SpanID     SpanID
+	Parent     SpanID // Zero for the root span of a trace
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        string // Error the work failed with, if any
+}
+
+// Context returns the span context of the exported span
+func (d SpanData) Context() SpanContext {
+	return SpanContext{TraceID: d.TraceID, SpanID: d.SpanID, Sampled: true}
+}
+
+// Duration returns how long the span took
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Exporter receives the spans that end. It must be safe for concurrent use
+// and should not block, as it runs on the path of the traced command.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// TracerOpts is the configuration of a tracer
+type TracerOpts struct {
+	Exporter Exporter
+	// Sampling makes one in that many traces started here sampled, every trace
+	// if 0 or 1. Traces continued from a remote parent follow its decision.
+	Sampling int
+}
+
+// Tracer starts spans and exports the sampled ones once they end. A nil
+// tracer starts no spans, though contexts received from remote parents are
+// still propagated.
+type Tracer struct {
+	opts TracerOpts
+}
+
+// NewTracer creates a tracer exporting spans to opts.Exporter
+func NewTracer(opts TracerOpts) *Tracer {
+	return &Tracer{opts: opts}
+}
+
+// Start starts a span named name, the child of the span or remote span
+// context carried by ctx, and returns a context carrying the new span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	return t.StartAt(ctx, name, time.Now())
+}
+
+// StartAt is Start for a span that began at start
+func (t *Tracer) StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	span := &Span{tracer: t, data: SpanData{Name: name, Start: start}}
+	if parent.IsValid() {
+		span.data.TraceID = parent.TraceID
+		span.data.Parent = parent.SpanID
+		span.sampled = parent.Sampled
+	} else {
+		span.data.TraceID = newTraceID()
+		span.sampled = t.opts.Sampling <= 1 || rand.IntN(t.opts.Sampling) == 0
+	}
+	span.data.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Span is the work of a single step of a trace. Its methods do nothing on a
+// nil span, so callers need not check whether tracing is enabled.
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+	mu      sync.Mutex
+	data    SpanData
+	ended   bool
+}
+
+// Context returns the span context to propagate to children of the span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+// SetName renames the span
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute records key as value on the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError records that the work of the span failed with err, if not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// End ends the span and exports it if it is sampled. Only the first call has
+// any effect.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt is End for a span that finished at end
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	s.mu.Unlock()
+
+	if s.sampled && s.tracer.opts.Exporter != nil {
+		s.tracer.opts.Exporter.Export(data)
+	}
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// remoteKey is the context key of a span context received from another process
+type remoteKey struct{}
+
+// SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote returns a context whose spans continue the trace of a
+// span from another process
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the span carried by ctx, or
+// else the remote span context it carries, to propagate to other processes
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// newTraceID returns a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		hi, lo := rand.Uint64(), rand.Uint64()
+		for i := range 8 {
+			id[i] = byte(hi >> (56 - 8*i))
+			id[8+i] = byte(lo >> (56 - 8*i))
+		}
+	}
+	return id
+}
+
+// newSpanID returns a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		v := rand.Uint64()
+		for i := range 8 {
+			id[i] = byte(v >> (56 - 8*i))
+		}
+	}
+	return id
+}
@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSpanContextEncoding tests that a span context survives Encode and
+// Decode and that truncated or empty contexts are rejected
+func TestSpanContextEncoding(t *testing.T) {
+	sc := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa},
+		Sampled: true,
+	}
+	assert.Equal(t, "00-4bf92f35000000000000000000000000-00f067aa00000000-01", sc.String())
+
+	decoded, err := Decode(sc.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, sc, decoded)
+
+	_, err = Decode(sc.Encode()[:10])
+	assert.ErrorIs(t, err, ErrInvalidContext)
+	_, err = Decode(SpanContext{}.Encode())
+	assert.ErrorIs(t, err, ErrInvalidContext)
+}
+
+// TestSpansFormTrace tests that a child span shares the trace of its parent
+// and is exported once with its attributes and error
+func TestSpansFormTrace(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(TracerOpts{Exporter: exporter})
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, root.Context().TraceID, spans[0].TraceID)
+	assert.Equal(t, root.Context().SpanID, spans[0].Parent)
+	assert.Equal(t, map[string]string{"key": "value"}, spans[0].Attributes)
+	assert.Equal(t, "failed", spans[0].Err)
+	assert.False(t, spans[1].Parent.IsValid())
+
+	exporter.Reset()
+	assert.Empty(t, exporter.Spans())
+}
+
+// TestRemoteContextPropagated tests that a remote span context is propagated
+// without a tracer and parents the spans of one
+func TestRemoteContextPropagated(t *testing.T) {
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	ctx := ContextWithRemote(context.Background(), remote)
+
+	// Without a tracer no span starts but the remote context is still propagated
+	var tracer *Tracer
+	ctx, span := tracer.Start(ctx, "ignored")
+	assert.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.End()
+	assert.Equal(t, remote, SpanContextFromContext(ctx))
+
+	exporter := NewInMemoryExporter()
+	tracer = NewTracer(TracerOpts{Exporter: exporter})
+	_, span = tracer.Start(ctx, "continued")
+	span.End()
+	assert.Equal(t, remote.TraceID, exporter.Spans()[0].TraceID)
+	assert.Equal(t, remote.SpanID, exporter.Spans()[0].Parent)
+}
+
+// TestSamplingFollowsParent tests that children of unsampled spans are not
+// exported while a sampled remote parent is
+func TestSamplingFollowsParent(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(TracerOpts{Exporter: exporter, Sampling: 1 << 62})
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	root.End()
+	assert.False(t, root.Context().Sampled)
+	assert.Empty(t, exporter.Spans())
+
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	_, span := tracer.Start(ContextWithRemote(context.Background(), remote), "continued")
+	span.End()
+	assert.Len(t, exporter.Spans(), 1)
+}
+
+// TestJSONExporter tests the JSON line a span is exported as
+func TestJSONExporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	exporter := NewJSONExporter(buf)
+	start := time.Now()
+	exporter.Export(SpanData{
+		Name:    "server SET",
+		TraceID: TraceID{1},
+		SpanID:  SpanID{2},
+		Start:   start,
+		End:     start.Add(1500 * time.Microsecond),
+	})
+
+	var span map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &span))
+	assert.Equal(t, "server SET", span["name"])
+	assert.Equal(t, "01000000000000000000000000000000", span["trace_id"])
+	assert.Equal(t, float64(1500), span["duration_us"])
+	assert.NotContains(t, span, "parent_id")
+}
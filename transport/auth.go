@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// CommandAuth is a command to authenticate the connection with the password
+// of the node. Until it succeeds, a node requiring a password answers every
+// other command with StatusNoAuth. Peer is set by nodes forwarding commands
+// of their own clients, and Client names the client a forwarded command came
+// from, so the node it is forwarded to rate limits that client.
+type CommandAuth struct {
+	Password []byte
+	Peer     bool
+	Client   string
+}
+
+// Bytes returns the byte representation of the auth command
+func (c *CommandAuth) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDAuth); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Password); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Peer); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, []byte(c.Client)); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseAuthCommand parses an auth command from the reader
+func parseAuthCommand(r io.Reader) (*CommandAuth, error) {
+	password, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd := &CommandAuth{Password: password}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Peer); err != nil {
+		return nil, err
+	}
+	client, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Client = string(client)
+	return cmd, nil
+}
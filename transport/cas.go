@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Condition is a byte representing the guard of a conditional write
+type Condition byte
+
+const (
+	CondNone Condition = iota
+	CondVersion
+	CondAbsent
+	CondPresent
+)
+
+// CommandSetIf is a command to set a key-value pair with a TTL if Cond holds.
+// Version is the expected current version for CondVersion. At is the TTL
+// resolved to a Unix time in milliseconds, like the one of CommandSet.
+type CommandSetIf struct {
+	Key     []byte
+	Value   []byte
+	TTL     int
+	At      int64
+	Cond    Condition
+	Version uint64
+}
+
+// Bytes returns the byte representation of the conditional set command
+func (c *CommandSetIf) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDSetIf); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Value); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(c.TTL)); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Cond); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Version); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseSetIfCommand parses a conditional set command from the reader
+func parseSetIfCommand(r io.Reader) (*CommandSetIf, error) {
+	cmd := &CommandSetIf{}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	value, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Value = value
+
+	var ttl int32
+	if err := binary.Read(r, binary.LittleEndian, &ttl); err != nil {
+		return nil, err
+	}
+	cmd.TTL = int(ttl)
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Cond); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Version); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// CommandDeleteIf is a command to delete a key if it is at Version
+type CommandDeleteIf struct {
+	Key     []byte
+	Version uint64
+}
+
+// Bytes returns the byte representation of the conditional delete command
+func (c *CommandDeleteIf) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDDeleteIf); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Version); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseDeleteIfCommand parses a conditional delete command from the reader
+func parseDeleteIfCommand(r io.Reader) (*CommandDeleteIf, error) {
+	cmd := &CommandDeleteIf{}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Version); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ResponseVersion is a response to a conditional write. Version is the new
+// version of the key on success and its current version on conflict.
+type ResponseVersion struct {
+	Status  Status
+	Version uint64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseVersion) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Version); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseVersionResponse parses a version response from the reader
+func ParseVersionResponse(r io.Reader) (*ResponseVersion, error) {
+	resp := &ResponseVersion{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Version); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// CommandClusterInfo is a command to describe the cluster members and leader
+type CommandClusterInfo struct{}
+
+// Bytes returns the byte representation of the cluster info command
+func (c *CommandClusterInfo) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDClusterInfo); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Member describes a cluster member as seen by clients
+type Member struct {
+	ID    string
+	Addr  string // Client-facing address of the member
+	Voter bool
+}
+
+// ResponseClusterInfo is a response to a cluster info command
+type ResponseClusterInfo struct {
+	Status  Status
+	Leader  string // Client-facing address of the leader, empty when unknown
+	Members []Member
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseClusterInfo) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, []byte(r.Leader)); err != nil {
+		return nil
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(r.Members))); err != nil {
+		return nil
+	}
+	for _, m := range r.Members {
+		if err := writeBytes(buf, []byte(m.ID)); err != nil {
+			return nil
+		}
+		if err := writeBytes(buf, []byte(m.Addr)); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, m.Voter); err != nil {
+			return nil
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// ParseClusterInfoResponse parses a cluster info response from the reader
+func ParseClusterInfoResponse(r io.Reader) (*ResponseClusterInfo, error) {
+	resp := &ResponseClusterInfo{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+
+	leader, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Leader = string(leader)
+
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	for i := int32(0); i < count; i++ {
+		id, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		m := Member{ID: string(id), Addr: string(addr)}
+		if err := binary.Read(r, binary.LittleEndian, &m.Voter); err != nil {
+			return nil, err
+		}
+		resp.Members = append(resp.Members, m)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// CommandIncr is a command to atomically add Delta to the integer stored at
+// Key. A missing key is created holding Initial, with a TTL in seconds, before
+// Delta is added. At is the TTL resolved to a Unix time in milliseconds, like
+// the one of CommandSet.
+type CommandIncr struct {
+	Key     []byte
+	Delta   int64
+	Initial int64
+	TTL     int
+	At      int64
+}
+
+// Bytes returns the byte representation of the incr command
+func (c *CommandIncr) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDIncr); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Delta); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Initial); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(c.TTL)); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseIncrCommand parses an incr command from the reader
+func parseIncrCommand(r io.Reader) (*CommandIncr, error) {
+	cmd := &CommandIncr{}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Delta); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Initial); err != nil {
+		return nil, err
+	}
+
+	var ttl int32
+	if err := binary.Read(r, binary.LittleEndian, &ttl); err != nil {
+		return nil, err
+	}
+	cmd.TTL = int(ttl)
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// ResponseIncr is a response to an incr command
+type ResponseIncr struct {
+	Status Status
+	Value  int64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseIncr) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Value); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseIncrResponse parses an incr response from the reader
+func ParseIncrResponse(r io.Reader) (*ResponseIncr, error) {
+	resp := &ResponseIncr{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Value); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
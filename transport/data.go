@@ -0,0 +1,280 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// DataOp is a byte representing an operation on a hash, list, set or sorted set
+type DataOp byte
+
+const (
+	OpNone DataOp = iota
+	OpHSet
+	OpHGet
+	OpHDel
+	OpHGetAll
+	OpHLen
+	OpLPush
+	OpRPush
+	OpLPop
+	OpRPop
+	OpLRange
+	OpLLen
+	OpSAdd
+	OpSRem
+	OpSMembers
+	OpSIsMember
+	OpSCard
+	OpZAdd
+	OpZRem
+	OpZScore
+	OpZRangeByScore
+	OpZCard
+)
+
+// dataOps holds the name, whether it writes, and the arity of every data operation.
+// An arity of -n accepts n or more arguments.
+var dataOps = map[DataOp]struct {
+	name  string
+	write bool
+	arity int
+}{
+	OpHSet:          {"HSET", true, 2},
+	OpHGet:          {"HGET", false, 1},
+	OpHDel:          {"HDEL", true, -1},
+	OpHGetAll:       {"HGETALL", false, 0},
+	OpHLen:          {"HLEN", false, 0},
+	OpLPush:         {"LPUSH", true, -1},
+	OpRPush:         {"RPUSH", true, -1},
+	OpLPop:          {"LPOP", true, 0},
+	OpRPop:          {"RPOP", true, 0},
+	OpLRange:        {"LRANGE", false, 2},
+	OpLLen:          {"LLEN", false, 0},
+	OpSAdd:          {"SADD", true, -1},
+	OpSRem:          {"SREM", true, -1},
+	OpSMembers:      {"SMEMBERS", false, 0},
+	OpSIsMember:     {"SISMEMBER", false, 1},
+	OpSCard:         {"SCARD", false, 0},
+	OpZAdd:          {"ZADD", true, -2},
+	OpZRem:          {"ZREM", true, -1},
+	OpZScore:        {"ZSCORE", false, 1},
+	OpZRangeByScore: {"ZRANGEBYSCORE", false, 2},
+	OpZCard:         {"ZCARD", false, 0},
+}
+
+// String returns the name of the operation
+func (op DataOp) String() string {
+	if info, ok := dataOps[op]; ok {
+		return info.name
+	}
+	return "NONE"
+}
+
+// Write reports whether the operation modifies the key
+func (op DataOp) Write() bool {
+	return dataOps[op].write
+}
+
+// CommandData is a command to run Op on the hash, list, set or sorted set
+// stored at Key. Numbers in Args, such as scores and list indexes, are
+// encoded as decimal strings:
+//
+//	HSET field value          HGET field        HDEL field...
+//	LPUSH/RPUSH value...      LRANGE start stop
+//	SADD/SREM member...       SISMEMBER member
+//	ZADD score member...      ZREM member...    ZSCORE member
+//	ZRANGEBYSCORE min max
+//
+// TTL in seconds applies to a key created by a write, resolved to At, a Unix
+// time in milliseconds, like the one of CommandSet.
+type CommandData struct {
+	Op   DataOp
+	Key  []byte
+	Args [][]byte
+	TTL  int
+	At   int64
+}
+
+// Bytes returns the byte representation of the data command
+func (c *CommandData) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDData); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Op); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := writeList(buf, c.Args); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(c.TTL)); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Validate checks the arguments of the command
+func (c *CommandData) Validate() error {
+	info, ok := dataOps[c.Op]
+	if !ok {
+		return fmt.Errorf("unknown data operation %d", c.Op)
+	}
+	if (info.arity >= 0 && len(c.Args) != info.arity) ||
+		(info.arity < 0 && len(c.Args) < -info.arity) {
+		return fmt.Errorf("wrong number of arguments for %s", info.name)
+	}
+
+	switch c.Op {
+	case OpLRange:
+		for _, arg := range c.Args {
+			if _, err := strconv.Atoi(string(arg)); err != nil {
+				return fmt.Errorf("invalid index %q for %s", arg, info.name)
+			}
+		}
+	case OpZRangeByScore:
+		for _, arg := range c.Args {
+			if _, err := ParseScore(arg); err != nil {
+				return err
+			}
+		}
+	case OpZAdd:
+		if len(c.Args)%2 != 0 {
+			return fmt.Errorf("wrong number of arguments for %s", info.name)
+		}
+		for i := 0; i < len(c.Args); i += 2 {
+			if _, err := ParseScore(c.Args[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FormatScore encodes a sorted set score as a command argument
+func FormatScore(score float64) []byte {
+	return []byte(strconv.FormatFloat(score, 'g', -1, 64))
+}
+
+// ParseScore decodes a sorted set score from a command argument
+func ParseScore(arg []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("invalid score %q", arg)
+	}
+	return score, nil
+}
+
+// parseDataCommand parses a data command from the reader
+func parseDataCommand(r io.Reader) (*CommandData, error) {
+	cmd := &CommandData{}
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Op); err != nil {
+		return nil, err
+	}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	if cmd.Args, err = readList(r); err != nil {
+		return nil, err
+	}
+
+	var ttl int32
+	if err := binary.Read(r, binary.LittleEndian, &ttl); err != nil {
+		return nil, err
+	}
+	cmd.TTL = int(ttl)
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ResponseData is a response to a data command. Int carries counts, lengths
+// and booleans; Values carries returned values, with HGETALL returning field
+// value pairs and ZRANGEBYSCORE member score pairs.
+type ResponseData struct {
+	Status Status
+	Int    int64
+	Values [][]byte
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseData) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Int); err != nil {
+		return nil
+	}
+	if err := writeList(buf, r.Values); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseDataResponse parses a data response from the reader
+func ParseDataResponse(r io.Reader) (*ResponseData, error) {
+	resp := &ResponseData{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Int); err != nil {
+		return nil, err
+	}
+	values, err := readList(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Values = values
+	return resp, nil
+}
+
+// writeList writes a count prefixed list of byte slices to the buffer
+func writeList(buf *bytes.Buffer, list [][]byte) error {
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(list))); err != nil {
+		return err
+	}
+	for _, b := range list {
+		if err := writeBytes(buf, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readList reads a count prefixed list of byte slices from the reader
+func readList(r io.Reader) ([][]byte, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("invalid list length %d", count)
+	}
+
+	list := [][]byte{}
+	for i := int32(0); i < count; i++ {
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, nil
+}
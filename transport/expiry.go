@@ -0,0 +1,195 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// CommandTTL is a command to get the remaining time to live of a key
+type CommandTTL struct {
+	Key []byte
+}
+
+// Bytes returns the byte representation of the TTL command
+func (c *CommandTTL) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDTTL); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseTTLCommand parses a TTL command from the reader
+func parseTTLCommand(r io.Reader) (*CommandTTL, error) {
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	return &CommandTTL{Key: key}, nil
+}
+
+// ResponseTTL is a response to a TTL command. TTL is the remaining time to
+// live in milliseconds, -1 if the key never expires, and Sliding the window
+// in milliseconds reads extend it to, 0 if it is fixed.
+type ResponseTTL struct {
+	Status  Status
+	TTL     int64
+	Sliding int64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseTTL) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.TTL); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Sliding); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseTTLResponse parses a TTL response from the reader
+func ParseTTLResponse(r io.Reader) (*ResponseTTL, error) {
+	resp := &ResponseTTL{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.TTL); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Sliding); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CommandExpire is a command to change the expiry of a key. The server that
+// receives it resolves TTL, in milliseconds from now, to At so every replica
+// applies the same expiry. At is a Unix time in milliseconds, 0 with a TTL of
+// 0 persists the key. Sliding makes reads extend the expiry to TTL again.
+type CommandExpire struct {
+	Key     []byte
+	TTL     int64
+	At      int64
+	Sliding bool
+}
+
+// Bytes returns the byte representation of the expire command
+func (c *CommandExpire) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDExpire); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.TTL); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Sliding); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseExpireCommand parses an expire command from the reader
+func parseExpireCommand(r io.Reader) (*CommandExpire, error) {
+	cmd := &CommandExpire{}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.TTL); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Sliding); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// CommandTouch is a command to mark keys as used, extending the expiry of
+// sliding keys. At is the Unix time in milliseconds of the touch, set by the
+// server that receives it so every replica applies the same expiry.
+type CommandTouch struct {
+	Keys [][]byte
+	At   int64
+}
+
+// Bytes returns the byte representation of the touch command
+func (c *CommandTouch) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDTouch); err != nil {
+		return nil
+	}
+	if err := writeList(buf, c.Keys); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseTouchCommand parses a touch command from the reader
+func parseTouchCommand(r io.Reader) (*CommandTouch, error) {
+	cmd := &CommandTouch{}
+
+	keys, err := readList(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Keys = keys
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ResponseTouch is a response to a touch command with the number of keys found
+type ResponseTouch struct {
+	Status Status
+	Count  int64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseTouch) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Count); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseTouchResponse parses a touch response from the reader
+func ParseTouchResponse(r io.Reader) (*ResponseTouch, error) {
+	resp := &ResponseTouch{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Count); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
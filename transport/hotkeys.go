@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxHotKeys bounds the number of keys in a hot keys response
+const maxHotKeys = 1 << 16
+
+// CommandHotKeys is a command to list the most accessed keys of a node, at
+// most Count of them, every monitored key when Count is 0
+type CommandHotKeys struct {
+	Count int32
+}
+
+// Bytes returns the byte representation of the hot keys command
+func (c *CommandHotKeys) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDHotKeys); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Count); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseHotKeysCommand parses a hot keys command from the reader
+func parseHotKeysCommand(r io.Reader) (*CommandHotKeys, error) {
+	cmd := &CommandHotKeys{}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Count); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// HotKey is a frequently accessed key with its estimated access count, which
+// may overestimate by up to Error
+type HotKey struct {
+	Key   []byte
+	Count uint64
+	Error uint64
+}
+
+// ResponseHotKeys is a response to a hot keys command, in descending order of count
+type ResponseHotKeys struct {
+	Status Status
+	Keys   []HotKey
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseHotKeys) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(r.Keys))); err != nil {
+		return nil
+	}
+	for _, key := range r.Keys {
+		if err := writeBytes(buf, key.Key); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, key.Count); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, key.Error); err != nil {
+			return nil
+		}
+	}
+	return buf.Bytes()
+}
+
+// ParseHotKeysResponse parses a hot keys response from the reader
+func ParseHotKeysResponse(r io.Reader) (*ResponseHotKeys, error) {
+	resp := &ResponseHotKeys{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 || n > maxHotKeys {
+		return nil, fmt.Errorf("invalid hot key count %d", n)
+	}
+	resp.Keys = make([]HotKey, n)
+	for i := range resp.Keys {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.Keys[i].Key = key
+		if err := binary.Read(r, binary.LittleEndian, &resp.Keys[i].Count); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &resp.Keys[i].Error); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
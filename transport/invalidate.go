@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// InvalidateBy is a byte representing what a bulk invalidation matches keys by
+type InvalidateBy byte
+
+const (
+	InvalidateByTag InvalidateBy = iota + 1
+	InvalidateByPrefix
+)
+
+// CommandInvalidate is a command to delete every key carrying the tag Match,
+// or starting with the prefix Match, in one replicated operation
+type CommandInvalidate struct {
+	By    InvalidateBy
+	Match []byte
+}
+
+// Bytes returns the byte representation of the invalidate command
+func (c *CommandInvalidate) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDInvalidate); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.By); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Match); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseInvalidateCommand parses an invalidate command from the reader
+func parseInvalidateCommand(r io.Reader) (*CommandInvalidate, error) {
+	cmd := &CommandInvalidate{}
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.By); err != nil {
+		return nil, err
+	}
+	if cmd.By != InvalidateByTag && cmd.By != InvalidateByPrefix {
+		return nil, fmt.Errorf("invalid invalidation kind %d", cmd.By)
+	}
+
+	match, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Match = match
+	return cmd, nil
+}
+
+// ResponseInvalidate is a response to an invalidate command with the number of deleted keys
+type ResponseInvalidate struct {
+	Status Status
+	Count  int64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseInvalidate) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Count); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseInvalidateResponse parses an invalidate response from the reader
+func ParseInvalidateResponse(r io.Reader) (*ResponseInvalidate, error) {
+	resp := &ResponseInvalidate{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Count); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
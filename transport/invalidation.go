@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// CommandInvalidations is a command to subscribe to key invalidations. The
+// server acknowledges it with a ResponseStatus and then streams Invalidation
+// frames on the connection until it is closed.
+type CommandInvalidations struct{}
+
+// Bytes returns the byte representation of the invalidations command
+func (c *CommandInvalidations) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDInvalidations); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Invalidation is a frame pushed to subscribers when a key changes
+type Invalidation struct {
+	Key []byte
+}
+
+// Bytes returns the byte representation of the invalidation
+func (i *Invalidation) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := writeBytes(buf, i.Key); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseInvalidation parses an invalidation frame from the reader
+func ParseInvalidation(r io.Reader) (*Invalidation, error) {
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Invalidation{Key: key}, nil
+}
@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// LeaseOp is a byte representing an operation on a lease
+type LeaseOp byte
+
+const (
+	LeaseAcquire LeaseOp = iota + 1
+	LeaseRenew
+	LeaseRelease
+	// LeaseExpire is only proposed by the leader once a lease outlived its TTL
+	LeaseExpire
+)
+
+// String returns the name of the operation
+func (op LeaseOp) String() string {
+	switch op {
+	case LeaseAcquire:
+		return "ACQUIRE"
+	case LeaseRenew:
+		return "RENEW"
+	case LeaseRelease:
+		return "RELEASE"
+	case LeaseExpire:
+		return "EXPIRE"
+	default:
+		return "NONE"
+	}
+}
+
+// CommandLease is a command to acquire, renew, release or expire the lease
+// Name. Token is the fencing token of the held lease for every operation but
+// acquire, TTL in milliseconds applies to acquire and renew, and Renewed is
+// the raft index of the last grant an expiry was decided for.
+type CommandLease struct {
+	Op      LeaseOp
+	Name    []byte
+	Token   uint64
+	TTL     int64
+	Renewed uint64
+}
+
+// Bytes returns the byte representation of the lease command
+func (c *CommandLease) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDLease); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Op); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Name); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Token); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.TTL); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Renewed); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseLeaseCommand parses a lease command from the reader
+func parseLeaseCommand(r io.Reader) (*CommandLease, error) {
+	cmd := &CommandLease{}
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Op); err != nil {
+		return nil, err
+	}
+
+	name, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Name = name
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Token); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.TTL); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Renewed); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ResponseLease is a response to a lease command. Token is the fencing token
+// of the lease, or of its current holder when acquiring conflicts. TTL in
+// milliseconds is the granted TTL.
+type ResponseLease struct {
+	Status Status
+	Token  uint64
+	TTL    int64
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseLease) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Token); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.TTL); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseLeaseResponse parses a lease response from the reader
+func ParseLeaseResponse(r io.Reader) (*ResponseLease, error) {
+	resp := &ResponseLease{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Token); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.TTL); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// MaxScanCount bounds the number of keys a server returns per scan page
+const MaxScanCount = 1000
+
+// CommandScan is a command to list a page of keys greater than Cursor in
+// lexical order, filtered by Prefix and the glob pattern Match. An empty
+// Cursor starts a new scan and a Count of 0 uses the server default.
+type CommandScan struct {
+	Cursor []byte
+	Prefix []byte
+	Match  []byte
+	Count  int32
+}
+
+// Bytes returns the byte representation of the scan command
+func (c *CommandScan) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDScan); err != nil {
+		return nil
+	}
+	for _, b := range [][]byte{c.Cursor, c.Prefix, c.Match} {
+		if err := writeBytes(buf, b); err != nil {
+			return nil
+		}
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Count); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseScanCommand parses a scan command from the reader
+func parseScanCommand(r io.Reader) (*CommandScan, error) {
+	cmd := &CommandScan{}
+
+	var err error
+	if cmd.Cursor, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if cmd.Prefix, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if cmd.Match, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Count); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ResponseScan is a response to a scan command with a page of keys and the
+// cursor of the next page, empty once the scan is done
+type ResponseScan struct {
+	Status Status
+	Cursor []byte
+	Keys   [][]byte
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseScan) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, r.Cursor); err != nil {
+		return nil
+	}
+	if err := writeList(buf, r.Keys); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseScanResponse parses a scan response from the reader
+func ParseScanResponse(r io.Reader) (*ResponseScan, error) {
+	resp := &ResponseScan{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if resp.Cursor, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if resp.Keys, err = readList(r); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
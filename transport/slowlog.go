@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxSlowLogEntries bounds the number of entries in a slow log response
+const maxSlowLogEntries = 1 << 16
+
+// SlowLogOp is a byte representing an operation on the slow log
+type SlowLogOp byte
+
+const (
+	SlowLogGet SlowLogOp = iota + 1
+	SlowLogReset
+)
+
+// String returns the name of the operation
+func (op SlowLogOp) String() string {
+	switch op {
+	case SlowLogGet:
+		return "GET"
+	case SlowLogReset:
+		return "RESET"
+	default:
+		return "NONE"
+	}
+}
+
+// CommandSlowLog is a command to read the Count most recent entries of the
+// slow log of a node, every entry when Count is 0, or to empty it
+type CommandSlowLog struct {
+	Op    SlowLogOp
+	Count int32
+}
+
+// Bytes returns the byte representation of the slow log command
+func (c *CommandSlowLog) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDSlowLog); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Op); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Count); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseSlowLogCommand parses a slow log command from the reader
+func parseSlowLogCommand(r io.Reader) (*CommandSlowLog, error) {
+	cmd := &CommandSlowLog{}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Op); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Count); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// SlowLogEntry is a command that took longer than the slow log threshold.
+// Time is when it started in unix microseconds, Duration and Apply how long
+// it took and how much of it was spent in raft apply, in microseconds.
+type SlowLogEntry struct {
+	ID       uint64
+	Time     int64
+	Duration int64
+	Apply    int64
+	Client   string
+	Command  string
+	Key      []byte // Truncated to the first bytes of long keys
+}
+
+// ResponseSlowLog is a response to a slow log command, most recent entry first
+type ResponseSlowLog struct {
+	Status  Status
+	Entries []SlowLogEntry
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseSlowLog) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(r.Entries))); err != nil {
+		return nil
+	}
+	for _, e := range r.Entries {
+		for _, v := range []any{e.ID, e.Time, e.Duration, e.Apply} {
+			if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+				return nil
+			}
+		}
+		for _, b := range [][]byte{[]byte(e.Client), []byte(e.Command), e.Key} {
+			if err := writeBytes(buf, b); err != nil {
+				return nil
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// ParseSlowLogResponse parses a slow log response from the reader
+func ParseSlowLogResponse(r io.Reader) (*ResponseSlowLog, error) {
+	resp := &ResponseSlowLog{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 || n > maxSlowLogEntries {
+		return nil, fmt.Errorf("invalid slow log entry count %d", n)
+	}
+	resp.Entries = make([]SlowLogEntry, n)
+	for i := range resp.Entries {
+		e := &resp.Entries[i]
+		for _, v := range []any{&e.ID, &e.Time, &e.Duration, &e.Apply} {
+			if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+				return nil, err
+			}
+		}
+		client, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		command, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if e.Key, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		e.Client, e.Command = string(client), string(command)
+	}
+	return resp, nil
+}
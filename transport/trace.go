@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"errors"
+	"io"
+
+	"github.com/dhyanio/discache/trace"
+)
+
+// CommandTraced is a command preceded by the trace context of the span that
+// sent it. The header is optional: a command sent without it starts a new
+// trace on the server.
+type CommandTraced struct {
+	Context trace.SpanContext
+	Command any
+}
+
+// WithTrace prefixes an encoded command with the trace header carrying sc,
+// or returns it unchanged if sc is not valid
+func WithTrace(sc trace.SpanContext, cmd []byte) []byte {
+	if !sc.IsValid() || len(cmd) == 0 {
+		return cmd
+	}
+	b := make([]byte, 0, 1+trace.EncodedLen+len(cmd))
+	b = append(b, byte(CMDTrace))
+	b = append(b, sc.Encode()...)
+	return append(b, cmd...)
+}
+
+// parseTracedCommand parses the trace header and the command it precedes
+func parseTracedCommand(r io.Reader) (*CommandTraced, error) {
+	header := make([]byte, trace.EncodedLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	sc, err := trace.Decode(header)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := ParseCommand(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, nested := cmd.(*CommandTraced); nested {
+		return nil, errors.New("nested trace header")
+	}
+	return &CommandTraced{Context: sc, Command: cmd}, nil
+}
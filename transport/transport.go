@@ -16,8 +16,78 @@ const (
 	CMDGet
 	CMDDel
 	CMDJoin
+	CMDClusterInfo
+	CMDInvalidations
+	CMDWatch
+	CMDIncr
+	CMDSetIf
+	CMDDeleteIf
+	CMDTxn
+	CMDData
+	CMDLease
+	CMDInvalidate
+	CMDScan
+	CMDTTL
+	CMDExpire
+	CMDTouch
+	CMDHotKeys
+	CMDTrace
+	CMDSlowLog
+	CMDAuth
 )
 
+// String returns the name of the command
+func (c Command) String() string {
+	switch c {
+	case CMDSet:
+		return "SET"
+	case CMDGet:
+		return "GET"
+	case CMDDel:
+		return "DEL"
+	case CMDJoin:
+		return "JOIN"
+	case CMDClusterInfo:
+		return "CLUSTERINFO"
+	case CMDInvalidations:
+		return "INVALIDATIONS"
+	case CMDWatch:
+		return "WATCH"
+	case CMDIncr:
+		return "INCR"
+	case CMDSetIf:
+		return "SETIF"
+	case CMDDeleteIf:
+		return "DELETEIF"
+	case CMDTxn:
+		return "TXN"
+	case CMDData:
+		return "DATA"
+	case CMDLease:
+		return "LEASE"
+	case CMDInvalidate:
+		return "INVALIDATE"
+	case CMDScan:
+		return "SCAN"
+	case CMDTTL:
+		return "TTL"
+	case CMDExpire:
+		return "EXPIRE"
+	case CMDTouch:
+		return "TOUCH"
+	case CMDHotKeys:
+		return "HOTKEYS"
+	case CMDTrace:
+		return "TRACE"
+	case CMDSlowLog:
+		return "SLOWLOG"
+	case CMDAuth:
+		return "AUTH"
+	default:
+		return "NONE"
+	}
+}
+
 // Status is a byte representing the status of a command
 type Status byte
 
@@ -32,6 +102,20 @@ func (s Status) String() string {
 		return "NOTFOUND"
 	case StatusExpired:
 		return "EXPIRED"
+	case StatusCompacted:
+		return "COMPACTED"
+	case StatusNotInteger:
+		return "NOTINTEGER"
+	case StatusOverflow:
+		return "OVERFLOW"
+	case StatusConflict:
+		return "CONFLICT"
+	case StatusWrongType:
+		return "WRONGTYPE"
+	case StatusBusy:
+		return "BUSY"
+	case StatusNoAuth:
+		return "NOAUTH"
 	default:
 		return "NONE"
 	}
@@ -43,6 +127,13 @@ const (
 	StatusError
 	StatusKeyNotFound
 	StatusExpired
+	StatusCompacted
+	StatusNotInteger
+	StatusOverflow
+	StatusConflict
+	StatusWrongType
+	StatusBusy
+	StatusNoAuth
 )
 
 // ResponseSet is a response to a set command
@@ -59,10 +150,34 @@ func (r *ResponseSet) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// ResponseStatus is a response that only carries a status
+type ResponseStatus struct {
+	Status Status
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseStatus) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseStatusResponse parses a status response from the reader
+func ParseStatusResponse(r io.Reader) (*ResponseStatus, error) {
+	resp := &ResponseStatus{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 // ResponseGet is a response to a get command
 type ResponseGet struct {
-	Status Status
-	Value  []byte
+	Status  Status
+	Value   []byte
+	Version uint64
 }
 
 // Bytes returns the byte representation of the response
@@ -79,6 +194,9 @@ func (r *ResponseGet) Bytes() []byte {
 	if err := binary.Write(buf, binary.LittleEndian, r.Value); err != nil {
 		return nil
 	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Version); err != nil {
+		return nil
+	}
 
 	return buf.Bytes()
 }
@@ -107,15 +225,22 @@ func ParseGetResponse(r io.Reader) (*ResponseGet, error) {
 	if _, err := io.ReadFull(r, resp.Value); err != nil {
 		return nil, err
 	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Version); err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
 
-// CommandSet is a command to set a key-value pair with a TTL
+// CommandSet is a command to set a key-value pair with a TTL in seconds. The
+// server that receives it resolves the TTL to At, a Unix time in
+// milliseconds, so every replica and replay applies the same expiry.
 type CommandSet struct {
 	Key   []byte
 	Value []byte
 	TTL   int
+	At    int64
+	Tags  [][]byte // Tags the key can be invalidated by
 }
 
 // Bytes returns the byte representation of the set command
@@ -144,6 +269,13 @@ func (c *CommandSet) Bytes() []byte {
 	if err := binary.Write(buf, binary.LittleEndian, int32(c.TTL)); err != nil {
 		return nil
 	}
+	if err := binary.Write(buf, binary.LittleEndian, c.At); err != nil {
+		return nil
+	}
+
+	if err := writeList(buf, c.Tags); err != nil {
+		return nil
+	}
 
 	return buf.Bytes()
 }
@@ -160,6 +292,42 @@ func ParseCommand(r io.Reader) (any, error) {
 		return parseSetCommand(r)
 	case CMDGet:
 		return parseGetCommand(r)
+	case CMDClusterInfo:
+		return &CommandClusterInfo{}, nil
+	case CMDInvalidations:
+		return &CommandInvalidations{}, nil
+	case CMDWatch:
+		return parseWatchCommand(r)
+	case CMDIncr:
+		return parseIncrCommand(r)
+	case CMDSetIf:
+		return parseSetIfCommand(r)
+	case CMDDeleteIf:
+		return parseDeleteIfCommand(r)
+	case CMDTxn:
+		return parseTxnCommand(r)
+	case CMDData:
+		return parseDataCommand(r)
+	case CMDLease:
+		return parseLeaseCommand(r)
+	case CMDInvalidate:
+		return parseInvalidateCommand(r)
+	case CMDScan:
+		return parseScanCommand(r)
+	case CMDTTL:
+		return parseTTLCommand(r)
+	case CMDExpire:
+		return parseExpireCommand(r)
+	case CMDTouch:
+		return parseTouchCommand(r)
+	case CMDHotKeys:
+		return parseHotKeysCommand(r)
+	case CMDTrace:
+		return parseTracedCommand(r)
+	case CMDSlowLog:
+		return parseSlowLogCommand(r)
+	case CMDAuth:
+		return parseAuthCommand(r)
 	default:
 		return nil, fmt.Errorf("invalid command")
 	}
@@ -167,7 +335,8 @@ func ParseCommand(r io.Reader) (any, error) {
 
 // CommandGet is a command to get a key
 type CommandGet struct {
-	Key []byte
+	Key    []byte
+	Strong bool // Read from the leader through raft, followers forward it
 }
 
 // Bytes returns the byte representation of the get command
@@ -184,6 +353,9 @@ func (c *CommandGet) Bytes() []byte {
 	if err := binary.Write(buf, binary.LittleEndian, c.Key); err != nil {
 		return nil
 	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Strong); err != nil {
+		return nil
+	}
 
 	return buf.Bytes()
 }
@@ -215,6 +387,15 @@ func parseSetCommand(r io.Reader) (*CommandSet, error) {
 		return nil, err
 	}
 	cmd.TTL = int(ttl)
+	if err := binary.Read(r, binary.LittleEndian, &cmd.At); err != nil {
+		return nil, err
+	}
+
+	tags, err := readList(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Tags = tags
 
 	return cmd, nil
 }
@@ -231,6 +412,33 @@ func parseGetCommand(r io.Reader) (*CommandGet, error) {
 	if _, err := io.ReadFull(r, cmd.Key); err != nil {
 		return nil, err
 	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Strong); err != nil {
+		return nil, err
+	}
 
 	return cmd, nil
 }
+
+// writeBytes writes a length prefixed byte slice to the buffer
+func writeBytes(buf *bytes.Buffer, b []byte) error {
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(b))); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.LittleEndian, b)
+}
+
+// readBytes reads a length prefixed byte slice from the reader
+func readBytes(r io.Reader) ([]byte, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/dhyanio/discache/trace"
 	"github.com/stretchr/testify/assert"
 )
 
 // TestParseGetCommand tests the ParseCommand function with a CommandGet
 func TestParseGetCommand(t *testing.T) {
 	cmd := &CommandGet{
-		Key: []byte("Foo"),
+		Key:    []byte("Foo"),
+		Strong: true,
 	}
 
 	r := bytes.NewReader(cmd.Bytes())
@@ -20,6 +22,7 @@ func TestParseGetCommand(t *testing.T) {
 	assert.NotNil(t, pcmd)
 	assert.IsType(t, &CommandGet{}, pcmd)
 	assert.Equal(t, cmd.Key, pcmd.(*CommandGet).Key)
+	assert.True(t, pcmd.(*CommandGet).Strong)
 }
 
 // TestParseSetCommand tests the ParseCommand function with a CommandSet
@@ -28,6 +31,8 @@ func TestParseSetCommand(t *testing.T) {
 		Key:   []byte("Foo"),
 		Value: []byte("Bar"),
 		TTL:   2,
+		At:    1700000002000,
+		Tags:  [][]byte{[]byte("product:1")},
 	}
 
 	r := bytes.NewReader(cmd.Bytes())
@@ -39,6 +44,8 @@ func TestParseSetCommand(t *testing.T) {
 	assert.Equal(t, cmd.Key, pcmd.(*CommandSet).Key)
 	assert.Equal(t, cmd.Value, pcmd.(*CommandSet).Value)
 	assert.Equal(t, cmd.TTL, pcmd.(*CommandSet).TTL)
+	assert.Equal(t, cmd.At, pcmd.(*CommandSet).At)
+	assert.Equal(t, cmd.Tags, pcmd.(*CommandSet).Tags)
 }
 
 // TestParseCommandWithInvalidData tests the ParseCommand function with invalid data
@@ -64,3 +71,259 @@ func BenchmarkParseCommand(b *testing.B) {
 		_, _ = ParseCommand(r)
 	}
 }
+
+// TestClusterInfoResponseRoundTrip tests encoding and parsing a ResponseClusterInfo
+func TestClusterInfoResponseRoundTrip(t *testing.T) {
+	resp := &ResponseClusterInfo{
+		Status: StatusOK,
+		Leader: "127.0.0.1:9080",
+		Members: []Member{
+			{ID: "node1", Addr: "127.0.0.1:9080", Voter: true},
+			{ID: "node2", Addr: "127.0.0.2:9080", Voter: false},
+		},
+	}
+
+	presp, err := ParseClusterInfoResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	pcmd, err := ParseCommand(bytes.NewReader((&CommandClusterInfo{}).Bytes()))
+	assert.Nil(t, err)
+	assert.IsType(t, &CommandClusterInfo{}, pcmd)
+}
+
+// TestParseWatchCommand tests the ParseCommand function with a CommandWatch
+func TestParseWatchCommand(t *testing.T) {
+	cmd := &CommandWatch{
+		Key:       []byte("user:"),
+		Prefix:    true,
+		FromIndex: 42,
+	}
+
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	ev := &Event{Type: EventEvict, Index: 7, Key: []byte("user:1")}
+	pev, err := ParseEvent(bytes.NewReader(ev.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, ev, pev)
+}
+
+// TestParseIncrCommand tests the ParseCommand function with a CommandIncr
+func TestParseIncrCommand(t *testing.T) {
+	cmd := &CommandIncr{
+		Key:     []byte("hits"),
+		Delta:   -3,
+		Initial: 100,
+		TTL:     60,
+		At:      1700000060000,
+	}
+
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+}
+
+// TestParseConditionalCommands tests the ParseCommand function with CommandSetIf and CommandDeleteIf
+func TestParseConditionalCommands(t *testing.T) {
+	set := &CommandSetIf{
+		Key:     []byte("Foo"),
+		Value:   []byte("Bar"),
+		TTL:     5,
+		At:      1700000005000,
+		Cond:    CondVersion,
+		Version: 12,
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(set.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, set, pcmd)
+
+	del := &CommandDeleteIf{Key: []byte("Foo"), Version: 13}
+	pcmd, err = ParseCommand(bytes.NewReader(del.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, del, pcmd)
+}
+
+// TestParseTxnCommand tests the ParseCommand function with a CommandTxn and its response
+func TestParseTxnCommand(t *testing.T) {
+	cmd := &CommandTxn{
+		Checks: []TxnCheck{
+			{Key: []byte("user:1"), Cond: CondVersion, Version: 4},
+			{Key: []byte("email:a@b.c"), Cond: CondAbsent},
+		},
+		Ops: []TxnOp{
+			{Type: TxnSet, Key: []byte("user:1"), Value: []byte("a@b.c"), TTL: 30, At: 1700000030000},
+			{Type: TxnSet, Key: []byte("email:a@b.c"), Value: []byte("user:1")},
+			{Type: TxnDelete, Key: []byte("email:old@b.c"), Value: []byte{}},
+		},
+	}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseTxn{Status: StatusConflict, Version: 9, Key: []byte("user:1")}
+	presp, err := ParseTxnResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseDataCommand tests the ParseCommand function with a CommandData and its response
+func TestParseDataCommand(t *testing.T) {
+	cmd := &CommandData{
+		Op:   OpZAdd,
+		Key:  []byte("leaderboard"),
+		Args: [][]byte{FormatScore(1.5), []byte("alice"), FormatScore(-2), []byte("bob")},
+		TTL:  60,
+	}
+	assert.Nil(t, cmd.Validate())
+	assert.True(t, cmd.Op.Write())
+
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseData{Status: StatusOK, Int: 2, Values: [][]byte{[]byte("bob"), []byte("-2")}}
+	presp, err := ParseDataResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestDataCommandValidate tests that malformed data commands are rejected
+func TestDataCommandValidate(t *testing.T) {
+	invalid := []*CommandData{
+		{Op: OpNone, Key: []byte("k")},
+		{Op: OpHSet, Key: []byte("k"), Args: [][]byte{[]byte("field")}},
+		{Op: OpLPush, Key: []byte("k")},
+		{Op: OpLRange, Key: []byte("k"), Args: [][]byte{[]byte("0"), []byte("last")}},
+		{Op: OpZAdd, Key: []byte("k"), Args: [][]byte{[]byte("NaN"), []byte("alice")}},
+		{Op: OpZAdd, Key: []byte("k"), Args: [][]byte{[]byte("1"), []byte("alice"), []byte("2")}},
+	}
+	for _, cmd := range invalid {
+		assert.NotNil(t, cmd.Validate(), cmd.Op.String())
+	}
+}
+
+// TestParseLeaseCommand tests the ParseCommand function with a CommandLease and its response
+func TestParseLeaseCommand(t *testing.T) {
+	cmd := &CommandLease{Op: LeaseExpire, Name: []byte("nightly-job"), Token: 42, TTL: 1500, Renewed: 57}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseLease{Status: StatusConflict, Token: 42, TTL: 1500}
+	presp, err := ParseLeaseResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseInvalidateCommand tests the ParseCommand function with a CommandInvalidate
+func TestParseInvalidateCommand(t *testing.T) {
+	cmd := &CommandInvalidate{By: InvalidateByPrefix, Match: []byte("product:1:")}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseInvalidate{Status: StatusOK, Count: 12}
+	presp, err := ParseInvalidateResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseScanCommand tests the ParseCommand function with a CommandScan and its response
+func TestParseScanCommand(t *testing.T) {
+	cmd := &CommandScan{Cursor: []byte("user:41"), Prefix: []byte("user:"), Match: []byte("user:4*"), Count: 50}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseScan{Status: StatusOK, Cursor: []byte("user:43"), Keys: [][]byte{[]byte("user:42"), []byte("user:43")}}
+	presp, err := ParseScanResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseExpiryCommands tests the ParseCommand function with the TTL, expire and touch commands
+func TestParseExpiryCommands(t *testing.T) {
+	cmds := []any{
+		&CommandTTL{Key: []byte("session:1")},
+		&CommandExpire{Key: []byte("session:1"), TTL: 30000, At: 1700000030000, Sliding: true},
+		&CommandTouch{Keys: [][]byte{[]byte("session:1"), []byte("session:2")}, At: 1700000000000},
+	}
+	for _, cmd := range cmds {
+		pcmd, err := ParseCommand(bytes.NewReader(cmd.(interface{ Bytes() []byte }).Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, cmd, pcmd)
+	}
+
+	resp := &ResponseTTL{Status: StatusOK, TTL: 29500, Sliding: 30000}
+	presp, err := ParseTTLResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+
+	touch := &ResponseTouch{Status: StatusOK, Count: 2}
+	ptouch, err := ParseTouchResponse(bytes.NewReader(touch.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, touch, ptouch)
+}
+
+// TestParseHotKeysCommand tests the ParseCommand function with a CommandHotKeys and its response
+func TestParseHotKeysCommand(t *testing.T) {
+	cmd := &CommandHotKeys{Count: 10}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseHotKeys{Status: StatusOK, Keys: []HotKey{
+		{Key: []byte("user:1"), Count: 1200, Error: 40},
+		{Key: []byte("user:2"), Count: 300},
+	}}
+	presp, err := ParseHotKeysResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseTracedCommand tests the ParseCommand function with a command preceded by a trace header
+func TestParseTracedCommand(t *testing.T) {
+	sc := trace.SpanContext{
+		TraceID: trace.TraceID{1, 2, 3},
+		SpanID:  trace.SpanID{4, 5, 6},
+		Sampled: true,
+	}
+	cmd := &CommandGet{Key: []byte("Foo")}
+
+	pcmd, err := ParseCommand(bytes.NewReader(WithTrace(sc, cmd.Bytes())))
+	assert.Nil(t, err)
+	assert.Equal(t, &CommandTraced{Context: sc, Command: cmd}, pcmd)
+
+	// Without a valid context the command is sent as is
+	assert.Equal(t, cmd.Bytes(), WithTrace(trace.SpanContext{}, cmd.Bytes()))
+
+	nested := WithTrace(sc, WithTrace(sc, cmd.Bytes()))
+	_, err = ParseCommand(bytes.NewReader(nested))
+	assert.NotNil(t, err)
+}
+
+// TestParseSlowLogCommand tests the ParseCommand function with a CommandSlowLog and its response
+func TestParseSlowLogCommand(t *testing.T) {
+	cmd := &CommandSlowLog{Op: SlowLogGet, Count: 10}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+
+	resp := &ResponseSlowLog{Status: StatusOK, Entries: []SlowLogEntry{
+		{ID: 2, Time: 1700000000000000, Duration: 25000, Apply: 20000, Client: "127.0.0.1:5000", Command: "SET", Key: []byte("user:1")},
+		{ID: 1, Time: 1699999999000000, Duration: 12000, Client: "127.0.0.1:5001", Command: "SCAN", Key: []byte{}},
+	}}
+	presp, err := ParseSlowLogResponse(bytes.NewReader(resp.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, resp, presp)
+}
+
+// TestParseAuthCommand tests the ParseCommand function with a CommandAuth
+func TestParseAuthCommand(t *testing.T) {
+	cmd := &CommandAuth{Password: []byte("secret"), Peer: true, Client: "10.0.0.1"}
+	pcmd, err := ParseCommand(bytes.NewReader(cmd.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, pcmd)
+}
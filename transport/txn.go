@@ -0,0 +1,193 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxTxnSize is the most checks or operations a transaction may carry
+const maxTxnSize = 1024
+
+// TxnCheck is a version guard on a key evaluated before a transaction applies
+type TxnCheck struct {
+	Key     []byte
+	Cond    Condition
+	Version uint64
+}
+
+// TxnOpType is a byte representing the kind of a transaction operation
+type TxnOpType byte
+
+const (
+	TxnSet TxnOpType = iota + 1
+	TxnDelete
+)
+
+// TxnOp is a write applied by a transaction. Value, TTL and At, the TTL
+// resolved like the one of CommandSet, are only used by TxnSet.
+type TxnOp struct {
+	Type  TxnOpType
+	Key   []byte
+	Value []byte
+	TTL   int
+	At    int64
+}
+
+// CommandTxn is a command to apply Ops atomically if every one of Checks holds
+type CommandTxn struct {
+	Checks []TxnCheck
+	Ops    []TxnOp
+}
+
+// Bytes returns the byte representation of the transaction command
+func (c *CommandTxn) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDTxn); err != nil {
+		return nil
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(c.Checks))); err != nil {
+		return nil
+	}
+	for _, check := range c.Checks {
+		if err := writeBytes(buf, check.Key); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, check.Cond); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, check.Version); err != nil {
+			return nil
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(c.Ops))); err != nil {
+		return nil
+	}
+	for _, op := range c.Ops {
+		if err := binary.Write(buf, binary.LittleEndian, op.Type); err != nil {
+			return nil
+		}
+		if err := writeBytes(buf, op.Key); err != nil {
+			return nil
+		}
+		if err := writeBytes(buf, op.Value); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, int32(op.TTL)); err != nil {
+			return nil
+		}
+		if err := binary.Write(buf, binary.LittleEndian, op.At); err != nil {
+			return nil
+		}
+	}
+	return buf.Bytes()
+}
+
+// parseTxnCommand parses a transaction command from the reader
+func parseTxnCommand(r io.Reader) (*CommandTxn, error) {
+	cmd := &CommandTxn{}
+
+	count, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := int32(0); i < count; i++ {
+		check := TxnCheck{}
+		if check.Key, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &check.Cond); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &check.Version); err != nil {
+			return nil, err
+		}
+		cmd.Checks = append(cmd.Checks, check)
+	}
+
+	count, err = readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := int32(0); i < count; i++ {
+		op := TxnOp{}
+		if err := binary.Read(r, binary.LittleEndian, &op.Type); err != nil {
+			return nil, err
+		}
+		if op.Type != TxnSet && op.Type != TxnDelete {
+			return nil, fmt.Errorf("invalid transaction operation %d", op.Type)
+		}
+		if op.Key, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if op.Value, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		var ttl int32
+		if err := binary.Read(r, binary.LittleEndian, &ttl); err != nil {
+			return nil, err
+		}
+		op.TTL = int(ttl)
+		if err := binary.Read(r, binary.LittleEndian, &op.At); err != nil {
+			return nil, err
+		}
+		cmd.Ops = append(cmd.Ops, op)
+	}
+	return cmd, nil
+}
+
+// readCount reads the number of checks or operations of a transaction
+func readCount(r io.Reader) (int32, error) {
+	var count int32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return 0, err
+	}
+	if count < 0 || count > maxTxnSize {
+		return 0, fmt.Errorf("invalid transaction size %d", count)
+	}
+	return count, nil
+}
+
+// ResponseTxn is a response to a transaction. On success Version is the
+// version of every key written. On conflict Key is the first key whose check
+// failed and Version its current version.
+type ResponseTxn struct {
+	Status  Status
+	Version uint64
+	Key     []byte
+}
+
+// Bytes returns the byte representation of the response
+func (r *ResponseTxn) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, r.Status); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, r.Version); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, r.Key); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseTxnResponse parses a transaction response from the reader
+func ParseTxnResponse(r io.Reader) (*ResponseTxn, error) {
+	resp := &ResponseTxn{}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Status); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &resp.Version); err != nil {
+		return nil, err
+	}
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Key = key
+	return resp, nil
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// EventType is a byte representing the kind of keyspace change
+type EventType byte
+
+const (
+	EventSet EventType = iota + 1
+	EventDelete
+	EventExpire
+	EventEvict
+	EventTTL // The expiry of a key was changed by EXPIRE, EXPIREAT or PERSIST
+)
+
+// String returns the string representation of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "SET"
+	case EventDelete:
+		return "DELETE"
+	case EventExpire:
+		return "EXPIRE"
+	case EventEvict:
+		return "EVICT"
+	case EventTTL:
+		return "TTL"
+	default:
+		return "NONE"
+	}
+}
+
+// Local reports whether events of the type are produced by each replica on
+// its own, as expirations and evictions are, instead of by applying a raft
+// log entry. Their index is only the last one applied, so they differ between
+// replicas and are not replayed when a watch resumes.
+func (t EventType) Local() bool {
+	return t == EventExpire || t == EventEvict
+}
+
+// Event is a keyspace change applied by the raft FSM. Index is the raft log
+// index that caused it and is shared by all events of the same log entry.
+type Event struct {
+	Type  EventType
+	Index uint64
+	Key   []byte
+}
+
+// Bytes returns the byte representation of the event
+func (e *Event) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, e.Type); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, e.Index); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, e.Key); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ParseEvent parses an event frame from the reader
+func ParseEvent(r io.Reader) (*Event, error) {
+	ev := &Event{}
+	if err := binary.Read(r, binary.LittleEndian, &ev.Type); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &ev.Index); err != nil {
+		return nil, err
+	}
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	ev.Key = key
+	return ev, nil
+}
+
+// CommandWatch is a command to stream the events of a key or key prefix. The
+// server acknowledges it with a ResponseStatus and then streams Event frames
+// on the connection until it is closed. A non-zero FromIndex replays the
+// retained events starting at that raft index.
+type CommandWatch struct {
+	Key       []byte
+	Prefix    bool
+	FromIndex uint64
+}
+
+// Bytes returns the byte representation of the watch command
+func (c *CommandWatch) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, CMDWatch); err != nil {
+		return nil
+	}
+	if err := writeBytes(buf, c.Key); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.Prefix); err != nil {
+		return nil
+	}
+	if err := binary.Write(buf, binary.LittleEndian, c.FromIndex); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// parseWatchCommand parses a watch command from the reader
+func parseWatchCommand(r io.Reader) (*CommandWatch, error) {
+	cmd := &CommandWatch{}
+
+	key, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Key = key
+
+	if err := binary.Read(r, binary.LittleEndian, &cmd.Prefix); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &cmd.FromIndex); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
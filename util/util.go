@@ -24,6 +24,43 @@ func (e *KeyNotFoundError) Error() string {
 	return fmt.Sprintf("key %s not found", e.Key)
 }
 
+// NotIntegerError is an error type for values that are not integers
+type NotIntegerError struct {
+	Key string
+}
+
+func (e *NotIntegerError) Error() string {
+	return fmt.Sprintf("value of key %s is not an integer", e.Key)
+}
+
+// OverflowError is an error type for increments that overflow an int64
+type OverflowError struct {
+	Key string
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("increment of key %s would overflow", e.Key)
+}
+
+// ConflictError is an error type for conditional writes whose condition failed
+type ConflictError struct {
+	Key     string
+	Version uint64 // Current version of the key, 0 if it does not exist
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict on key %s at version %d", e.Key, e.Version)
+}
+
+// WrongTypeError is an error type for operations on a key holding another kind of value
+type WrongTypeError struct {
+	Key string
+}
+
+func (e *WrongTypeError) Error() string {
+	return fmt.Sprintf("key %s holds the wrong kind of value", e.Key)
+}
+
 // randomByte return random bytes
 func randomByte(n int) []byte {
 	buf := make([]byte, n)